@@ -0,0 +1,449 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// dohlictl is a command-line tool for administration of dohli.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/allowlist"
+	"github.com/dimkr/dohli/pkg/blocks"
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/dns"
+	"github.com/dimkr/dohli/pkg/feed"
+	"github.com/dimkr/dohli/pkg/history"
+	"github.com/dimkr/dohli/pkg/hosts"
+	"github.com/dimkr/dohli/pkg/nod"
+	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const timeout = 30 * time.Second
+
+const usage = `Usage: dohlictl COMMAND [ARGS]
+
+Commands:
+  blocked                List blocked domains
+  why DOMAIN             Show why a domain is blocked
+  stats                  Count blocked domains by source and category
+  dry-run                Show domains that would have been blocked
+  block DOMAIN           Block a domain
+  unblock DOMAIN         Unblock a domain
+  cache DOMAIN [TYPE]    Show cached responses for a domain
+  flush PATTERN          Flush cached responses for domains matching a pattern
+  queue                  Show the number of queued domain access messages
+  export                 Export blocked domains as JSON
+  import [FILE]          Import blocked domains exported as JSON
+  versions               List blocklist versions
+  rollback VERSION       Roll back the blocklist to an earlier version
+  feeds                  Show the freshness of downloaded feeds
+  new-domains [DAY]      Count newly observed domains by day, or list them
+  alerts                 Print alerts as they are raised by workers
+`
+
+var errUsage = errors.New("bad usage")
+
+var c *cache.Cache
+var q *queue.Queue
+var al *allowlist.Allowlist
+var registry *blocks.Registry
+var hist *history.History
+var tracker *nod.Tracker
+
+type whyResponse struct {
+	Domain      string         `json:"domain"`
+	Allowlisted bool           `json:"allowlisted"`
+	Block       *blocks.Record `json:"block"`
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func parseDomain(arg string) (string, error) {
+	domain, err := allowlist.ParseRule(arg)
+	if err != nil || strings.HasPrefix(domain, "*") {
+		return "", fmt.Errorf("bad domain: %s", arg)
+	}
+
+	return domain, nil
+}
+
+func parseType(arg string) (dnsmessage.Type, error) {
+	for _, requestType := range []dnsmessage.Type{
+		dnsmessage.TypeA,
+		dnsmessage.TypeNS,
+		dnsmessage.TypeCNAME,
+		dnsmessage.TypeSOA,
+		dnsmessage.TypePTR,
+		dnsmessage.TypeMX,
+		dnsmessage.TypeTXT,
+		dnsmessage.TypeAAAA,
+		dnsmessage.TypeSRV,
+	} {
+		if strings.EqualFold("Type"+arg, requestType.String()) {
+			return requestType, nil
+		}
+	}
+
+	return 0, fmt.Errorf("bad type: %s", arg)
+}
+
+func listBlocked(ctx context.Context) error {
+	domains := registry.Domains(ctx)
+	sort.Strings(domains)
+
+	for _, domain := range domains {
+		fmt.Println(domain)
+	}
+
+	return nil
+}
+
+func why(ctx context.Context, arg string) error {
+	domain, err := parseDomain(arg)
+	if err != nil {
+		return err
+	}
+
+	// subdomains of blocked zones are blocked too, although they have no
+	// record of their own
+	record := registry.Lookup(ctx, domain)
+	if record == nil {
+		record = registry.LookupZone(ctx, domain)
+	}
+
+	return printJSON(whyResponse{
+		Domain:      domain,
+		Allowlisted: al.Contains(ctx, domain),
+		Block:       record,
+	})
+}
+
+func showStats(ctx context.Context) error {
+	return printJSON(registry.Stats(ctx))
+}
+
+func showDryRunReport(ctx context.Context) error {
+	return printJSON(registry.DryRunReport(ctx))
+}
+
+func block(ctx context.Context, arg string) error {
+	domain, err := parseDomain(arg)
+	if err != nil {
+		return err
+	}
+
+	return registry.Block(ctx, domain, dnsmessage.TypeA, &verdict.Verdict{Source: blocks.SourceAdmin, Confidence: 1})
+}
+
+func unblock(ctx context.Context, arg string) error {
+	domain, err := parseDomain(arg)
+	if err != nil {
+		return err
+	}
+
+	registry.Unblock(ctx, domain)
+	return nil
+}
+
+func showCache(ctx context.Context, args []string) error {
+	domain, err := parseDomain(args[0])
+	if err != nil {
+		return err
+	}
+
+	entries := c.Entries(ctx, domain)
+
+	if len(args) > 1 {
+		requestType, err := parseType(args[1])
+		if err != nil {
+			return err
+		}
+
+		response := c.Get(ctx, domain, requestType)
+		if response == nil {
+			return fmt.Errorf("%s %s is not cached", domain, args[1])
+		}
+
+		entries = map[dnsmessage.Type][]byte{requestType: response}
+	}
+
+	summaries := map[string]*dns.Summary{}
+	for requestType, response := range entries {
+		summary, err := dns.Summarize(response)
+		if err != nil {
+			return err
+		}
+
+		summaries[strings.TrimPrefix(requestType.String(), "Type")] = summary
+	}
+
+	return printJSON(summaries)
+}
+
+func flush(ctx context.Context, pattern string) error {
+	fmt.Printf("Flushed %d cached responses\n", c.FlushMatching(ctx, pattern))
+	return nil
+}
+
+func showQueue() error {
+	n, err := q.Len()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(n)
+	return nil
+}
+
+func exportRecords(ctx context.Context) error {
+	records := registry.Records(ctx)
+	sort.Slice(records, func(i, j int) bool { return records[i].Domain < records[j].Domain })
+	return printJSON(records)
+}
+
+func importRecords(ctx context.Context, args []string) error {
+	var r io.Reader = os.Stdin
+
+	if len(args) > 0 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	var records []blocks.Record
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return err
+	}
+
+	for i := range records {
+		// records are stored under the normalized name, which is what
+		// queries are matched against
+		domain, err := parseDomain(records[i].Domain)
+		if err != nil {
+			return err
+		}
+		records[i].Domain = domain
+
+		if err := registry.Restore(ctx, &records[i]); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Imported %d blocked domains\n", len(records))
+	return nil
+}
+
+func listVersions(ctx context.Context) error {
+	var active string
+	if version := hist.Active(ctx); version != nil {
+		active = version.ID
+	}
+
+	for _, version := range hist.Versions(ctx) {
+		marker := " "
+		if version.ID == active {
+			marker = "*"
+		}
+
+		fmt.Printf("%s %s %s %8d +%d -%d\n", marker, version.ID, version.Created.Format(time.RFC3339), version.Count, version.Diff.Added, version.Diff.Removed)
+	}
+
+	return nil
+}
+
+func rollback(ctx context.Context, id string) error {
+	n, err := hist.Rollback(ctx, id, registry, hosts.Source)
+	if err != nil {
+		return err
+	}
+
+	if err := q.Publish(queue.EventsChannel, queue.RefreshEvent); err != nil {
+		return err
+	}
+
+	fmt.Printf("Rolled back to %s, unblocked %d domains\n", id, n)
+	return nil
+}
+
+func showFeeds(ctx context.Context) error {
+	for _, status := range feed.Statuses(ctx, c) {
+		age := "never"
+		if !status.Updated.IsZero() {
+			age = time.Since(status.Updated).Round(time.Second).String() + " ago"
+		}
+
+		fmt.Printf("%-12s %8d %s", status.Name, status.Entries, age)
+		if status.Error != "" {
+			fmt.Printf(" (last download failed: %s)", status.Error)
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
+
+func showNewDomains(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		for _, day := range tracker.Days(ctx) {
+			fmt.Printf("%s %8d\n", day.Day, day.Domains)
+		}
+
+		return nil
+	}
+
+	day, err := time.Parse(nod.DayFormat, args[0])
+	if err != nil {
+		return fmt.Errorf("bad day: %s", args[0])
+	}
+
+	for _, domain := range tracker.Domains(ctx, day) {
+		fmt.Printf("%s %s\n", domain.FirstSeen.Format(time.RFC3339), domain.Domain)
+	}
+
+	return nil
+}
+
+func watchAlerts() error {
+	alerts, err := q.Subscribe(queue.AlertsChannel)
+	if err != nil {
+		return err
+	}
+
+	for alert := range alerts {
+		fmt.Println(alert)
+	}
+
+	return nil
+}
+
+func run(ctx context.Context, command string, args []string) error {
+	switch {
+	case command == "blocked" && len(args) == 0:
+		return listBlocked(ctx)
+
+	case command == "why" && len(args) == 1:
+		return why(ctx, args[0])
+
+	case command == "stats" && len(args) == 0:
+		return showStats(ctx)
+
+	case command == "dry-run" && len(args) == 0:
+		return showDryRunReport(ctx)
+
+	case command == "block" && len(args) == 1:
+		return block(ctx, args[0])
+
+	case command == "unblock" && len(args) == 1:
+		return unblock(ctx, args[0])
+
+	case command == "cache" && (len(args) == 1 || len(args) == 2):
+		return showCache(ctx, args)
+
+	case command == "flush" && len(args) == 1:
+		return flush(ctx, args[0])
+
+	case command == "queue" && len(args) == 0:
+		return showQueue()
+
+	case command == "export" && len(args) == 0:
+		return exportRecords(ctx)
+
+	case command == "import" && len(args) <= 1:
+		return importRecords(ctx, args)
+
+	case command == "versions" && len(args) == 0:
+		return listVersions(ctx)
+
+	case command == "rollback" && len(args) == 1:
+		return rollback(ctx, args[0])
+
+	case command == "feeds" && len(args) == 0:
+		return showFeeds(ctx)
+
+	case command == "new-domains" && len(args) <= 1:
+		return showNewDomains(ctx, args)
+
+	case command == "alerts" && len(args) == 0:
+		return watchAlerts()
+	}
+
+	return errUsage
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+
+	if c, err = cache.OpenCache(&cache.RedisBackend{}); err != nil {
+		panic(err)
+	}
+
+	if q, err = queue.OpenQueue(); err != nil {
+		panic(err)
+	}
+
+	al = allowlist.OpenAllowlist(c)
+	registry = blocks.OpenRegistry(c)
+	hist = history.OpenHistory(c)
+	tracker = nod.OpenTracker(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := run(ctx, flag.Arg(0), flag.Args()[1:]); err != nil {
+		if err == errUsage {
+			flag.Usage()
+			os.Exit(2)
+		}
+
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
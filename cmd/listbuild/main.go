@@ -0,0 +1,81 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// listbuild compiles a domain blocklist from the sources listed in a manifest.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/blocklist"
+	"github.com/dimkr/dohli/pkg/fetch"
+)
+
+const timeout = 10 * time.Minute
+
+func main() {
+	manifestPath := flag.String("manifest", "lists.json", "Manifest path")
+	outputPath := flag.String("o", "hosts.block", "Output path")
+	format := flag.String("format", "table", "Output format (table or text)")
+	bloomBitsPerKey := flag.Int("bloom", blocklist.DefaultBloomBitsPerKey, "Bloom filter bits per rule, in the table format (0 to disable)")
+	flag.Parse()
+
+	f, err := os.Open(*manifestPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	m, err := blocklist.LoadManifest(f)
+	f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *format != "table" && *format != "text" {
+		log.Fatalf("Bad format: %s", *format)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	artifact, err := blocklist.Build(ctx, m, &fetch.Fetcher{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch *format {
+	case "table":
+		err = artifact.WriteTableFile(*outputPath, *bloomBitsPerKey)
+
+	case "text":
+		err = artifact.WriteFile(*outputPath)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Wrote %d rules from %d sources to %s", len(artifact.Rules), len(artifact.Sources), *outputPath)
+}
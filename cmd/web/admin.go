@@ -0,0 +1,389 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/allowlist"
+	"github.com/dimkr/dohli/pkg/blocks"
+	"github.com/dimkr/dohli/pkg/dns"
+	"github.com/dimkr/dohli/pkg/feed"
+	"github.com/dimkr/dohli/pkg/history"
+	"github.com/dimkr/dohli/pkg/hosts"
+	"github.com/dimkr/dohli/pkg/nod"
+	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const adminPrefix = "/admin/"
+
+var adminToken string
+var registry *blocks.Registry
+var hist *history.History
+var tracker *nod.Tracker
+
+var adminVerdict = verdict.Verdict{Source: blocks.SourceAdmin, Confidence: 1}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type statusResponse struct {
+	Status string `json:"status"`
+}
+
+type rollbackResponse struct {
+	Version   string `json:"version"`
+	Unblocked int    `json:"unblocked"`
+}
+
+type domainResponse struct {
+	Domain      string                  `json:"domain"`
+	Allowlisted bool                    `json:"allowlisted"`
+	Block       *blocks.Record          `json:"block"`
+	Cache       map[string]*dns.Summary `json:"cache"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{Error: msg})
+}
+
+func writeOK(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, statusResponse{Status: "ok"})
+}
+
+// parseDomain validates a domain name passed to the admin API.
+func parseDomain(w http.ResponseWriter, arg string) (string, bool) {
+	domain, err := allowlist.ParseRule(arg)
+	if err != nil || strings.HasPrefix(domain, "*") {
+		writeError(w, http.StatusBadRequest, "Bad domain")
+		return "", false
+	}
+
+	return domain, true
+}
+
+func handleDomain(w http.ResponseWriter, r *http.Request, arg string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Bad method")
+		return
+	}
+
+	domain, ok := parseDomain(w, arg)
+	if !ok {
+		return
+	}
+
+	response := domainResponse{
+		Domain:      domain,
+		Allowlisted: al.Contains(r.Context(), domain),
+		Block:       registry.Lookup(r.Context(), domain),
+		Cache:       map[string]*dns.Summary{},
+	}
+
+	for requestType, cached := range c.Entries(r.Context(), domain) {
+		if summary, err := dns.Summarize(cached); err == nil {
+			response.Cache[strings.TrimPrefix(requestType.String(), "Type")] = summary
+		}
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func handleBlocks(w http.ResponseWriter, r *http.Request, arg string) {
+	if arg == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Bad method")
+			return
+		}
+
+		domains := registry.Domains(r.Context())
+		sort.Strings(domains)
+		writeJSON(w, http.StatusOK, domains)
+		return
+	}
+
+	domain, ok := parseDomain(w, arg)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if record := registry.Lookup(r.Context(), domain); record != nil {
+			writeJSON(w, http.StatusOK, record)
+		} else {
+			writeError(w, http.StatusNotFound, "Not blocked")
+		}
+
+	case http.MethodPut:
+		if err := registry.Block(r.Context(), domain, dnsmessage.TypeA, &adminVerdict); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, registry.Lookup(r.Context(), domain))
+
+	case http.MethodDelete:
+		registry.Unblock(r.Context(), domain)
+		writeOK(w)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Bad method")
+	}
+}
+
+func handleAllowlist(w http.ResponseWriter, r *http.Request, arg string) {
+	if arg == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Bad method")
+			return
+		}
+
+		rules := al.Rules(r.Context())
+		sort.Strings(rules)
+		writeJSON(w, http.StatusOK, rules)
+		return
+	}
+
+	rule, err := allowlist.ParseRule(arg)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if err := al.Add(r.Context(), rule); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		writeOK(w)
+
+	case http.MethodDelete:
+		if err := al.Remove(r.Context(), rule); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		writeOK(w)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Bad method")
+	}
+}
+
+func handleCache(w http.ResponseWriter, r *http.Request, arg string) {
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "Bad method")
+		return
+	}
+
+	// *.example.com flushes all subdomains of example.com
+	rule, err := allowlist.ParseRule(arg)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad domain")
+		return
+	}
+
+	if strings.HasPrefix(rule, "*.") {
+		c.FlushSuffix(r.Context(), rule[2:])
+	} else {
+		c.Flush(r.Context(), rule)
+	}
+
+	writeOK(w)
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Bad method")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, registry.Stats(r.Context()))
+}
+
+func handleDryRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Bad method")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, registry.DryRunReport(r.Context()))
+}
+
+func handleRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Bad method")
+		return
+	}
+
+	if err := q.Publish(queue.EventsChannel, queue.RefreshEvent); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeOK(w)
+}
+
+func handleVersions(w http.ResponseWriter, r *http.Request, arg string) {
+	if arg == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "Bad method")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, hist.Versions(r.Context()))
+		return
+	}
+
+	id := strings.TrimSuffix(arg, "/rollback")
+	if id != arg {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Bad method")
+			return
+		}
+
+		n, err := hist.Rollback(r.Context(), id, registry, hosts.Source)
+		if err == history.ErrNoSuchVersion {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// workers replace their blocklist with the selected version
+		if err := q.Publish(queue.EventsChannel, queue.RefreshEvent); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, rollbackResponse{Version: id, Unblocked: n})
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Bad method")
+		return
+	}
+
+	if version := hist.Get(r.Context(), id); version != nil {
+		writeJSON(w, http.StatusOK, version)
+	} else {
+		writeError(w, http.StatusNotFound, history.ErrNoSuchVersion.Error())
+	}
+}
+
+func handleFeeds(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Bad method")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, feed.Statuses(r.Context(), c))
+}
+
+func handleNewDomains(w http.ResponseWriter, r *http.Request, arg string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Bad method")
+		return
+	}
+
+	if arg == "" {
+		writeJSON(w, http.StatusOK, tracker.Days(r.Context()))
+		return
+	}
+
+	day, err := time.Parse(nod.DayFormat, arg)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad day")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tracker.Domains(r.Context(), day))
+}
+
+func handleAdmin(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(adminToken)) != 1 {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	resource := strings.TrimPrefix(r.URL.Path, adminPrefix)
+	var arg string
+	if i := strings.IndexByte(resource, '/'); i != -1 {
+		resource, arg = resource[:i], resource[i+1:]
+	}
+
+	switch resource {
+	case "domains":
+		handleDomain(w, r, arg)
+
+	case "blocks":
+		handleBlocks(w, r, arg)
+
+	case "allowlist":
+		handleAllowlist(w, r, arg)
+
+	case "cache":
+		handleCache(w, r, arg)
+
+	case "stats":
+		handleStats(w, r)
+
+	case "dry-run":
+		handleDryRun(w, r)
+
+	case "refresh":
+		handleRefresh(w, r)
+
+	case "versions":
+		handleVersions(w, r, arg)
+
+	case "feeds":
+		handleFeeds(w, r)
+
+	case "new-domains":
+		handleNewDomains(w, r, arg)
+
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
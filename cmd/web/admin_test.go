@@ -0,0 +1,300 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/allowlist"
+	"github.com/dimkr/dohli/pkg/blocks"
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/history"
+	"github.com/dimkr/dohli/pkg/nod"
+	"github.com/dimkr/dohli/pkg/verdict"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const testToken = "secret"
+
+func openAdmin(t *testing.T) *httptest.Server {
+	var err error
+	if c, err = cache.OpenCache(&cache.MemoryBackend{}); err != nil {
+		t.Fatal(err)
+	}
+
+	al = allowlist.OpenAllowlist(c)
+	registry = blocks.OpenRegistry(c)
+	al.OnAdd = registry.UnblockRule
+	hist = history.OpenHistory(c)
+	tracker = nod.OpenTracker(c)
+
+	adminToken = testToken
+
+	return httptest.NewServer(newMux())
+}
+
+// request sends a request to the admin API and decodes the JSON response
+func request(t *testing.T, server *httptest.Server, method, path, token string, v interface{}) int {
+	req, err := http.NewRequest(method, server.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return resp.StatusCode
+}
+
+func TestAuth(t *testing.T) {
+	server := openAdmin(t)
+	defer server.Close()
+
+	for token, expected := range map[string]int{
+		"":                   http.StatusUnauthorized,
+		"wrong":              http.StatusUnauthorized,
+		testToken + "suffix": http.StatusUnauthorized,
+		testToken:            http.StatusOK,
+	} {
+		if status := request(t, server, http.MethodGet, "/admin/stats", token, nil); status != expected {
+			t.Error(token, status)
+		}
+	}
+
+	// the token must be passed as a bearer token
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/admin/stats", nil)
+	req.Header.Set("Authorization", testToken)
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Error(resp, err)
+	} else {
+		resp.Body.Close()
+	}
+}
+
+func TestAuthDisabled(t *testing.T) {
+	openAdmin(t).Close()
+
+	adminToken = ""
+	server := httptest.NewServer(newMux())
+	defer server.Close()
+
+	if status := request(t, server, http.MethodGet, "/admin/stats", "", nil); status != http.StatusNotFound {
+		t.Error(status)
+	}
+}
+
+func TestRouting(t *testing.T) {
+	server := openAdmin(t)
+	defer server.Close()
+
+	for path, expected := range map[string]int{
+		"/admin/":               http.StatusNotFound,
+		"/admin/nothing":        http.StatusNotFound,
+		"/admin/stats":          http.StatusOK,
+		"/admin/dry-run":        http.StatusOK,
+		"/admin/feeds":          http.StatusOK,
+		"/admin/versions":       http.StatusOK,
+		"/admin/versions/none":  http.StatusNotFound,
+		"/admin/new-domains":    http.StatusOK,
+		"/admin/new-domains/x":  http.StatusBadRequest,
+		"/admin/blocks/a..b":    http.StatusBadRequest,
+		"/admin/domains/*.a.b":  http.StatusBadRequest,
+		"/admin/blocks/example": http.StatusNotFound,
+	} {
+		if status := request(t, server, http.MethodGet, path, testToken, nil); status != expected {
+			t.Error(path, status)
+		}
+	}
+
+	for _, path := range []string{"/admin/stats", "/admin/refresh", "/admin/domains/example.com", "/admin/cache/example.com", "/admin/versions/none/rollback"} {
+		if status := request(t, server, http.MethodPatch, path, testToken, nil); status != http.StatusMethodNotAllowed {
+			t.Error(path, status)
+		}
+	}
+}
+
+func TestBlocks(t *testing.T) {
+	server := openAdmin(t)
+	defer server.Close()
+
+	var record blocks.Record
+	if status := request(t, server, http.MethodPut, "/admin/blocks/ads.example.com", testToken, &record); status != http.StatusOK || record.Domain != "ads.example.com" || record.Source != blocks.SourceAdmin {
+		t.Error(status, record)
+	}
+
+	var domains []string
+	if status := request(t, server, http.MethodGet, "/admin/blocks", testToken, &domains); status != http.StatusOK || len(domains) != 1 || domains[0] != "ads.example.com" {
+		t.Error(status, domains)
+	}
+
+	var response domainResponse
+	if status := request(t, server, http.MethodGet, "/admin/domains/ads.example.com", testToken, &response); status != http.StatusOK || response.Block == nil || response.Allowlisted || response.Cache["A"] == nil {
+		t.Error(status, response)
+	}
+
+	if status := request(t, server, http.MethodDelete, "/admin/blocks/ads.example.com", testToken, nil); status != http.StatusOK {
+		t.Error(status)
+	}
+
+	if registry.Lookup(context.Background(), "ads.example.com") != nil || c.Get(context.Background(), "ads.example.com", dnsmessage.TypeA) != nil {
+		t.Error()
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	server := openAdmin(t)
+	defer server.Close()
+
+	registry.Block(context.Background(), "example.com", dnsmessage.TypeA, &verdict.Verdict{Source: "hosts", Confidence: 1})
+
+	if status := request(t, server, http.MethodPut, "/admin/allowlist/example.com", testToken, nil); status != http.StatusOK {
+		t.Error(status)
+	}
+
+	// the block is removed with the blocked response
+	if registry.Lookup(context.Background(), "example.com") != nil || !al.Contains(context.Background(), "example.com") {
+		t.Error()
+	}
+
+	registry.Block(context.Background(), "ads.example.org", dnsmessage.TypeA, &verdict.Verdict{Source: "hosts", Confidence: 1})
+	registry.BlockZone(context.Background(), "tunnel.example.org", &verdict.Verdict{Source: "tunnel", Confidence: 1})
+
+	if status := request(t, server, http.MethodPut, "/admin/allowlist/*.example.org", testToken, nil); status != http.StatusOK {
+		t.Error(status)
+	}
+
+	// so are the blocks of subdomains, when the rule is a wildcard
+	if domains := registry.Domains(context.Background()); len(domains) != 0 {
+		t.Error(domains)
+	}
+
+	var rules []string
+	if status := request(t, server, http.MethodGet, "/admin/allowlist", testToken, &rules); status != http.StatusOK || len(rules) != 2 || rules[0] != "*.example.org" || rules[1] != "example.com" {
+		t.Error(status, rules)
+	}
+
+	if status := request(t, server, http.MethodDelete, "/admin/allowlist/example.com", testToken, nil); status != http.StatusOK || al.Contains(context.Background(), "example.com") {
+		t.Error(status)
+	}
+
+	if status := request(t, server, http.MethodPut, "/admin/allowlist/a..b", testToken, nil); status != http.StatusBadRequest {
+		t.Error(status)
+	}
+}
+
+func TestCache(t *testing.T) {
+	server := openAdmin(t)
+	defer server.Close()
+
+	for _, domain := range []string{"example.com", "a.example.com", "b.example.com"} {
+		c.Set(context.Background(), domain, dnsmessage.TypeA, []byte{1}, 0)
+	}
+
+	if status := request(t, server, http.MethodDelete, "/admin/cache/example.com", testToken, nil); status != http.StatusOK {
+		t.Error(status)
+	}
+
+	if c.Get(context.Background(), "example.com", dnsmessage.TypeA) != nil || c.Get(context.Background(), "a.example.com", dnsmessage.TypeA) == nil {
+		t.Error()
+	}
+
+	if status := request(t, server, http.MethodDelete, "/admin/cache/*.example.com", testToken, nil); status != http.StatusOK {
+		t.Error(status)
+	}
+
+	if c.Get(context.Background(), "a.example.com", dnsmessage.TypeA) != nil || c.Get(context.Background(), "b.example.com", dnsmessage.TypeA) != nil {
+		t.Error()
+	}
+}
+
+func TestStats(t *testing.T) {
+	server := openAdmin(t)
+	defer server.Close()
+
+	registry.Block(context.Background(), "ads.example.com", dnsmessage.TypeA, &verdict.Verdict{Source: "hosts", Category: verdict.CategoryAds, Confidence: 1})
+
+	var stats blocks.Stats
+	if status := request(t, server, http.MethodGet, "/admin/stats", testToken, &stats); status != http.StatusOK || stats.Total != 1 || stats.Sources["hosts"] != 1 || stats.Categories[verdict.CategoryAds] != 1 {
+		t.Error(status, stats)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	server := openAdmin(t)
+	defer server.Close()
+
+	version, err := hist.Record(context.Background(), []string{"ads.example.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var versions []history.Version
+	if status := request(t, server, http.MethodGet, "/admin/versions", testToken, &versions); status != http.StatusOK || len(versions) != 1 || versions[0].ID != version.ID {
+		t.Error(status, versions)
+	}
+
+	var got history.Version
+	if status := request(t, server, http.MethodGet, "/admin/versions/"+version.ID, testToken, &got); status != http.StatusOK || got.ID != version.ID {
+		t.Error(status, got)
+	}
+
+	if status := request(t, server, http.MethodPost, "/admin/versions/none/rollback", testToken, nil); status != http.StatusNotFound {
+		t.Error(status)
+	}
+}
+
+func TestNewDomains(t *testing.T) {
+	server := openAdmin(t)
+	defer server.Close()
+
+	now := time.Now().UTC()
+	tracker.Observe(context.Background(), "www.example.com", now)
+
+	var days []nod.Day
+	if status := request(t, server, http.MethodGet, "/admin/new-domains", testToken, &days); status != http.StatusOK || len(days) != 1 || days[0].Domains != 1 {
+		t.Error(status, days)
+	}
+
+	var domains []nod.Domain
+	if status := request(t, server, http.MethodGet, "/admin/new-domains/"+now.Format(nod.DayFormat), testToken, &domains); status != http.StatusOK || len(domains) != 1 || domains[0].Domain != "example.com" {
+		t.Error(status, domains)
+	}
+}
@@ -25,9 +25,15 @@ package main
 
 import (
 	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"log"
 	"math/rand"
 	"net"
 	"net/http"
@@ -35,9 +41,16 @@ import (
 	"strings"
 	"time"
 
+	"github.com/dimkr/dohli/pkg/allowlist"
+	"github.com/dimkr/dohli/pkg/blocks"
 	"github.com/dimkr/dohli/pkg/cache"
 	"github.com/dimkr/dohli/pkg/dns"
+	"github.com/dimkr/dohli/pkg/history"
+	"github.com/dimkr/dohli/pkg/nod"
+	"github.com/dimkr/dohli/pkg/policy"
 	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/rules"
+	"github.com/dimkr/dohli/pkg/verdict"
 	"golang.org/x/net/dns/dnsmessage"
 	"golang.org/x/sync/semaphore"
 )
@@ -62,13 +75,28 @@ const (
 
 	resolvingTimeout = 3 * time.Second
 	cachingTimeout   = 5 * time.Second
+
+	adminRequestTimeout = 5 * time.Second
+
+	// in bytes
+	clientIDLength    = 8
+	clientIDKeyLength = 32
+
+	// the key used when CLIENT_ID_KEY is not set, shared by all web
+	// containers
+	clientIDKeyKey = "client-id-key"
 )
 
 var upstreamServers []string
+var clientIDKey []byte
+var trustedProxies []*net.IPNet
 
 var sem *semaphore.Weighted
 var c *cache.Cache
 var q *queue.Queue
+var al *allowlist.Allowlist
+var ruleBlocker *rules.RuleBlocker
+var pol *policy.Policy
 
 func resolveWithUpstream(parent context.Context, question dnsmessage.Question, request []byte) []byte {
 	var upstream string
@@ -101,16 +129,112 @@ func resolveWithUpstream(parent context.Context, question dnsmessage.Question, r
 	return buf[:n]
 }
 
-func resolve(ctx context.Context, question dnsmessage.Question, request []byte) []byte {
+// parseTrustedProxies parses a comma-separated list of addresses and CIDR
+// blocks.
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+
+	for _, proxy := range strings.Split(s, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip == nil {
+				return nil, errors.New("bad trusted proxy: " + proxy)
+			} else if ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+
+		networks = append(networks, n)
+	}
+
+	return networks, nil
+}
+
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getClientID returns an opaque identifier of the client, which does not
+// reveal its address.
+func getClientID(r *http.Request) string {
+	addr := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	// X-Forwarded-For is only trusted when added by a trusted proxy: the
+	// client is the last address not added by one
+	if isTrustedProxy(addr) {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			addrs := strings.Split(forwarded, ",")
+			for i := len(addrs) - 1; i >= 0; i-- {
+				addr = strings.TrimSpace(addrs[i])
+				if !isTrustedProxy(addr) {
+					break
+				}
+			}
+		}
+	}
+
+	mac := hmac.New(sha256.New, clientIDKey)
+	mac.Write([]byte(addr))
+	return hex.EncodeToString(mac.Sum(nil)[:clientIDLength])
+}
+
+// loadClientIDKey returns the key stored in the cache, or generates one if
+// there is none. If multiple containers start at once, all use the key stored
+// first.
+func loadClientIDKey(ctx context.Context) ([]byte, error) {
+	backend := c.Backend(ctx)
+
+	if key := backend.Get(clientIDKeyKey); len(key) == clientIDKeyLength {
+		return key, nil
+	}
+
+	key := make([]byte, clientIDKeyLength)
+	if _, err := crand.Read(key); err != nil {
+		return nil, err
+	}
+
+	if backend.SetNX(clientIDKeyKey, key, 0) {
+		return key, nil
+	}
+
+	if key = backend.Get(clientIDKeyKey); len(key) != clientIDKeyLength {
+		return nil, errors.New("failed to load the client ID key")
+	}
+
+	return key, nil
+}
+
+// resolve resolves a normalized domain name; the response may not echo the
+// casing of the request.
+func resolve(ctx context.Context, domain string, question dnsmessage.Question, request []byte, client string) []byte {
 	if err := sem.Acquire(ctx, 1); err != nil {
 		return nil
 	}
 	defer sem.Release(1)
 
-	domain := strings.TrimSuffix(question.Name.String(), ".")
-
 	// Chrome resolves junk domains without a dot
-	if strings.Index(domain, ".") == -1 {
+	if strings.Index(domain, ".") == -1 && !al.Contains(ctx, domain) {
 		response, err := dns.BuildNXDomainResponse(domain, question.Type)
 		if err == nil {
 			return response
@@ -118,10 +242,45 @@ func resolve(ctx context.Context, question dnsmessage.Question, request []byte)
 		return nil
 	}
 
+	// rules are cheap to check, so they're checked inline, before the worker
+	// gets a chance to block the domain; verdicts that do not block the
+	// domain under the worker's policy are left to the worker, which combines
+	// them with other verdicts or records them in dry-run mode
+	if ruleBlocker != nil {
+		if v := ruleBlocker.Check(domain); v != nil && pol.Decide([]*verdict.Verdict{v}).Block && !al.Contains(ctx, domain) {
+			response, err := dns.BuildBlockedResponse(domain, question.Type, v.String())
+			if err == nil {
+				return response
+			}
+			return nil
+		}
+	}
+
 	if cachedResponse := c.Get(ctx, domain, question.Type); cachedResponse != nil {
 		return cachedResponse
 	}
 
+	// the upstream server receives the normalized name, so the response can
+	// be cached for all variants of the name
+	if strings.TrimSuffix(question.Name.String(), ".") != domain {
+		var err error
+		if request, err = dns.SetQuestionName(request, domain); err != nil {
+			return nil
+		}
+	}
+
+	// tunnels use a new subdomain for each query, so blocks of all subdomains
+	// of a domain are checked before each query is forwarded
+	if record := registry.LookupZone(ctx, domain); record != nil && !al.Contains(ctx, domain) {
+		response, err := dns.BuildBlockedResponse(domain, question.Type, record.Verdict.String())
+		if err == nil {
+			return response
+		}
+		return nil
+	}
+
+	now := time.Now().UTC()
+
 	response := resolveWithUpstream(ctx, question, request)
 	if response == nil {
 		return nil
@@ -140,10 +299,24 @@ func resolve(ctx context.Context, question dnsmessage.Question, request []byte)
 		}
 		c.Set(ctx, domain, question.Type, response, ttl)
 
+		// the worker can block domains by their addresses
+		var addrs []string
+		if ips, err := dns.GetAddresses(response); err == nil {
+			for _, ip := range ips {
+				addrs = append(addrs, ip.String())
+			}
+		}
+
+		nameservers, _ := dns.GetNameservers(response)
+
 		// we want the worker to replace the cache entry we just inserted
 		if j, err := json.Marshal(queue.DomainAccessMessage{
 			Domain:      domain,
 			RequestType: question.Type,
+			Client:      client,
+			Time:        now,
+			Addresses:   addrs,
+			Nameservers: nameservers,
 		}); err == nil {
 			q.Push(string(j))
 		}
@@ -201,7 +374,8 @@ func handleDNSQuery(w http.ResponseWriter, r *http.Request) {
 
 	var p dnsmessage.Parser
 
-	if _, err := p.Start(body); err != nil {
+	header, err := p.Start(body)
+	if err != nil {
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
@@ -212,16 +386,74 @@ func handleDNSQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	buf := resolve(r.Context(), question, body)
+	// the root is passed through, and other names that can't be normalized
+	// are answered with FORMERR: a HTTP error is a transport failure for DoH
+	// clients
+	domain := ""
+	if name := question.Name.String(); name != "." {
+		if domain, err = dns.NormalizeName(name); err != nil {
+			buf, err := dns.BuildErrorResponse(header, question, dnsmessage.RCodeFormatError)
+			if err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/dns-message")
+			w.Write(buf)
+			return
+		}
+	}
+
+	buf := resolve(r.Context(), domain, question, body, getClientID(r))
 	if buf == nil {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
+	// cached responses are shared by clients with and without EDNS
+	if !dns.HasOPT(body) {
+		if buf, err = dns.RemoveOPT(buf); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// the response echoes the name as sent by the client
+	if strings.TrimSuffix(question.Name.String(), ".") != domain {
+		if buf, err = dns.SetQuestionName(buf, question.Name.String()); err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/dns-message")
 	w.Write(buf)
 }
 
+func handleEvents(events <-chan string) {
+	for event := range events {
+		switch event {
+		case queue.RefreshEvent:
+			if err := ruleBlocker.Reload(); err != nil {
+				log.Println("Failed to reload the rules: ", err)
+			}
+		}
+	}
+}
+
+func newMux() *http.ServeMux {
+	mux := http.ServeMux{}
+	mux.Handle("/", http.TimeoutHandler(http.StripPrefix("/", http.FileServer(http.Dir("/static"))), staticAssertRequestTimeout, "Timeout"))
+	mux.Handle("/dns-query", http.TimeoutHandler(http.HandlerFunc(handleDNSQuery), resolvingRequestTimeout, "Timeout"))
+
+	// the admin API is disabled unless a token is set
+	if adminToken != "" {
+		mux.Handle(adminPrefix, http.TimeoutHandler(http.HandlerFunc(handleAdmin), adminRequestTimeout, "Timeout"))
+	}
+
+	return &mux
+}
+
 func main() {
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -247,18 +479,50 @@ func main() {
 		panic(err)
 	}
 
+	if key := os.Getenv("CLIENT_ID_KEY"); key != "" {
+		clientIDKey = []byte(key)
+	} else if clientIDKey, err = loadClientIDKey(context.Background()); err != nil {
+		panic(err)
+	}
+
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		if trustedProxies, err = parseTrustedProxies(proxies); err != nil {
+			panic(err)
+		}
+	}
+
+	al = allowlist.OpenAllowlist(c)
+	registry = blocks.OpenRegistry(c)
+	al.OnAdd = registry.UnblockRule
+	hist = history.OpenHistory(c)
+	tracker = nod.OpenTracker(c)
+
+	if path := os.Getenv("RULES_PATH"); path != "" {
+		if ruleBlocker, err = rules.OpenRuleBlocker(path); err != nil {
+			panic(err)
+		}
+
+		if pol, err = policy.ParseEnvironment(); err != nil {
+			panic(err)
+		}
+
+		events, err := q.Subscribe(queue.EventsChannel)
+		if err != nil {
+			panic(err)
+		}
+		go handleEvents(events)
+	}
+
 	sem = semaphore.NewWeighted(maxResolvingOperations)
 
-	mux := http.ServeMux{}
-	mux.Handle("/", http.TimeoutHandler(http.StripPrefix("/", http.FileServer(http.Dir("/static"))), staticAssertRequestTimeout, "Timeout"))
-	mux.Handle("/dns-query", http.TimeoutHandler(http.HandlerFunc(handleDNSQuery), resolvingRequestTimeout, "Timeout"))
+	adminToken = os.Getenv("ADMIN_TOKEN")
 
 	server := http.Server{
 		Addr:         ":" + port,
 		ReadTimeout:  readTimeout,
 		WriteTimeout: writeTimeout,
 		IdleTimeout:  idleTimeout,
-		Handler:      &mux,
+		Handler:      newMux(),
 	}
 
 	server.ListenAndServe()
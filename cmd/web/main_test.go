@@ -0,0 +1,177 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dimkr/dohli/pkg/allowlist"
+	"github.com/dimkr/dohli/pkg/blocks"
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/verdict"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/sync/semaphore"
+)
+
+func TestClientIDKey(t *testing.T) {
+	var err error
+	if c, err = cache.OpenCache(&cache.MemoryBackend{}); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := loadClientIDKey(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(key) != clientIDKeyLength || bytes.Equal(key, make([]byte, clientIDKeyLength)) {
+		t.Error(key)
+	}
+
+	// the key is generated once and shared by all containers
+	if again, err := loadClientIDKey(context.Background()); err != nil || !bytes.Equal(again, key) {
+		t.Error(again, err)
+	}
+}
+
+func TestClientID(t *testing.T) {
+	clientIDKey = []byte("key")
+
+	var err error
+	if trustedProxies, err = parseTrustedProxies("10.0.0.0/8, 192.168.1.1"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { trustedProxies = nil }()
+
+	id := func(remoteAddr, forwarded string) string {
+		r := httptest.NewRequest("GET", "/dns-query", nil)
+		r.RemoteAddr = remoteAddr
+		if forwarded != "" {
+			r.Header.Set("X-Forwarded-For", forwarded)
+		}
+		return getClientID(r)
+	}
+
+	client := id("1.2.3.4:1234", "")
+
+	// untrusted callers can't choose their address
+	if other := id("1.2.3.4:5678", "5.6.7.8"); other != client {
+		t.Error(other)
+	}
+
+	// the client is the last address not added by a trusted proxy
+	for _, forwarded := range []string{"1.2.3.4", "5.6.7.8, 1.2.3.4", "1.2.3.4, 192.168.1.1", "5.6.7.8,1.2.3.4,10.1.1.1"} {
+		if other := id("10.0.0.1:1234", forwarded); other != client {
+			t.Error(forwarded, other)
+		}
+	}
+
+	if other := id("10.0.0.1:1234", "5.6.7.8"); other == client {
+		t.Error(other)
+	}
+
+	if _, err := parseTrustedProxies("10.0.0.0/8,proxy"); err == nil {
+		t.Error()
+	}
+}
+
+func query(t *testing.T, name string, requestType dnsmessage.Type, edns bool) *dnsmessage.Message {
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 1234, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: requestType, Class: dnsmessage.ClassINET}},
+	}
+
+	if edns {
+		opt := dnsmessage.Resource{Body: &dnsmessage.OPTResource{}}
+		if err := opt.Header.SetEDNS0(1232, dnsmessage.RCodeSuccess, false); err != nil {
+			t.Fatal(err)
+		}
+		msg.Additionals = []dnsmessage.Resource{opt}
+	}
+
+	request, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	handleDNSQuery(w, httptest.NewRequest("GET", "/dns-query?dns="+base64.RawURLEncoding.EncodeToString(request), nil))
+	if w.Code != 200 {
+		t.Fatal(name, w.Code)
+	}
+
+	var response dnsmessage.Message
+	if err := response.Unpack(w.Body.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	return &response
+}
+
+func TestHandleDNSQueryBadName(t *testing.T) {
+	var err error
+	if c, err = cache.OpenCache(&cache.MemoryBackend{}); err != nil {
+		t.Fatal(err)
+	}
+
+	al = allowlist.OpenAllowlist(c)
+	sem = semaphore.NewWeighted(1)
+
+	// the root has no dot, like the junk domains resolved by Chrome
+	if response := query(t, ".", dnsmessage.TypeNS, false); response.RCode != dnsmessage.RCodeNameError || len(response.Questions) != 1 || response.Questions[0].Name.String() != "." {
+		t.Error(response)
+	}
+
+	if response := query(t, "bad name.example.com.", dnsmessage.TypeA, false); response.RCode != dnsmessage.RCodeFormatError || response.ID != 1234 || !response.RecursionDesired || len(response.Questions) != 1 || response.Questions[0].Name.String() != "bad name.example.com." {
+		t.Error(response)
+	}
+}
+
+func TestHandleDNSQueryEDNS(t *testing.T) {
+	var err error
+	if c, err = cache.OpenCache(&cache.MemoryBackend{}); err != nil {
+		t.Fatal(err)
+	}
+
+	al = allowlist.OpenAllowlist(c)
+	registry = blocks.OpenRegistry(c)
+	sem = semaphore.NewWeighted(1)
+
+	if err := registry.Block(context.Background(), "ads.example.com", dnsmessage.TypeA, &verdict.Verdict{Source: "hosts", Category: verdict.CategoryAds}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the cached response has an Extended DNS Error, which is only sent to
+	// clients that sent an OPT record
+	if response := query(t, "ads.example.com.", dnsmessage.TypeA, false); response.RCode != dnsmessage.RCodeNameError || len(response.Additionals) != 0 {
+		t.Error(response)
+	}
+
+	if response := query(t, "ads.example.com.", dnsmessage.TypeA, true); response.RCode != dnsmessage.RCodeNameError || len(response.Additionals) != 1 || response.Additionals[0].Header.Type != dnsmessage.TypeOPT {
+		t.Error(response)
+	}
+}
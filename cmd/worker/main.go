@@ -27,69 +27,122 @@ import (
 	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/dimkr/dohli/pkg/allowlist"
+	"github.com/dimkr/dohli/pkg/blocks"
 	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/circuit"
+	"github.com/dimkr/dohli/pkg/dga"
 	"github.com/dimkr/dohli/pkg/dns"
+	"github.com/dimkr/dohli/pkg/history"
 	"github.com/dimkr/dohli/pkg/hosts"
+	"github.com/dimkr/dohli/pkg/iplist"
+	"github.com/dimkr/dohli/pkg/nameserver"
+	"github.com/dimkr/dohli/pkg/nod"
+	"github.com/dimkr/dohli/pkg/openphish"
+	"github.com/dimkr/dohli/pkg/phishtank"
+	"github.com/dimkr/dohli/pkg/policy"
 	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/rules"
+	"github.com/dimkr/dohli/pkg/safebrowsing"
+	"github.com/dimkr/dohli/pkg/threatfox"
+	"github.com/dimkr/dohli/pkg/tunnel"
+	"github.com/dimkr/dohli/pkg/typosquat"
 	"github.com/dimkr/dohli/pkg/urlhaus"
-	"golang.org/x/net/dns/dnsmessage"
+	"github.com/dimkr/dohli/pkg/verdict"
 )
 
 const (
-	// no expiration
-	blockedDomainTTL = 0
-	numWorkers       = 16
+	numWorkers = 16
 
 	blockingTimeout = 10 * time.Second
+
+	// messages with skipped checks are retried after 1, 2, 4 and 8 minutes
+	maxRetries    = 4
+	retryDelay    = time.Minute
+	retryInterval = 10 * time.Second
 )
 
 type blocker interface {
 	Connect() error
-	IsBad(context.Context, *queue.DomainAccessMessage) bool
+	IsBad(context.Context, *queue.DomainAccessMessage) *verdict.Verdict
 	IsAsync() bool
 }
 
+type reloader interface {
+	Reload() error
+}
+
 var c *cache.Cache
 var q *queue.Queue
-var blockers []blocker = []blocker{&hosts.HostsBlacklist{}, &urlhaus.Client{}}
+var al *allowlist.Allowlist
+var registry *blocks.Registry
+var pol *policy.Policy
+var hist *history.History
+var hostsBlacklist *hosts.HostsBlacklist
+var blockers []blocker
+
+// blockExpiry returns the time a block expires: the block lasts as long as any
+// of the verdicts that caused it.
+func blockExpiry(verdicts []*verdict.Verdict) *time.Time {
+	var expires *time.Time
+
+	for _, v := range verdicts {
+		if v.Expires == nil {
+			return nil
+		}
 
-func doBlockDomain(ctx context.Context, domain string, requestType dnsmessage.Type) error {
-	response, err := dns.BuildNXDomainResponse(domain, requestType)
-	if err == nil {
-		c.Set(ctx, domain, requestType, response, blockedDomainTTL)
+		if expires == nil || v.Expires.After(*expires) {
+			expires = v.Expires
+		}
 	}
-	return err
+
+	return expires
 }
 
-func blockDomain(ctx context.Context, msg *queue.DomainAccessMessage) {
-	log.Println("Blocking ", msg.Domain)
+func blockDomain(ctx context.Context, msg *queue.DomainAccessMessage, d *policy.Decision) {
+	for _, v := range d.Verdicts {
+		log.Printf("Blocking %s (score %.2f): %s", msg.Domain, d.Score, v)
+	}
+
+	// the strongest verdict is stored with the block
+	v := *d.Verdicts[0]
+	v.Expires = blockExpiry(d.Verdicts)
 
-	if err := doBlockDomain(ctx, msg.Domain, msg.RequestType); err != nil {
+	if err := registry.Block(ctx, msg.Domain, msg.RequestType, &v); err != nil {
 		log.Printf("Failed to block %s: %v", msg.Domain, err)
 	}
 
-	var otherType dnsmessage.Type
+	// some verdicts apply to all subdomains of a domain
+	for _, v := range d.Verdicts {
+		if v.Zone == "" {
+			continue
+		}
 
-	switch msg.RequestType {
-	case dnsmessage.TypeA:
-		otherType = dnsmessage.TypeAAAA
+		if err := registry.BlockZone(ctx, v.Zone, v); err != nil {
+			log.Printf("Failed to block %s: %v", v.Zone, err)
+		}
+	}
+}
 
-	case dnsmessage.TypeAAAA:
-		otherType = dnsmessage.TypeA
+func recordDryRun(ctx context.Context, msg *queue.DomainAccessMessage, d *policy.Decision) {
+	verdicts := append(append([]*verdict.Verdict{}, d.Verdicts...), d.Monitored...)
 
-	default:
-		return
+	for _, v := range verdicts {
+		log.Printf("Would block %s: %s", msg.Domain, v)
 	}
 
-	if err := doBlockDomain(ctx, msg.Domain, otherType); err != nil {
-		log.Printf("Failed to block %s: %v", msg.Domain, err)
+	if err := registry.RecordDryRun(ctx, msg.Domain, verdicts, msg.Client); err != nil {
+		log.Printf("Failed to record a dry-run verdict for %s: %v", msg.Domain, err)
 	}
 }
 
@@ -97,21 +150,16 @@ func blockDomainIfNeeded(parent context.Context, msg *queue.DomainAccessMessage)
 	ctx, cancel := context.WithTimeout(parent, blockingTimeout)
 	defer cancel()
 
-	n := len(blockers)
-	for _, b := range blockers {
-		if b.IsAsync() {
-			continue
-		}
-
-		if b.IsBad(ctx, msg) {
-			blockDomain(ctx, msg)
-			return
-		}
+	// checks skipped by circuit breakers are retried later
+	ctx, skipped := circuit.WithSkipped(ctx)
 
-		n--
+	// the allowlist takes precedence over all blockers
+	if al.Contains(ctx, msg.Domain) {
+		return
 	}
 
-	verdicts := make(chan bool, n)
+	asyncVerdicts := make(chan *verdict.Verdict, len(blockers))
+	n := 0
 
 	for _, b := range blockers {
 		if !b.IsAsync() {
@@ -119,17 +167,80 @@ func blockDomainIfNeeded(parent context.Context, msg *queue.DomainAccessMessage)
 		}
 
 		go func(b blocker) {
-			verdicts <- b.IsBad(ctx, msg)
+			asyncVerdicts <- b.IsBad(ctx, msg)
 		}(b)
+		n++
+	}
+
+	verdicts := make([]*verdict.Verdict, 0, len(blockers))
+
+	for _, b := range blockers {
+		if !b.IsAsync() {
+			verdicts = append(verdicts, b.IsBad(ctx, msg))
+		}
 	}
 
 	for i := 0; i < n; i++ {
-		select {
-		case shouldBlock := <-verdicts:
-			if shouldBlock {
-				blockDomain(ctx, msg)
-				return
-			}
+		verdicts = append(verdicts, <-asyncVerdicts)
+	}
+
+	d := pol.Decide(verdicts)
+
+	if d.Block {
+		blockDomain(ctx, msg, d)
+		return
+	} else if d.WouldBlock {
+		recordDryRun(ctx, msg, d)
+	}
+
+	if skipped.Len() > 0 {
+		retryLater(msg)
+	}
+}
+
+// publishAlert logs an alert and broadcasts it.
+func publishAlert(alert *tunnel.Alert) {
+	log.Printf("Suspected tunnel through %s by %s: %s", alert.Zone, alert.Client, alert.Reason)
+
+	j, err := json.Marshal(alert)
+	if err != nil {
+		return
+	}
+
+	if err := q.Publish(queue.AlertsChannel, string(j)); err != nil {
+		log.Printf("Failed to publish an alert: %v", err)
+	}
+}
+
+// retryLater pushes a message to the queue again, after a delay that grows
+// with each retry.
+func retryLater(msg *queue.DomainAccessMessage) {
+	if msg.Retries >= maxRetries {
+		log.Printf("Giving up on skipped checks of %s", msg.Domain)
+		return
+	}
+
+	retry := *msg
+	retry.Retries++
+
+	j, err := json.Marshal(retry)
+	if err != nil {
+		return
+	}
+
+	if err := q.PushLater(string(j), time.Now().Add(retryDelay<<msg.Retries)); err != nil {
+		log.Printf("Failed to retry %s: %v", msg.Domain, err)
+	}
+}
+
+// pushDueRetries pushes retried messages to the queue, once they're due.
+func pushDueRetries() {
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		if _, err := q.PushDue(now); err != nil {
+			log.Println("Failed to push retried messages: ", err)
 		}
 	}
 }
@@ -171,6 +282,14 @@ func handleDomainAccess(j string, jobQueue chan<- queue.DomainAccessMessage) {
 		return
 	}
 
+	// blockers expect a domain name in canonical form
+	domain, err := dns.NormalizeName(msg.Domain)
+	if err != nil {
+		log.Printf("Bad domain in %s: %v", j, err)
+		return
+	}
+	msg.Domain = domain
+
 	jobQueue <- msg
 }
 
@@ -186,12 +305,147 @@ func handleMessages(jobQueue chan<- queue.DomainAccessMessage) {
 	}
 }
 
+func reloadBlockers() {
+	for _, b := range blockers {
+		if r, ok := b.(reloader); ok {
+			if err := r.Reload(); err != nil {
+				log.Println("Failed to reload a blocklist: ", err)
+			}
+		}
+	}
+}
+
+// syncBlocklistVersion records the current version of the hosts blacklist and
+// replaces it with an older version, if rolled back.
+func syncBlocklistVersion() {
+	ctx, cancel := context.WithTimeout(context.Background(), blockingTimeout)
+	defer cancel()
+
+	current, err := hist.Record(ctx, hostsBlacklist.Domains(), hostsBlacklist.Sources())
+	if err != nil {
+		log.Println("Failed to record the blocklist version: ", err)
+		return
+	}
+
+	active := hist.Active(ctx)
+	if active == nil || active.ID == current.ID {
+		return
+	}
+
+	domains, err := hist.Domains(ctx, active.ID)
+	if err != nil {
+		log.Printf("Failed to load blocklist version %s: %v", active.ID, err)
+		return
+	}
+
+	log.Printf("Using blocklist version %s instead of %s", active.ID, current.ID)
+	hostsBlacklist.Load(domains)
+}
+
+func handleEvents(events <-chan string) {
+	for event := range events {
+		switch event {
+		case queue.RefreshEvent:
+			log.Println("Reloading blocklists")
+			reloadBlockers()
+			syncBlocklistVersion()
+		}
+	}
+}
+
+// parseLists parses a comma-separated list of files, each optionally prefixed
+// by a name and "=".
+func parseLists(s string) [][2]string {
+	var lists [][2]string
+
+	for _, path := range strings.Split(s, ",") {
+		var name string
+		if i := strings.IndexByte(path, '='); i != -1 {
+			name, path = path[:i], path[i+1:]
+		}
+
+		lists = append(lists, [2]string{strings.TrimSpace(name), strings.TrimSpace(path)})
+	}
+
+	return lists
+}
+
+func parseBlocklists(s string) []hosts.List {
+	if s == "" {
+		return []hosts.List{{Path: hosts.DefaultPath}}
+	}
+
+	var lists []hosts.List
+	for _, list := range parseLists(s) {
+		lists = append(lists, hosts.List{Name: list[0], Path: list[1]})
+	}
+
+	return lists
+}
+
+func parseIPLists(s string) []iplist.List {
+	var lists []iplist.List
+	for _, list := range parseLists(s) {
+		lists = append(lists, iplist.List{Name: list[0], Path: list[1]})
+	}
+
+	return lists
+}
+
+func handleHangup(hup <-chan os.Signal) {
+	for range hup {
+		log.Println("Reloading blocklists")
+		reloadBlockers()
+		syncBlocklistVersion()
+	}
+}
+
+// configureVerdictCache sets the TTLs of a verdict cache, from the
+// environment.
+func configureVerdictCache(vc *verdict.Cache) error {
+	for env, ttl := range map[string]*int{
+		"VERDICT_CACHE_TTL":          &vc.PositiveTTL,
+		"VERDICT_CACHE_NEGATIVE_TTL": &vc.NegativeTTL,
+	} {
+		s := os.Getenv(env)
+		if s == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("bad %s: %s", env, s)
+		}
+
+		*ttl = n
+	}
+
+	return nil
+}
+
+func loadAllowlist(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), blockingTimeout)
+	defer cancel()
+
+	return al.Load(ctx, f)
+}
+
 func main() {
 	waitForMessages := flag.Bool("wait", false, "Wait when job queue is empty")
 	flag.Parse()
 
 	var err error
 
+	if pol, err = policy.ParseEnvironment(); err != nil {
+		panic(err)
+	}
+
 	if c, err = cache.OpenCache(&cache.RedisBackend{}); err != nil {
 		panic(err)
 	}
@@ -200,15 +454,201 @@ func main() {
 		panic(err)
 	}
 
+	al = allowlist.OpenAllowlist(c)
+	registry = blocks.OpenRegistry(c)
+	al.OnAdd = registry.UnblockRule
+	hist = history.OpenHistory(c)
+
+	if path := os.Getenv("ALLOWLIST_PATH"); path != "" {
+		if err = loadAllowlist(path); err != nil {
+			panic(err)
+		}
+	}
+
+	if hostsBlacklist, err = hosts.OpenHostsBlacklist(parseBlocklists(os.Getenv("BLOCKLISTS"))...); err != nil {
+		panic(err)
+	}
+
+	urlhausClient := urlhaus.OpenClient(c)
+	if useAPI := os.Getenv("URLHAUS_API"); useAPI != "" {
+		if urlhausClient.UseAPI, err = strconv.ParseBool(useAPI); err != nil {
+			panic(err)
+		}
+	}
+	urlhausClient.BaseURL = os.Getenv("URLHAUS_API_URL")
+	urlhausClient.AuthKey = os.Getenv("URLHAUS_AUTH_KEY")
+	if err = configureVerdictCache(urlhausClient.Verdicts); err != nil {
+		panic(err)
+	}
+	if rate := os.Getenv("URLHAUS_RATE_LIMIT"); rate != "" {
+		if urlhausClient.Breaker.Rate, err = strconv.Atoi(rate); err != nil {
+			panic(err)
+		}
+	}
+
+	blockers = []blocker{hostsBlacklist, urlhausClient}
+
+	if feeds := os.Getenv("THREAT_FEEDS"); feeds != "" {
+		for _, name := range strings.Split(feeds, ",") {
+			switch strings.TrimSpace(name) {
+			case openphish.Source:
+				blockers = append(blockers, openphish.OpenBlocker(c))
+
+			case phishtank.Source:
+				blockers = append(blockers, phishtank.OpenBlocker(c, os.Getenv("PHISHTANK_APP_KEY")))
+
+			case threatfox.Source:
+				blockers = append(blockers, threatfox.OpenBlocker(c))
+
+			default:
+				panic("unknown feed: " + name)
+			}
+		}
+	}
+
+	if key := os.Getenv("SAFE_BROWSING_API_KEY"); key != "" {
+		blockers = append(blockers, safebrowsing.OpenBlocker(c, key))
+	}
+
+	// the DGA heuristics are only trusted enough to block when asked to
+	switch mode := os.Getenv("DGA_MODE"); mode {
+	case "", "monitor", "block":
+		dgaBlocker := dga.OpenBlocker()
+		if threshold := os.Getenv("DGA_THRESHOLD"); threshold != "" {
+			if dgaBlocker.Threshold, err = strconv.ParseFloat(threshold, 64); err != nil {
+				panic(err)
+			}
+
+			if dgaBlocker.Threshold <= 0 || dgaBlocker.Threshold > 1 {
+				panic("bad DGA_THRESHOLD: " + threshold)
+			}
+		}
+
+		if mode != "block" {
+			pol.Monitor[dga.Source] = true
+		}
+
+		// the confidence of DGA verdicts is the score, so a domain with a
+		// score at the threshold has a weight of 1 unless told otherwise
+		if _, ok := pol.Weights[dga.Source]; !ok {
+			pol.Weights[dga.Source] = 1 / dgaBlocker.Threshold
+		}
+
+		blockers = append(blockers, dgaBlocker)
+
+	case "off":
+		// disabled
+
+	default:
+		panic("bad DGA_MODE: " + mode)
+	}
+
+	// the first-seen time of every domain is recorded, even if young domains
+	// are not blocked
+	nodBlocker := nod.OpenBlocker(c)
+	if window := os.Getenv("NOD_WINDOW"); window != "" {
+		hours, err := strconv.Atoi(window)
+		if err != nil || hours < 0 {
+			panic("bad NOD_WINDOW: " + window)
+		}
+
+		nodBlocker.Window = time.Duration(hours) * time.Hour
+	}
+	blockers = append(blockers, nodBlocker)
+
+	if tunnels := os.Getenv("TUNNEL_DETECTION"); tunnels != "" {
+		enabled, err := strconv.ParseBool(tunnels)
+		if err != nil {
+			panic(err)
+		}
+
+		if enabled {
+			detector := tunnel.OpenDetector(c)
+			if minUnique := os.Getenv("TUNNEL_MIN_UNIQUE"); minUnique != "" {
+				if detector.MinUnique, err = strconv.Atoi(minUnique); err != nil {
+					panic(err)
+				}
+			}
+			detector.OnAlert = publishAlert
+
+			// a suspected tunnel comes from the queries of a single client,
+			// so it's only blocked when asked to
+			switch mode := os.Getenv("TUNNEL_MODE"); mode {
+			case "", "monitor":
+				pol.Monitor[tunnel.Source] = true
+
+			case "block":
+				// blocked like any other source
+
+			default:
+				panic("bad TUNNEL_MODE: " + mode)
+			}
+
+			blockers = append(blockers, detector)
+		}
+	}
+
+	if brands := os.Getenv("PROTECTED_BRANDS"); brands != "" {
+		typosquatBlocker, err := typosquat.OpenBlocker(strings.Split(brands, ",")...)
+		if err != nil {
+			panic(err)
+		}
+
+		blockers = append(blockers, typosquatBlocker)
+	}
+
+	if lists := os.Getenv("IP_BLOCKLISTS"); lists != "" {
+		ipBlocker, err := iplist.OpenBlocker(parseIPLists(lists)...)
+		if err != nil {
+			panic(err)
+		}
+
+		blockers = append(blockers, ipBlocker)
+	}
+
+	if path := os.Getenv("NAMESERVER_BLOCKLIST_PATH"); path != "" {
+		nsBlocker, err := nameserver.OpenBlocker(c, path)
+		if err != nil {
+			panic(err)
+		}
+
+		if err = configureVerdictCache(nsBlocker.Verdicts); err != nil {
+			panic(err)
+		}
+
+		blockers = append(blockers, nsBlocker)
+	}
+
+	if path := os.Getenv("RULES_PATH"); path != "" {
+		ruleBlocker, err := rules.OpenRuleBlocker(path)
+		if err != nil {
+			panic(err)
+		}
+
+		blockers = append(blockers, ruleBlocker)
+	}
+
 	for _, b := range blockers {
 		if err = b.Connect(); err != nil {
 			panic(err)
 		}
 	}
 
+	syncBlocklistVersion()
+
+	events, err := q.Subscribe(queue.EventsChannel)
+	if err != nil {
+		panic(err)
+	}
+	go handleEvents(events)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go handleHangup(hup)
+
 	ctx, cancel := context.WithCancel(context.Background())
 
-	sigCh := make(chan os.Signal)
+	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
 
 	var workers sync.WaitGroup
@@ -225,6 +665,7 @@ func main() {
 	}
 
 	go handleMessages(jobQueue)
+	go pushDueRetries()
 	<-sigCh
 
 	cancel()
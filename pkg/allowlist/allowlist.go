@@ -0,0 +1,184 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package allowlist implements a list of domains that are never blocked.
+package allowlist
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/dns"
+)
+
+const (
+	keyPrefix      = "allowlist:"
+	wildcardPrefix = "*."
+)
+
+// ErrInvalidRule is returned when an allowlist rule is not a domain name or a
+// wildcard.
+var ErrInvalidRule = errors.New("invalid allowlist rule")
+
+// Allowlist is a list of domains that takes precedence over all blockers.
+//
+// A rule is either a domain name, which matches this domain only, or a domain
+// name prefixed by "*.", which matches all subdomains of this domain.
+//
+// Rules are stored in the cache, so they can be edited at runtime and are
+// shared by all processes that use the same cache.
+type Allowlist struct {
+	cache *cache.Cache
+
+	// OnAdd is called after a rule is added, to remove other records of
+	// blocks of matching domains
+	OnAdd func(ctx context.Context, rule string)
+}
+
+// OpenAllowlist opens the allowlist stored in a cache.
+func OpenAllowlist(c *cache.Cache) *Allowlist {
+	return &Allowlist{cache: c}
+}
+
+// ParseRule validates an allowlist rule and returns it in canonical form.
+func ParseRule(rule string) (string, error) {
+	rule = strings.TrimSpace(rule)
+
+	prefix := ""
+	if strings.HasPrefix(rule, wildcardPrefix) {
+		prefix, rule = wildcardPrefix, rule[len(wildcardPrefix):]
+	}
+
+	domain, err := dns.NormalizeName(rule)
+	if err != nil {
+		return "", ErrInvalidRule
+	}
+
+	return prefix + domain, nil
+}
+
+// Add adds a rule to the allowlist and removes cached responses for matching
+// domains, including block entries.
+func (a *Allowlist) Add(ctx context.Context, rule string) error {
+	rule, err := ParseRule(rule)
+	if err != nil {
+		return err
+	}
+
+	a.cache.Backend(ctx).Set(keyPrefix+rule, []byte{1}, 0)
+
+	if strings.HasPrefix(rule, wildcardPrefix) {
+		a.cache.FlushSuffix(ctx, rule[len(wildcardPrefix):])
+	} else {
+		a.cache.Flush(ctx, rule)
+	}
+
+	if a.OnAdd != nil {
+		a.OnAdd(ctx, rule)
+	}
+
+	return nil
+}
+
+// Remove removes a rule from the allowlist.
+func (a *Allowlist) Remove(ctx context.Context, rule string) error {
+	rule, err := ParseRule(rule)
+	if err != nil {
+		return err
+	}
+
+	a.cache.Backend(ctx).Delete(keyPrefix + rule)
+	return nil
+}
+
+// Rules returns all allowlist rules.
+func (a *Allowlist) Rules(ctx context.Context) []string {
+	keys := a.cache.Backend(ctx).Keys(keyPrefix + "*")
+
+	rules := make([]string, 0, len(keys))
+	for _, key := range keys {
+		rules = append(rules, key[len(keyPrefix):])
+	}
+
+	return rules
+}
+
+// Load adds rules read from a file, with one rule per line. Empty lines and
+// lines that start with # are ignored.
+//
+// Rules that are already in the allowlist are skipped, so cached responses are
+// flushed only for new rules and loading the same file again is cheap.
+func (a *Allowlist) Load(ctx context.Context, r io.Reader) error {
+	backend := a.cache.Backend(ctx)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule, err := ParseRule(line)
+		if err != nil {
+			return err
+		}
+
+		if backend.Get(keyPrefix+rule) != nil {
+			continue
+		}
+
+		if err := a.Add(ctx, rule); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Contains determines whether or not a domain is allowlisted, either by an
+// exact rule or by a wildcard rule for one of its parent domains.
+func (a *Allowlist) Contains(ctx context.Context, domain string) bool {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	if domain == "" {
+		return false
+	}
+
+	// the exact rule and the wildcard rules of all parent domains are
+	// fetched at once
+	keys := []string{keyPrefix + domain}
+	for i := strings.IndexByte(domain, '.'); i != -1; i = strings.IndexByte(domain, '.') {
+		domain = domain[i+1:]
+		keys = append(keys, keyPrefix+wildcardPrefix+domain)
+	}
+
+	for _, value := range a.cache.Backend(ctx).MGet(keys...) {
+		if value != nil {
+			return true
+		}
+	}
+
+	return false
+}
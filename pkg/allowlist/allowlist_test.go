@@ -0,0 +1,159 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package allowlist
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/dimkr/dohli/pkg/cache"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func openAllowlist() (*Allowlist, *cache.Cache) {
+	c, err := cache.OpenCache(&cache.MemoryBackend{})
+	if err != nil {
+		panic(err)
+	}
+
+	return OpenAllowlist(c), c
+}
+
+func ExampleAllowlist_Contains() {
+	allowlist, _ := openAllowlist()
+
+	allowlist.Add(context.Background(), "wikipedia.org")
+	allowlist.Add(context.Background(), "*.example.com")
+
+	fmt.Println(allowlist.Contains(context.Background(), "wikipedia.org"))
+	fmt.Println(allowlist.Contains(context.Background(), "WikiPedia.org."))
+	fmt.Println(allowlist.Contains(context.Background(), "en.wikipedia.org"))
+	fmt.Println(allowlist.Contains(context.Background(), "example.com"))
+	fmt.Println(allowlist.Contains(context.Background(), "www.example.com"))
+	fmt.Print(allowlist.Contains(context.Background(), "a.b.example.com"))
+
+	// Output:
+	// true
+	// true
+	// false
+	// false
+	// true
+	// true
+}
+
+func TestParseRule(t *testing.T) {
+	for rule, expected := range map[string]string{
+		"wikipedia.org":    "wikipedia.org",
+		" Wikipedia.ORG. ": "wikipedia.org",
+		"*.example.com":    "*.example.com",
+	} {
+		if parsed, err := ParseRule(rule); err != nil || parsed != expected {
+			t.Errorf("%s: %s, %v", rule, parsed, err)
+		}
+	}
+
+	for _, rule := range []string{"", "*.", ".", "a..b", "a.*.b", "*", "a b", strings.Repeat("a", 64) + ".com"} {
+		if _, err := ParseRule(rule); err != ErrInvalidRule {
+			t.Errorf("%s: %v", rule, err)
+		}
+	}
+}
+
+func TestRemove(t *testing.T) {
+	allowlist, _ := openAllowlist()
+
+	allowlist.Add(context.Background(), "wikipedia.org")
+	allowlist.Remove(context.Background(), "wikipedia.org")
+
+	if allowlist.Contains(context.Background(), "wikipedia.org") {
+		t.Error()
+	}
+}
+
+func TestLoad(t *testing.T) {
+	allowlist, _ := openAllowlist()
+
+	if err := allowlist.Load(context.Background(), strings.NewReader("# comment\n\nwikipedia.org\n*.example.com\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := allowlist.Rules(context.Background())
+	sort.Strings(rules)
+
+	if len(rules) != 2 || rules[0] != "*.example.com" || rules[1] != "wikipedia.org" {
+		t.Error(rules)
+	}
+}
+
+func TestLoadExisting(t *testing.T) {
+	allowlist, c := openAllowlist()
+
+	if err := allowlist.Load(context.Background(), strings.NewReader("wikipedia.org\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Set(context.Background(), "wikipedia.org", dnsmessage.TypeA, []byte{1, 2, 3, 4}, 0)
+
+	// rules that were loaded before don't flush the cache again
+	if err := allowlist.Load(context.Background(), strings.NewReader("wikipedia.org\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Get(context.Background(), "wikipedia.org", dnsmessage.TypeA) == nil {
+		t.Error()
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	allowlist, _ := openAllowlist()
+
+	if err := allowlist.Load(context.Background(), strings.NewReader("wikipedia.org\na..b\n")); err != ErrInvalidRule {
+		t.Error(err)
+	}
+}
+
+func TestAddFlushesBlockEntries(t *testing.T) {
+	allowlist, c := openAllowlist()
+
+	c.Set(context.Background(), "wikipedia.org", dnsmessage.TypeA, []byte{1, 2, 3, 4}, 0)
+	c.Set(context.Background(), "www.example.com", dnsmessage.TypeAAAA, []byte{5, 6, 7, 8}, 0)
+	c.Set(context.Background(), "example.com", dnsmessage.TypeA, []byte{9, 10, 11, 12}, 0)
+
+	allowlist.Add(context.Background(), "wikipedia.org")
+	allowlist.Add(context.Background(), "*.example.com")
+
+	if c.Get(context.Background(), "wikipedia.org", dnsmessage.TypeA) != nil {
+		t.Error()
+	}
+
+	if c.Get(context.Background(), "www.example.com", dnsmessage.TypeAAAA) != nil {
+		t.Error()
+	}
+
+	if c.Get(context.Background(), "example.com", dnsmessage.TypeA) == nil {
+		t.Error()
+	}
+}
@@ -0,0 +1,105 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package blocklist
+
+import "math"
+
+// DefaultBloomBitsPerKey is the default bloom filter size of tables, in bits
+// per rule: about 1% of lookups of unlisted domains search the table.
+const DefaultBloomBitsPerKey = 10
+
+const (
+	fnvOffset = 14695981039346656037
+	fnvPrime  = 1099511628211
+)
+
+// bloomFilter is a bloom filter of strings; an empty filter contains
+// everything.
+type bloomFilter struct {
+	bits   int
+	hashes int
+	filter []byte
+}
+
+func newBloomFilter(keys, bitsPerKey int) bloomFilter {
+	if keys == 0 || bitsPerKey <= 0 {
+		return bloomFilter{}
+	}
+
+	hashes := int(math.Round(float64(bitsPerKey) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+
+	bits := keys * bitsPerKey
+	return bloomFilter{bits: bits, hashes: hashes, filter: make([]byte, (bits+7)/8)}
+}
+
+// hash returns the FNV-1a hash of prefix+s, without concatenation.
+func hash(prefix, s string) uint64 {
+	h := uint64(fnvOffset)
+
+	for i := 0; i < len(prefix); i++ {
+		h ^= uint64(prefix[i])
+		h *= fnvPrime
+	}
+
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= fnvPrime
+	}
+
+	return h
+}
+
+// position returns the i-th bit position of a key, using double hashing.
+func (b *bloomFilter) position(h uint64, i int) uint64 {
+	return ((h & 0xffffffff) + uint64(i)*(h>>32)) % uint64(b.bits)
+}
+
+func (b *bloomFilter) add(prefix, s string) {
+	if b.bits == 0 {
+		return
+	}
+
+	h := hash(prefix, s)
+	for i := 0; i < b.hashes; i++ {
+		bit := b.position(h, i)
+		b.filter[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+func (b *bloomFilter) mayContain(prefix, s string) bool {
+	if b.bits == 0 {
+		return true
+	}
+
+	h := hash(prefix, s)
+	for i := 0; i < b.hashes; i++ {
+		if bit := b.position(h, i); b.filter[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,361 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package blocklist
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dimkr/dohli/pkg/fetch"
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+const (
+	artifactHeader = "# dohli blocklist 1"
+	sourcePrefix   = "# source "
+)
+
+// ErrNotArtifact is returned when a file is not a compiled blocklist.
+var ErrNotArtifact = errors.New("not a compiled blocklist")
+
+// CompiledSource describes a source of a compiled blocklist.
+type CompiledSource struct {
+	Name    string
+	SHA256  string
+	License string
+}
+
+// Artifact is a compiled blocklist: a sorted list of unique rules, in
+// canonical form.
+type Artifact struct {
+	Sources []CompiledSource
+	Rules   []string
+
+	// Categories maps rules to the category of their source, if it has one
+	Categories map[string]verdict.Category
+}
+
+// severity ranks categories, so a rule found in multiple sources gets the most
+// severe category
+var severity = map[verdict.Category]int{
+	verdict.CategoryAds:      1,
+	verdict.CategoryTracking: 2,
+	verdict.CategoryPhishing: 3,
+	verdict.CategoryMalware:  4,
+}
+
+// moreSevere returns the more severe of two categories; unknown categories are
+// less severe than known ones, but more severe than none.
+func moreSevere(a, b verdict.Category) verdict.Category {
+	if a == "" || (b != "" && severity[b] > severity[a]) {
+		return b
+	}
+
+	return a
+}
+
+// IsArtifact determines whether or not the beginning of a file is the
+// beginning of a compiled blocklist.
+func IsArtifact(prefix []byte) bool {
+	return bytes.HasPrefix(prefix, []byte(artifactHeader+"\n"))
+}
+
+// exceptions are the domains allowed by exception rules.
+type exceptions struct {
+	domains   map[string]bool
+	wildcards map[string]bool
+
+	// parents of allowed domains, which must not be blocked by a wildcard
+	parents map[string]bool
+}
+
+func (e *exceptions) add(rule string) {
+	domain := rule
+	if strings.HasPrefix(rule, wildcardPrefix) {
+		domain = rule[len(wildcardPrefix):]
+		e.wildcards[domain] = true
+	} else {
+		e.domains[domain] = true
+	}
+
+	for i := strings.IndexByte(domain, '.'); i != -1; i = strings.IndexByte(domain, '.') {
+		domain = domain[i+1:]
+		e.parents[domain] = true
+	}
+}
+
+// match determines whether or not a rule matches an allowed domain: the rule
+// is removed if it blocks an allowed domain, or if it's a wildcard that
+// matches one.
+func (e *exceptions) match(rule string) bool {
+	domain := strings.TrimPrefix(rule, wildcardPrefix)
+	if e.wildcards[domain] {
+		return true
+	}
+
+	if strings.HasPrefix(rule, wildcardPrefix) {
+		if e.parents[domain] {
+			return true
+		}
+	} else if e.domains[domain] {
+		return true
+	}
+
+	for i := strings.IndexByte(domain, '.'); i != -1; i = strings.IndexByte(domain, '.') {
+		domain = domain[i+1:]
+		if e.wildcards[domain] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Compile applies exceptions, sorts and deduplicates rules, then removes
+// domains and wildcards already matched by a wildcard.
+//
+// Exceptions apply to the rules of all sources; a wildcard that matches an
+// allowed domain is removed, because tables cannot hold exceptions.
+func Compile(rules []string) []string {
+	allowed := exceptions{domains: map[string]bool{}, wildcards: map[string]bool{}, parents: map[string]bool{}}
+
+	for _, rule := range rules {
+		if strings.HasPrefix(rule, ExceptionPrefix) {
+			allowed.add(rule[len(ExceptionPrefix):])
+		}
+	}
+
+	wildcards := map[string]bool{}
+	unique := map[string]bool{}
+
+	for _, rule := range rules {
+		if strings.HasPrefix(rule, ExceptionPrefix) || unique[rule] {
+			continue
+		}
+
+		if allowed.match(rule) {
+			continue
+		}
+
+		unique[rule] = true
+
+		if strings.HasPrefix(rule, wildcardPrefix) {
+			wildcards[rule[len(wildcardPrefix):]] = true
+		}
+	}
+
+	compiled := make([]string, 0, len(unique))
+
+	for rule := range unique {
+		domain := strings.TrimPrefix(rule, wildcardPrefix)
+
+		covered := false
+		for i := strings.IndexByte(domain, '.'); i != -1 && !covered; i = strings.IndexByte(domain, '.') {
+			domain = domain[i+1:]
+			covered = wildcards[domain]
+		}
+
+		if !covered {
+			compiled = append(compiled, rule)
+		}
+	}
+
+	sort.Strings(compiled)
+	return compiled
+}
+
+// Build fetches or reads all sources with an accepted license and compiles
+// them.
+func Build(ctx context.Context, m *Manifest, f *fetch.Fetcher) (*Artifact, error) {
+	var artifact Artifact
+	var rules []string
+	categories := map[string]verdict.Category{}
+
+	var sources []Source
+	for i := range m.Sources {
+		expanded, err := m.Sources[i].expand()
+		if err != nil {
+			return nil, err
+		}
+
+		sources = append(sources, expanded...)
+	}
+
+	for i := range sources {
+		src := &sources[i]
+
+		if !m.Accepts(src) {
+			log.Printf("Skipping %s: license %s is not accepted", src.Name, src.License)
+			continue
+		}
+
+		var data []byte
+		var err error
+
+		if src.Path != "" {
+			data, err = ioutil.ReadFile(src.Path)
+		} else {
+			data, err = f.Fetch(ctx, &src.Source)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		parsed, err := Parse(src.Format, bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", src.Name, err)
+		}
+
+		log.Printf("Parsed %d rules from %s", len(parsed), src.Name)
+
+		hash := sha256.Sum256(data)
+		artifact.Sources = append(artifact.Sources, CompiledSource{Name: src.Name, SHA256: hex.EncodeToString(hash[:]), License: src.License})
+		rules = append(rules, parsed...)
+
+		if src.Category != "" {
+			for _, rule := range parsed {
+				categories[rule] = moreSevere(categories[rule], src.Category)
+			}
+		}
+	}
+
+	artifact.Rules = Compile(rules)
+
+	// rules removed by Compile are matched by a wildcard, which has its own
+	// category
+	if len(categories) > 0 {
+		artifact.Categories = make(map[string]verdict.Category, len(artifact.Rules))
+		for _, rule := range artifact.Rules {
+			if category, ok := categories[rule]; ok {
+				artifact.Categories[rule] = category
+			}
+		}
+	}
+
+	return &artifact, nil
+}
+
+// Write writes a compiled blocklist: each rule is followed by its category, if
+// it has one.
+func (a *Artifact) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, artifactHeader)
+	for _, src := range a.Sources {
+		fmt.Fprintf(bw, "%s%s %s %s\n", sourcePrefix, src.Name, src.SHA256, src.License)
+	}
+
+	for _, rule := range a.Rules {
+		bw.WriteString(rule)
+		if category := a.Categories[rule]; category != "" {
+			bw.WriteByte(' ')
+			bw.WriteString(string(category))
+		}
+		bw.WriteByte('\n')
+	}
+
+	return bw.Flush()
+}
+
+// writeFile atomically replaces a file.
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".blocklist")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// WriteFile atomically replaces a file with a compiled blocklist.
+func (a *Artifact) WriteFile(path string) error {
+	return writeFile(path, a.Write)
+}
+
+// ReadArtifact reads a compiled blocklist.
+func ReadArtifact(r io.Reader) (*Artifact, error) {
+	var artifact Artifact
+
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() || scanner.Text() != artifactHeader {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+
+		return nil, ErrNotArtifact
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.HasPrefix(line, sourcePrefix) {
+			fields := strings.SplitN(line[len(sourcePrefix):], " ", 3)
+			if len(fields) < 2 {
+				return nil, ErrNotArtifact
+			}
+
+			src := CompiledSource{Name: fields[0], SHA256: fields[1]}
+			if len(fields) == 3 {
+				src.License = fields[2]
+			}
+
+			artifact.Sources = append(artifact.Sources, src)
+		} else if line != "" && line[0] != '#' {
+			rule := line
+			if i := strings.IndexByte(line, ' '); i != -1 {
+				rule = line[:i]
+
+				if artifact.Categories == nil {
+					artifact.Categories = map[string]verdict.Category{}
+				}
+				artifact.Categories[rule] = verdict.Category(line[i+1:])
+			}
+
+			artifact.Rules = append(artifact.Rules, rule)
+		}
+	}
+
+	return &artifact, scanner.Err()
+}
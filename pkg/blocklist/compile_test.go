@@ -0,0 +1,161 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package blocklist
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dimkr/dohli/pkg/fetch"
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+func ExampleCompile() {
+	fmt.Println(Compile([]string{
+		"b.example.com",
+		"a.example.com",
+		"*.example.com",
+		"example.com",
+		"*.a.example.com",
+		"a.example.com",
+		"example.org",
+	}))
+	// Output: [*.example.com example.com example.org]
+}
+
+func ExampleCompile_exceptions() {
+	rules, _ := Parse(FormatAdblock, strings.NewReader(`||ads.example.com^
+||example.net^
+@@||good.ads.example.com^
+||good.example.org^
+@@||example.org^
+`))
+
+	fmt.Println(Compile(append(rules, "tracker.example.com", "*.example.com", "bad.example.com")))
+	// Output: [*.example.net ads.example.com bad.example.com example.net tracker.example.com]
+}
+
+func TestBuild(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hosts":
+			w.Write([]byte("0.0.0.0 ads.example.com\n0.0.0.0 x.tracker.example.com\n0.0.0.0 bad.example.com\n"))
+
+		case "/nc":
+			w.Write([]byte("0.0.0.0 good.example.com\n"))
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "blocklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	domains := filepath.Join(dir, "domains")
+	if err := ioutil.WriteFile(domains, []byte("*.tracker.example.com\nbad.example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := Manifest{
+		Licenses: []string{"MIT"},
+		Sources: []Source{
+			{Source: fetch.Source{Name: "hosts", URL: server.URL + "/hosts"}, Format: FormatHosts, License: "MIT", Category: verdict.CategoryAds},
+			{Source: fetch.Source{Name: "nc", URL: server.URL + "/nc"}, Format: FormatHosts, License: "CC BY-NC"},
+			{Source: fetch.Source{Name: "local"}, Path: domains, Format: FormatDomains, License: "MIT", Category: verdict.CategoryMalware},
+		},
+	}
+
+	artifact, err := Build(context.Background(), &m, &fetch.Fetcher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(artifact.Sources) != 2 || artifact.Sources[0].Name != "hosts" || artifact.Sources[1].Name != "local" {
+		t.Error(artifact.Sources)
+	}
+
+	if len(artifact.Rules) != 3 || artifact.Rules[0] != "*.tracker.example.com" || artifact.Rules[1] != "ads.example.com" || artifact.Rules[2] != "bad.example.com" {
+		t.Error(artifact.Rules)
+	}
+
+	// the category of a rule found in multiple sources is the most severe one
+	if len(artifact.Categories) != 3 || artifact.Categories["*.tracker.example.com"] != verdict.CategoryMalware || artifact.Categories["ads.example.com"] != verdict.CategoryAds || artifact.Categories["bad.example.com"] != verdict.CategoryMalware {
+		t.Error(artifact.Categories)
+	}
+
+	path := filepath.Join(dir, "hosts.block")
+	if err := artifact.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsArtifact(data) {
+		t.Error(string(data))
+	}
+
+	read, err := ReadArtifact(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(read.Sources) != 2 || read.Sources[0] != artifact.Sources[0] || read.Sources[1] != artifact.Sources[1] {
+		t.Error(read.Sources)
+	}
+
+	if len(read.Rules) != 3 || read.Rules[0] != artifact.Rules[0] || read.Rules[1] != artifact.Rules[1] || read.Rules[2] != artifact.Rules[2] {
+		t.Error(read.Rules)
+	}
+
+	if len(read.Categories) != 3 {
+		t.Error(read.Categories)
+	}
+
+	for rule, category := range artifact.Categories {
+		if read.Categories[rule] != category {
+			t.Error(rule)
+		}
+	}
+}
+
+func TestReadNotArtifact(t *testing.T) {
+	if _, err := ReadArtifact(bytes.NewReader([]byte("0.0.0.0 ads.example.com\n"))); err != ErrNotArtifact {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,199 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package blocklist
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dimkr/dohli/pkg/fetch"
+)
+
+// Index is the layout of a local checkout of a repository that lists multiple
+// sources, each with its own license.
+type Index string
+
+const (
+	// IndexStevenBlack is a checkout of https://github.com/StevenBlack/hosts:
+	// each source under data/ and under the chosen extensions has a hosts
+	// file and an update.json file with its license
+	IndexStevenBlack Index = "stevenblack"
+
+	// IndexEnergized is a checkout of
+	// https://github.com/EnergizedProtection/block: each source is listed in
+	// README.md, with a link to its hosts file and its license
+	IndexEnergized Index = "energized"
+)
+
+const energizedSourceLink = "[SOURCE]("
+
+func (i Index) valid() bool {
+	return i == "" || i == IndexStevenBlack || i == IndexEnergized
+}
+
+type stevenBlackSource struct {
+	Name    string `json:"name"`
+	License string `json:"license"`
+}
+
+// expandStevenBlack returns a source for each hosts file of a StevenBlack
+// checkout.
+func expandStevenBlack(src *Source) ([]Source, error) {
+	patterns := []string{filepath.Join(src.Path, "data", "*", "update.json")}
+	for _, extension := range src.Extensions {
+		patterns = append(patterns, filepath.Join(src.Path, "extensions", extension, "update.json"), filepath.Join(src.Path, "extensions", extension, "*", "update.json"))
+	}
+
+	var sources []Source
+
+	for _, pattern := range patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			j, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+
+			var meta stevenBlackSource
+			if err := json.Unmarshal(j, &meta); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+
+			dir := filepath.Dir(path)
+			rel, err := filepath.Rel(src.Path, dir)
+			if err != nil {
+				return nil, err
+			}
+
+			sources = append(sources, Source{
+				Source:   fetch.Source{Name: src.Name + "/" + filepath.ToSlash(rel)},
+				Path:     filepath.Join(dir, "hosts"),
+				Format:   FormatHosts,
+				License:  meta.License,
+				Category: src.Category,
+			})
+		}
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("%s: no sources in %s", src.Name, src.Path)
+	}
+
+	return sources, nil
+}
+
+// parseEnergizedLine parses a README line like "| [Name](home) | ... |
+// [SOURCE](url) | [License](link) |" and returns the name, URL and license
+// of the source.
+func parseEnergizedLine(line string) (string, string, string, bool) {
+	i := strings.Index(line, energizedSourceLink)
+	if i == -1 {
+		return "", "", "", false
+	}
+
+	rest := line[i+len(energizedSourceLink):]
+	end := strings.IndexByte(rest, ')')
+	if end <= 0 {
+		return "", "", "", false
+	}
+
+	url := rest[:end]
+	rest = rest[end+1:]
+
+	start := strings.IndexByte(rest, '[')
+	end = strings.IndexByte(rest, ']')
+	if start == -1 || end < start {
+		return "", "", "", false
+	}
+
+	license := strings.TrimSpace(rest[start+1 : end])
+
+	name := url
+	if start, end := strings.IndexByte(line, '['), strings.IndexByte(line, ']'); start != -1 && end > start+1 && start < i {
+		name = strings.TrimSpace(line[start+1 : end])
+	}
+
+	return name, url, license, true
+}
+
+// expandEnergized returns a source for each hosts file listed in the README of
+// an Energized checkout.
+func expandEnergized(src *Source) ([]Source, error) {
+	f, err := os.Open(filepath.Join(src.Path, "README.md"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var sources []Source
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, url, license, ok := parseEnergizedLine(scanner.Text())
+		if !ok {
+			continue
+		}
+
+		sources = append(sources, Source{
+			Source:   fetch.Source{Name: src.Name + "/" + name, URL: url},
+			Format:   FormatHosts,
+			License:  license,
+			Category: src.Category,
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("%s: no sources in %s", src.Name, src.Path)
+	}
+
+	return sources, nil
+}
+
+// expand returns the sources listed by an index, or the source itself.
+func (src *Source) expand() ([]Source, error) {
+	switch src.Index {
+	case IndexStevenBlack:
+		return expandStevenBlack(src)
+
+	case IndexEnergized:
+		return expandEnergized(src)
+	}
+
+	return []Source{*src}, nil
+}
@@ -0,0 +1,161 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package blocklist
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dimkr/dohli/pkg/fetch"
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBuildStevenBlack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stevenblack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFiles(t, dir, map[string]string{
+		"hosts":                           "0.0.0.0 unified.example.com\n",
+		"data/a/update.json":              `{"name": "a", "license": "MIT"}`,
+		"data/a/hosts":                    "0.0.0.0 a.example.com\n",
+		"data/nc/update.json":             `{"name": "nc", "license": "CC BY-NC-SA 4.0"}`,
+		"data/nc/hosts":                   "0.0.0.0 nc.example.com\n",
+		"extensions/gambling/update.json": `{"name": "gambling", "license": "CC BY 4.0"}`,
+		"extensions/gambling/hosts":       "0.0.0.0 gambling.example.com\n",
+		"extensions/porn/b/update.json":   `{"name": "b", "license": "MIT"}`,
+		"extensions/porn/b/hosts":         "0.0.0.0 porn.example.com\n",
+		"extensions/social/update.json":   `{"name": "social", "license": "MIT"}`,
+		"extensions/social/hosts":         "0.0.0.0 social.example.com\n",
+	})
+
+	m := Manifest{
+		Licenses: []string{"MIT", "CC BY"},
+		Sources: []Source{
+			{Source: fetch.Source{Name: "stevenblack"}, Path: dir, Index: IndexStevenBlack, Extensions: []string{"gambling", "porn"}, Category: verdict.CategoryAds},
+		},
+	}
+
+	artifact, err := Build(context.Background(), &m, &fetch.Fetcher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each source is filtered by its own license, and the unified file is
+	// never used
+	if len(artifact.Sources) != 3 || artifact.Sources[0].Name != "stevenblack/data/a" || artifact.Sources[1].Name != "stevenblack/extensions/gambling" || artifact.Sources[2].Name != "stevenblack/extensions/porn/b" || artifact.Sources[0].License != "MIT" {
+		t.Error(artifact.Sources)
+	}
+
+	if len(artifact.Rules) != 3 || artifact.Rules[0] != "a.example.com" || artifact.Rules[1] != "gambling.example.com" || artifact.Rules[2] != "porn.example.com" {
+		t.Error(artifact.Rules)
+	}
+
+	if artifact.Categories["a.example.com"] != verdict.CategoryAds {
+		t.Error(artifact.Categories)
+	}
+}
+
+func TestParseEnergizedLine(t *testing.T) {
+	for line, expected := range map[string][3]string{
+		"| [AdAway](https://adaway.org) | [@AdAway](https://github.com/AdAway) | [SOURCE](https://adaway.org/hosts.txt) | [CC BY 3.0](https://creativecommons.org/licenses/by/3.0/) |": {"AdAway", "https://adaway.org/hosts.txt", "CC BY 3.0"},
+		"| [SOURCE](https://example.com/hosts) | [MIT](https://opensource.org/licenses/MIT) |":                                                                                         {"https://example.com/hosts", "https://example.com/hosts", "MIT"},
+	} {
+		name, url, license, ok := parseEnergizedLine(line)
+		if !ok || name != expected[0] || url != expected[1] || license != expected[2] {
+			t.Error(line, name, url, license)
+		}
+	}
+
+	for _, line := range []string{"", "# Sources", "| [SOURCE](https://example.com/hosts) |", "| [SOURCE]() | [MIT](x) |"} {
+		if _, _, _, ok := parseEnergizedLine(line); ok {
+			t.Error(line)
+		}
+	}
+}
+
+func TestBuildEnergized(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			w.Write([]byte("0.0.0.0 a.example.com\n"))
+
+		case "/nc":
+			w.Write([]byte("0.0.0.0 nc.example.com\n"))
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "energized")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFiles(t, dir, map[string]string{
+		"README.md": "# Sources\n" +
+			"| [A](https://a.example.com) | [SOURCE](" + server.URL + "/a) | [BSD](https://opensource.org/licenses/BSD-3-Clause) |\n" +
+			"| [NC](https://nc.example.com) | [SOURCE](" + server.URL + "/nc) | [CC BY-NC-SA 4.0](https://creativecommons.org/licenses/by-nc-sa/4.0/) |\n",
+	})
+
+	m := Manifest{
+		Licenses: []string{"BSD"},
+		Sources:  []Source{{Source: fetch.Source{Name: "energized"}, Path: dir, Index: IndexEnergized}},
+	}
+
+	artifact, err := Build(context.Background(), &m, &fetch.Fetcher{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(artifact.Sources) != 1 || artifact.Sources[0].Name != "energized/A" || artifact.Sources[0].License != "BSD" {
+		t.Error(artifact.Sources)
+	}
+
+	if len(artifact.Rules) != 1 || artifact.Rules[0] != "a.example.com" {
+		t.Error(artifact.Rules)
+	}
+}
@@ -0,0 +1,117 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package blocklist compiles domain blocklists from multiple sources.
+package blocklist
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dimkr/dohli/pkg/fetch"
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+// Source is a blocklist source, with license metadata.
+type Source struct {
+	fetch.Source
+
+	// Path is a local file to read instead of fetching URL
+	Path string `json:"path,omitempty"`
+
+	Format  Format `json:"format"`
+	License string `json:"license"`
+
+	// Index is set when Path is a checkout of a repository that lists
+	// multiple sources, each with its own license; then, Format and License
+	// are ignored
+	Index Index `json:"index,omitempty"`
+
+	// Extensions are the extensions used from an IndexStevenBlack checkout
+	Extensions []string `json:"extensions,omitempty"`
+
+	// Category is the category of all domains in the source
+	Category verdict.Category `json:"category,omitempty"`
+}
+
+// Manifest is a list of blocklist sources.
+type Manifest struct {
+	// Licenses are the accepted licenses; a license matches if it starts
+	// with an accepted license, followed by a space or nothing (e.g. "CC BY"
+	// matches "CC BY 4.0" but not "CC BY-NC 4.0")
+	Licenses []string `json:"licenses"`
+
+	Sources []Source `json:"sources"`
+}
+
+// LoadManifest parses a JSON manifest.
+func LoadManifest(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+
+	for _, src := range m.Sources {
+		if src.Name == "" {
+			return nil, fmt.Errorf("a source has no name")
+		}
+
+		if (src.URL == "") == (src.Path == "") {
+			return nil, fmt.Errorf("%s: exactly one of url and path must be set", src.Name)
+		}
+
+		if !src.Index.valid() {
+			return nil, fmt.Errorf("%s: bad index: %s", src.Name, src.Index)
+		}
+
+		if src.Index != "" {
+			if src.Path == "" {
+				return nil, fmt.Errorf("%s: an index must be a path", src.Name)
+			}
+		} else if !src.Format.valid() {
+			return nil, fmt.Errorf("%s: bad format: %s", src.Name, src.Format)
+		}
+
+		if strings.ContainsAny(string(src.Category), " \t\r\n") {
+			return nil, fmt.Errorf("%s: bad category: %s", src.Name, src.Category)
+		}
+	}
+
+	return &m, nil
+}
+
+// Accepts determines whether or not the license of a source is accepted.
+func (m *Manifest) Accepts(src *Source) bool {
+	license := strings.ToUpper(strings.TrimSpace(src.License))
+
+	for _, accepted := range m.Licenses {
+		accepted = strings.ToUpper(strings.TrimSpace(accepted))
+
+		if accepted != "" && strings.HasPrefix(license, accepted) && (len(license) == len(accepted) || license[len(accepted)] == ' ') {
+			return true
+		}
+	}
+
+	return false
+}
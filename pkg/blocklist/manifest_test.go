@@ -0,0 +1,67 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package blocklist
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadManifest(t *testing.T) {
+	m, err := LoadManifest(strings.NewReader(`{
+	"licenses": ["MIT", "CC BY"],
+	"sources": [
+		{"name": "a", "url": "https://example.com/hosts", "format": "hosts", "license": "MIT"},
+		{"name": "b", "path": "/tmp/domains", "format": "domains", "license": "CC BY 4.0"},
+		{"name": "c", "url": "https://example.com/c", "format": "adblock", "license": "CC BY-NC 4.0"},
+		{"name": "d", "url": "https://example.com/d", "format": "adblock", "license": "MITx"},
+		{"name": "e", "url": "https://example.com/e", "format": "hosts"}
+	]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, accepted := range []bool{true, true, false, false, false} {
+		if m.Accepts(&m.Sources[i]) != accepted {
+			t.Error(m.Sources[i].Name)
+		}
+	}
+}
+
+func TestLoadBadManifest(t *testing.T) {
+	for _, j := range []string{
+		`{"sources": [{"url": "https://example.com/hosts", "format": "hosts"}]}`,
+		`{"sources": [{"name": "a", "format": "hosts"}]}`,
+		`{"sources": [{"name": "a", "url": "https://example.com/hosts", "path": "/hosts", "format": "hosts"}]}`,
+		`{"sources": [{"name": "a", "url": "https://example.com/hosts", "format": "xml"}]}`,
+		`{"sources": [{"name": "a", "url": "https://example.com/hosts", "format": "hosts", "category": "bad category"}]}`,
+		`{"sources": [{"name": "a", "url": "https://example.com/hosts", "index": "stevenblack"}]}`,
+		`{"sources": [{"name": "a", "path": "/hosts", "index": "other"}]}`,
+		`{`,
+	} {
+		if _, err := LoadManifest(strings.NewReader(j)); err == nil {
+			t.Error(j)
+		}
+	}
+}
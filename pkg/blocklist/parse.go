@@ -0,0 +1,251 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package blocklist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/dimkr/dohli/pkg/allowlist"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Format is the format of a blocklist.
+type Format string
+
+const (
+	// FormatHosts is a hosts file, where blocked domains point to 0.0.0.0,
+	// 127.0.0.1 or similar
+	FormatHosts Format = "hosts"
+
+	// FormatDomains is a list of domains, one per line; a domain prefixed
+	// by "*." matches all its subdomains
+	FormatDomains Format = "domains"
+
+	// FormatAdblock is an Adblock Plus filter list; only rules that block
+	// an entire domain ("||example.com^") and exceptions that allow an
+	// entire domain ("@@||example.com^") are used
+	FormatAdblock Format = "adblock"
+
+	// FormatURLs is a list of URLs, one per line; the host of each URL is
+	// blocked, unless it's shared by many sites
+	FormatURLs Format = "urls"
+)
+
+const wildcardPrefix = "*."
+
+// ExceptionPrefix is the prefix of exception rules, which remove rules from
+// a compiled blocklist
+const ExceptionPrefix = "@@"
+
+// names that appear in hosts files but must not be blocked
+var localNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+	"0.0.0.0":               true,
+}
+
+// hosts and registered domains of services that host content of many users
+// under the same host, like docs.google.com: a malicious URL under such a host
+// is not a reason to block the host
+var sharedDomains = map[string]bool{
+	"1drv.ms":                true,
+	"bit.ly":                 true,
+	"dropbox.com":            true,
+	"facebook.com":           true,
+	"forms.gle":              true,
+	"github.com":             true,
+	"goo.gl":                 true,
+	"google.com":             true,
+	"googleusercontent.com":  true,
+	"live.com":               true,
+	"microsoft.com":          true,
+	"office.com":             true,
+	"storage.googleapis.com": true,
+	"t.co":                   true,
+	"tinyurl.com":            true,
+	"wetransfer.com":         true,
+	"windows.net":            true,
+}
+
+func (f Format) valid() bool {
+	return f == FormatHosts || f == FormatDomains || f == FormatAdblock || f == FormatURLs
+}
+
+// normalize returns a rule in canonical form, or an empty string if the rule
+// should be skipped.
+func normalize(rule string) string {
+	if strings.HasPrefix(rule, ExceptionPrefix) {
+		if rule = normalize(rule[len(ExceptionPrefix):]); rule != "" {
+			return ExceptionPrefix + rule
+		}
+
+		return ""
+	}
+
+	rule, err := allowlist.ParseRule(rule)
+	if err != nil || localNames[rule] || net.ParseIP(rule) != nil {
+		return ""
+	}
+
+	// blocking entire TLDs is too dangerous
+	if !strings.Contains(strings.TrimPrefix(rule, wildcardPrefix), ".") {
+		return ""
+	}
+
+	return rule
+}
+
+func parseHostsLine(line string) []string {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+		return nil
+	}
+
+	return fields[1:]
+}
+
+func parseDomainsLine(line string) []string {
+	if fields := strings.Fields(line); len(fields) == 1 {
+		return fields
+	}
+
+	return nil
+}
+
+func parseAdblockLine(line string) []string {
+	prefix := ""
+	if strings.HasPrefix(line, ExceptionPrefix) {
+		prefix, line = ExceptionPrefix, line[len(ExceptionPrefix):]
+	}
+
+	if !strings.HasPrefix(line, "||") || !strings.HasSuffix(line, "^") {
+		return nil
+	}
+
+	domain := line[2 : len(line)-1]
+	if strings.ContainsAny(domain, "/*^$|@") {
+		return nil
+	}
+
+	// ||example.com^ blocks example.com and all its subdomains, and
+	// @@||example.com^ allows them
+	return []string{prefix + domain, prefix + wildcardPrefix + domain}
+}
+
+// URLHost returns the host of a URL in canonical form, or an empty string if
+// the URL is malformed, the host is an address or the host is shared by many
+// sites.
+func URLHost(rawurl string) string {
+	u, err := url.Parse(strings.TrimSpace(rawurl))
+	if err != nil {
+		return ""
+	}
+
+	host := normalize(u.Hostname())
+	if host == "" {
+		return ""
+	}
+
+	// the host of a URL under a public suffix, like s3.amazonaws.com, is
+	// shared too
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil || sharedDomains[domain] || sharedDomains[host] {
+		return ""
+	}
+
+	return host
+}
+
+func parseURLsLine(line string) []string {
+	if line == "" {
+		return nil
+	}
+
+	if host := URLHost(line); host != "" {
+		return []string{host}
+	}
+
+	return nil
+}
+
+// Parse parses a blocklist and returns its rules, in canonical form. Exception
+// rules are prefixed by ExceptionPrefix.
+func Parse(format Format, r io.Reader) ([]string, error) {
+	var parseLine func(string) []string
+	comment := "#"
+
+	switch format {
+	case FormatHosts:
+		parseLine = parseHostsLine
+
+	case FormatDomains:
+		parseLine = parseDomainsLine
+
+	case FormatAdblock:
+		parseLine = parseAdblockLine
+		comment = "!"
+
+	case FormatURLs:
+		parseLine = parseURLsLine
+
+		// URLs may contain #
+		comment = ""
+
+	default:
+		return nil, fmt.Errorf("bad format: %s", format)
+	}
+
+	var rules []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if comment != "" {
+			if i := strings.Index(line, comment); i != -1 {
+				line = line[:i]
+			}
+		}
+
+		for _, rule := range parseLine(strings.TrimSpace(line)) {
+			if rule = normalize(rule); rule != "" {
+				rules = append(rules, rule)
+			}
+		}
+	}
+
+	return rules, scanner.Err()
+}
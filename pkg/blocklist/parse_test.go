@@ -0,0 +1,98 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package blocklist
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func ExampleParse() {
+	rules, _ := Parse(FormatHosts, strings.NewReader(`# comment
+127.0.0.1 localhost
+0.0.0.0 0.0.0.0
+0.0.0.0 Ads.Example.COM. tracker.example.com # inline comment
+:: ipv6.example.com
+0.0.0.0 com
+example.org
+`))
+
+	fmt.Println(rules)
+	// Output: [ads.example.com tracker.example.com ipv6.example.com]
+}
+
+func TestParseDomains(t *testing.T) {
+	rules, err := Parse(FormatDomains, strings.NewReader("# comment\nads.example.com\n*.tracker.example.com\n\nbad domain\nbad/domain.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rules) != 2 || rules[0] != "ads.example.com" || rules[1] != "*.tracker.example.com" {
+		t.Error(rules)
+	}
+}
+
+func TestParseAdblock(t *testing.T) {
+	rules, err := Parse(FormatAdblock, strings.NewReader(`[Adblock Plus 2.0]
+! comment
+||ads.example.com^
+||tracker.example.com^$third-party
+@@||good.example.com^
+@@||other.example.com^$document
+||example.com/ads/*
+##.banner
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rules) != 4 || rules[0] != "ads.example.com" || rules[1] != "*.ads.example.com" || rules[2] != "@@good.example.com" || rules[3] != "@@*.good.example.com" {
+		t.Error(rules)
+	}
+}
+
+func TestParseURLs(t *testing.T) {
+	rules, err := Parse(FormatURLs, strings.NewReader(`https://Login.Bank.example.com/verify#account
+http://phish.example.net:8080/
+https://docs.google.com/forms/d/e/1FAIpQLS/viewform
+https://storage.googleapis.com/bucket/index.html
+http://192.0.2.1/login
+https://s3.amazonaws.com/bucket/index.html
+https://bucket.s3.amazonaws.com/index.html
+not a url
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rules) != 3 || rules[0] != "login.bank.example.com" || rules[1] != "phish.example.net" || rules[2] != "bucket.s3.amazonaws.com" {
+		t.Error(rules)
+	}
+}
+
+func TestParseBadFormat(t *testing.T) {
+	if _, err := Parse("xml", strings.NewReader("")); err == nil {
+		t.Error("bad format was accepted")
+	}
+}
@@ -0,0 +1,437 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package blocklist
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+// A table is a compiled blocklist in a binary format, which can be used
+// without parsing and without copying it to the heap:
+//
+//	magic       [8]byte
+//	sources     uint32 length, followed by the source lines of the text format
+//	categories  uint32 length, followed by newline-separated category names
+//	bloom       uint32 bits, uint32 hash functions, followed by the filter
+//	domains     section
+//	wildcards   section, without the *. prefix
+//
+// A section is a uint32 count and a uint32 blob length, followed by count+1
+// uint32 offsets, a blob of sorted strings and the category of each string: a
+// byte which is 0 for no category or the 1-based index of a category name. All
+// integers are little-endian.
+const tableMagic = "DOHLIBT2"
+
+// the maximum number of categories in a table
+const maxCategories = 255
+
+// ErrTooManyCategories is returned when a table cannot hold the categories of
+// a compiled blocklist.
+var ErrTooManyCategories = errors.New("too many categories")
+
+// ErrBadTable is returned when a table is malformed.
+var ErrBadTable = errors.New("bad blocklist table")
+
+// Table is a compiled blocklist in the binary format.
+type Table struct {
+	data       []byte
+	sources    []CompiledSource
+	categories []verdict.Category
+	bloom      bloomFilter
+	domains    section
+	wildcards  section
+
+	// the memory mapping to release on Close, if any
+	unmap func() error
+}
+
+type section struct {
+	count      int
+	offsets    []byte
+	blob       []byte
+	categories []byte
+}
+
+// IsTable determines whether or not the beginning of a file is the beginning
+// of a table.
+func IsTable(prefix []byte) bool {
+	return bytes.HasPrefix(prefix, []byte(tableMagic))
+}
+
+func (s *section) offset(i int) int {
+	return int(binary.LittleEndian.Uint32(s.offsets[i*4:]))
+}
+
+func (s *section) get(i int) []byte {
+	return s.blob[s.offset(i):s.offset(i+1)]
+}
+
+// compare compares a []byte to a string, without allocation.
+func compare(b []byte, s string) int {
+	for i := 0; i < len(b) && i < len(s); i++ {
+		if b[i] != s[i] {
+			if b[i] < s[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return len(b) - len(s)
+}
+
+// index returns the index of a key, or -1.
+func (s *section) index(key string) int {
+	i := sort.Search(s.count, func(i int) bool { return compare(s.get(i), key) >= 0 })
+	if i < s.count && compare(s.get(i), key) == 0 {
+		return i
+	}
+
+	return -1
+}
+
+func (s *section) validate(categories int) bool {
+	if s.offset(0) != 0 || s.offset(s.count) != len(s.blob) {
+		return false
+	}
+
+	for _, c := range s.categories {
+		if int(c) > categories {
+			return false
+		}
+	}
+
+	for i := 0; i < s.count; i++ {
+		if s.offset(i) > s.offset(i+1) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type tableReader struct {
+	data []byte
+	err  error
+}
+
+func (r *tableReader) uint32() int {
+	if r.err != nil || len(r.data) < 4 {
+		r.err = ErrBadTable
+		return 0
+	}
+
+	n := int(binary.LittleEndian.Uint32(r.data))
+	r.data = r.data[4:]
+	return n
+}
+
+func (r *tableReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || len(r.data) < n {
+		r.err = ErrBadTable
+		return nil
+	}
+
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *tableReader) section(categories int) section {
+	var s section
+	s.count = r.uint32()
+	blobLength := r.uint32()
+	s.offsets = r.bytes((s.count + 1) * 4)
+	s.blob = r.bytes(blobLength)
+	s.categories = r.bytes(s.count)
+
+	if r.err == nil && !s.validate(categories) {
+		r.err = ErrBadTable
+	}
+
+	return s
+}
+
+// ParseTable parses a table. The table refers to data, which must not be
+// modified.
+func ParseTable(data []byte) (*Table, error) {
+	if !IsTable(data) {
+		return nil, ErrBadTable
+	}
+
+	r := tableReader{data: data[len(tableMagic):]}
+	t := Table{data: data}
+
+	sources := r.bytes(r.uint32())
+
+	if categories := r.bytes(r.uint32()); len(categories) > 0 {
+		for _, name := range strings.Split(string(categories), "\n") {
+			t.categories = append(t.categories, verdict.Category(name))
+		}
+	}
+
+	t.bloom.bits = r.uint32()
+	t.bloom.hashes = r.uint32()
+	t.bloom.filter = r.bytes((t.bloom.bits + 7) / 8)
+
+	t.domains = r.section(len(t.categories))
+	t.wildcards = r.section(len(t.categories))
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if len(r.data) != 0 {
+		return nil, ErrBadTable
+	}
+
+	artifact, err := ReadArtifact(io.MultiReader(strings.NewReader(artifactHeader+"\n"), bytes.NewReader(sources)))
+	if err != nil {
+		return nil, err
+	}
+
+	t.sources = artifact.Sources
+	return &t, nil
+}
+
+// NewTable builds a table in memory.
+func NewTable(a *Artifact, bloomBitsPerKey int) *Table {
+	var buf bytes.Buffer
+	a.WriteTable(&buf, bloomBitsPerKey)
+
+	t, err := ParseTable(buf.Bytes())
+	if err != nil {
+		panic(err)
+	}
+
+	return t
+}
+
+// Sources returns the sources of a table.
+func (t *Table) Sources() []CompiledSource {
+	return t.sources
+}
+
+// Len returns the number of rules in a table.
+func (t *Table) Len() int {
+	return t.domains.count + t.wildcards.count
+}
+
+// Rules returns all rules in a table, sorted.
+func (t *Table) Rules() []string {
+	rules := make([]string, 0, t.Len())
+
+	for i := 0; i < t.domains.count; i++ {
+		rules = append(rules, string(t.domains.get(i)))
+	}
+
+	for i := 0; i < t.wildcards.count; i++ {
+		rules = append(rules, wildcardPrefix+string(t.wildcards.get(i)))
+	}
+
+	sort.Strings(rules)
+	return rules
+}
+
+func (t *Table) category(s *section, i int) verdict.Category {
+	if c := s.categories[i]; c != 0 {
+		return t.categories[c-1]
+	}
+
+	return ""
+}
+
+// Match returns the rule that matches a domain, or an empty string.
+func (t *Table) Match(domain string) string {
+	rule, _ := t.MatchCategory(domain)
+	return rule
+}
+
+// MatchCategory returns the rule that matches a domain and its category, or
+// empty strings.
+func (t *Table) MatchCategory(domain string) (string, verdict.Category) {
+	if t.bloom.mayContain("", domain) {
+		if i := t.domains.index(domain); i != -1 {
+			return domain, t.category(&t.domains, i)
+		}
+	}
+
+	for i := strings.IndexByte(domain, '.'); i != -1; i = strings.IndexByte(domain, '.') {
+		domain = domain[i+1:]
+		if t.bloom.mayContain(wildcardPrefix, domain) {
+			if j := t.wildcards.index(domain); j != -1 {
+				return wildcardPrefix + domain, t.category(&t.wildcards, j)
+			}
+		}
+	}
+
+	return "", ""
+}
+
+// Category returns the category of a rule, or an empty string.
+func (t *Table) Category(rule string) verdict.Category {
+	s, key := &t.domains, rule
+	if strings.HasPrefix(rule, wildcardPrefix) {
+		s, key = &t.wildcards, rule[len(wildcardPrefix):]
+	}
+
+	if i := s.index(key); i != -1 {
+		return t.category(s, i)
+	}
+
+	return ""
+}
+
+// Close releases the memory of a table opened using OpenTable.
+func (t *Table) Close() error {
+	if t.unmap == nil {
+		return nil
+	}
+
+	unmap := t.unmap
+	t.unmap = nil
+	return unmap()
+}
+
+func writeSection(w *bufio.Writer, keys []string, categories []byte) {
+	var n [4]byte
+
+	blobLength := 0
+	for _, key := range keys {
+		blobLength += len(key)
+	}
+
+	binary.LittleEndian.PutUint32(n[:], uint32(len(keys)))
+	w.Write(n[:])
+	binary.LittleEndian.PutUint32(n[:], uint32(blobLength))
+	w.Write(n[:])
+
+	offset := 0
+	binary.LittleEndian.PutUint32(n[:], 0)
+	w.Write(n[:])
+	for _, key := range keys {
+		offset += len(key)
+		binary.LittleEndian.PutUint32(n[:], uint32(offset))
+		w.Write(n[:])
+	}
+
+	for _, key := range keys {
+		w.WriteString(key)
+	}
+
+	w.Write(categories)
+}
+
+// WriteTable writes a compiled blocklist as a table. If bloomBitsPerKey is
+// positive, the table contains a bloom filter that speeds up lookup of
+// domains that do not match.
+func (a *Artifact) WriteTable(w io.Writer, bloomBitsPerKey int) error {
+	var domains, wildcards, names []string
+	indices := map[verdict.Category]byte{}
+
+	for _, category := range a.Categories {
+		if _, ok := indices[category]; ok || category == "" {
+			continue
+		}
+
+		if len(names) == maxCategories {
+			return ErrTooManyCategories
+		}
+
+		names = append(names, string(category))
+		indices[category] = byte(len(names))
+	}
+
+	for _, rule := range a.Rules {
+		if strings.HasPrefix(rule, wildcardPrefix) {
+			wildcards = append(wildcards, rule[len(wildcardPrefix):])
+		} else {
+			domains = append(domains, rule)
+		}
+	}
+
+	sort.Strings(domains)
+	sort.Strings(wildcards)
+
+	domainCategories := make([]byte, len(domains))
+	for i, domain := range domains {
+		domainCategories[i] = indices[a.Categories[domain]]
+	}
+
+	wildcardCategories := make([]byte, len(wildcards))
+	for i, domain := range wildcards {
+		wildcardCategories[i] = indices[a.Categories[wildcardPrefix+domain]]
+	}
+
+	var sources bytes.Buffer
+	(&Artifact{Sources: a.Sources}).Write(&sources)
+	sources.Next(len(artifactHeader) + 1)
+
+	bloom := newBloomFilter(len(a.Rules), bloomBitsPerKey)
+	for _, domain := range domains {
+		bloom.add("", domain)
+	}
+	for _, domain := range wildcards {
+		bloom.add(wildcardPrefix, domain)
+	}
+
+	bw := bufio.NewWriter(w)
+	var n [4]byte
+
+	bw.WriteString(tableMagic)
+
+	binary.LittleEndian.PutUint32(n[:], uint32(sources.Len()))
+	bw.Write(n[:])
+	bw.Write(sources.Bytes())
+
+	categories := strings.Join(names, "\n")
+	binary.LittleEndian.PutUint32(n[:], uint32(len(categories)))
+	bw.Write(n[:])
+	bw.WriteString(categories)
+
+	binary.LittleEndian.PutUint32(n[:], uint32(bloom.bits))
+	bw.Write(n[:])
+	binary.LittleEndian.PutUint32(n[:], uint32(bloom.hashes))
+	bw.Write(n[:])
+	bw.Write(bloom.filter)
+
+	writeSection(bw, domains, domainCategories)
+	writeSection(bw, wildcards, wildcardCategories)
+
+	return bw.Flush()
+}
+
+// WriteTableFile atomically replaces a file with a table.
+func (a *Artifact) WriteTableFile(path string, bloomBitsPerKey int) error {
+	return writeFile(path, func(w io.Writer) error {
+		return a.WriteTable(w, bloomBitsPerKey)
+	})
+}
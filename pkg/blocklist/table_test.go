@@ -0,0 +1,259 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package blocklist
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+var testArtifact = Artifact{
+	Sources: []CompiledSource{{Name: "a", SHA256: "1234", License: "CC BY 4.0"}},
+	Rules:   []string{"*.tracker.example.com", "ads.example.com", "b.example.org", "example.net"},
+	Categories: map[string]verdict.Category{
+		"*.tracker.example.com": verdict.CategoryTracking,
+		"ads.example.com":       verdict.CategoryAds,
+		"b.example.org":         verdict.CategoryMalware,
+	},
+}
+
+func testTable(t *testing.T, table *Table) {
+	if sources := table.Sources(); len(sources) != 1 || sources[0] != testArtifact.Sources[0] {
+		t.Error(sources)
+	}
+
+	if rules := table.Rules(); strings.Join(rules, ",") != strings.Join(testArtifact.Rules, ",") {
+		t.Error(rules)
+	}
+
+	for domain, rule := range map[string]string{
+		"ads.example.com":         "ads.example.com",
+		"x.ads.example.com":       "",
+		"tracker.example.com":     "",
+		"a.tracker.example.com":   "*.tracker.example.com",
+		"a.b.tracker.example.com": "*.tracker.example.com",
+		"b.example.org":           "b.example.org",
+		"a.example.org":           "",
+		"example.net":             "example.net",
+		"example.com":             "",
+		"":                        "",
+	} {
+		if match := table.Match(domain); match != rule {
+			t.Errorf("%s: %s != %s", domain, match, rule)
+		}
+
+		if match, category := table.MatchCategory(domain); match != rule || category != testArtifact.Categories[rule] {
+			t.Errorf("%s: %s != %s", domain, category, testArtifact.Categories[rule])
+		}
+	}
+
+	for _, rule := range testArtifact.Rules {
+		if category := table.Category(rule); category != testArtifact.Categories[rule] {
+			t.Errorf("%s: %s", rule, category)
+		}
+	}
+
+	if category := table.Category("example.com"); category != "" {
+		t.Error(category)
+	}
+}
+
+func TestTable(t *testing.T) {
+	testTable(t, NewTable(&testArtifact, 0))
+	testTable(t, NewTable(&testArtifact, 10))
+}
+
+func TestEmptyTable(t *testing.T) {
+	table := NewTable(&Artifact{}, 10)
+
+	if table.Len() != 0 || table.Match("example.com") != "" {
+		t.Error(table.Rules())
+	}
+}
+
+func TestOpenTable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blocklist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hosts.block")
+	if err := testArtifact.WriteTableFile(path, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := OpenTable(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testTable(t, table)
+
+	if err := table.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseBadTable(t *testing.T) {
+	var buf bytes.Buffer
+	testArtifact.WriteTable(&buf, 10)
+	data := buf.Bytes()
+
+	for i := 0; i < len(data); i++ {
+		if _, err := ParseTable(data[:i]); err == nil {
+			t.Fatal(i)
+		}
+	}
+
+	if _, err := ParseTable(append(data, 0)); err != ErrBadTable {
+		t.Error(err)
+	}
+}
+
+const benchmarkDomains = 300000
+
+func benchmarkArtifact() *Artifact {
+	rules := make([]string, 0, benchmarkDomains)
+	for i := 0; i < benchmarkDomains; i++ {
+		if i%10 == 0 {
+			rules = append(rules, fmt.Sprintf("*.tracker%d.example.com", i))
+		} else {
+			rules = append(rules, fmt.Sprintf("ads%d.example.com", i))
+		}
+	}
+
+	return &Artifact{Rules: Compile(rules)}
+}
+
+var benchmarkQueries = []string{"ads12345.example.com", "a.b.tracker1230.example.com", "www.wikipedia.org", "ads12345.example.net"}
+
+// matchMap is the map-based lookup used before tables were introduced.
+func matchMap(domains, wildcards map[string]bool, domain string) string {
+	if domains[domain] {
+		return domain
+	}
+
+	for i := strings.IndexByte(domain, '.'); i != -1; i = strings.IndexByte(domain, '.') {
+		domain = domain[i+1:]
+		if wildcards[domain] {
+			return wildcardPrefix + domain
+		}
+	}
+
+	return ""
+}
+
+func loadMap(data []byte) (map[string]bool, map[string]bool) {
+	domains, wildcards := map[string]bool{}, map[string]bool{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, wildcardPrefix) {
+			wildcards[line[len(wildcardPrefix):]] = true
+		} else if line != "" && line[0] != '#' {
+			domains[line] = true
+		}
+	}
+
+	return domains, wildcards
+}
+
+func BenchmarkMapLoad(b *testing.B) {
+	var buf bytes.Buffer
+	benchmarkArtifact().Write(&buf)
+	data := buf.Bytes()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		loadMap(data)
+	}
+}
+
+func BenchmarkTableOpen(b *testing.B) {
+	dir, err := ioutil.TempDir("", "blocklist")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hosts.block")
+	if err := benchmarkArtifact().WriteTableFile(path, 10); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		table, err := OpenTable(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		table.Close()
+	}
+}
+
+func BenchmarkMapMatch(b *testing.B) {
+	var buf bytes.Buffer
+	benchmarkArtifact().Write(&buf)
+	domains, wildcards := loadMap(buf.Bytes())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		matchMap(domains, wildcards, benchmarkQueries[i%len(benchmarkQueries)])
+	}
+}
+
+func benchmarkTableMatch(b *testing.B, bloomBitsPerKey int) {
+	table := NewTable(benchmarkArtifact(), bloomBitsPerKey)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		table.Match(benchmarkQueries[i%len(benchmarkQueries)])
+	}
+}
+
+func BenchmarkTableMatch(b *testing.B) {
+	benchmarkTableMatch(b, 0)
+}
+
+func BenchmarkTableMatchBloom(b *testing.B) {
+	benchmarkTableMatch(b, 10)
+}
@@ -0,0 +1,269 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package blocks keeps track of blocked domains.
+package blocks
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/dns"
+	"github.com/dimkr/dohli/pkg/verdict"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/publicsuffix"
+)
+
+const (
+	keyPrefix = "block:"
+
+	// zone blocks are stored separately, so a block of the registrable
+	// domain itself doesn't replace them
+	zoneKeyPrefix = "zone:"
+
+	// no expiration
+	blockedDomainTTL = 0
+)
+
+// SourceAdmin is the source of blocks performed by an administrator.
+const SourceAdmin = "admin"
+
+// Record describes a block and the verdict that caused it.
+type Record struct {
+	Domain string `json:"domain"`
+	verdict.Verdict
+	Time time.Time `json:"time"`
+
+	// Subdomains is set when all subdomains of the domain are blocked too.
+	Subdomains bool `json:"subdomains,omitempty"`
+}
+
+// ttl returns the number of seconds until the block expires (0 if it doesn't
+// expire), and false if the block has expired.
+func (record *Record) ttl() (int, bool) {
+	if record.Expires == nil {
+		return blockedDomainTTL, true
+	}
+
+	ttl := int(time.Until(*record.Expires) / time.Second)
+	return ttl, ttl > 0
+}
+
+// Stats counts blocked domains by source and by category.
+type Stats struct {
+	Total      int                      `json:"total"`
+	Sources    map[string]int           `json:"sources"`
+	Categories map[verdict.Category]int `json:"categories"`
+}
+
+// Registry blocks domains by inserting NXDOMAIN responses to the cache, and
+// keeps a record of each block.
+type Registry struct {
+	cache *cache.Cache
+}
+
+// OpenRegistry opens the registry of blocks stored in a cache.
+func OpenRegistry(c *cache.Cache) *Registry {
+	return &Registry{cache: c}
+}
+
+func (r *Registry) blockType(ctx context.Context, domain string, requestType dnsmessage.Type, extraText string, ttl int) error {
+	response, err := dns.BuildBlockedResponse(domain, requestType, extraText)
+	if err == nil {
+		r.cache.Set(ctx, domain, requestType, response, ttl)
+	}
+	return err
+}
+
+func (r *Registry) block(ctx context.Context, record *Record, requestType dnsmessage.Type) error {
+	ttl, ok := record.ttl()
+	if !ok {
+		return nil
+	}
+
+	extraText := record.Verdict.String()
+
+	if err := r.blockType(ctx, record.Domain, requestType, extraText, ttl); err != nil {
+		return err
+	}
+
+	var otherType dnsmessage.Type
+
+	switch requestType {
+	case dnsmessage.TypeA:
+		otherType = dnsmessage.TypeAAAA
+
+	case dnsmessage.TypeAAAA:
+		otherType = dnsmessage.TypeA
+	}
+
+	if otherType != 0 {
+		if err := r.blockType(ctx, record.Domain, otherType, extraText, ttl); err != nil {
+			return err
+		}
+	}
+
+	j, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	prefix := keyPrefix
+	if record.Subdomains {
+		prefix = zoneKeyPrefix
+	}
+
+	r.cache.Backend(ctx).Set(prefix+record.Domain, j, ttl)
+	return nil
+}
+
+// Block blocks a domain and stores the verdict that caused the block. If the
+// request type is A or AAAA, the domain is blocked for both. If the verdict
+// expires, so does the block.
+func (r *Registry) Block(ctx context.Context, domain string, requestType dnsmessage.Type, v *verdict.Verdict) error {
+	return r.block(ctx, &Record{Domain: domain, Verdict: *v, Time: time.Now().UTC()}, requestType)
+}
+
+// BlockZone blocks a registrable domain and all its subdomains.
+func (r *Registry) BlockZone(ctx context.Context, domain string, v *verdict.Verdict) error {
+	return r.block(ctx, &Record{Domain: domain, Verdict: *v, Time: time.Now().UTC(), Subdomains: true}, dnsmessage.TypeA)
+}
+
+// LookupZone returns the record of a block of all subdomains of a domain's
+// registrable domain, or nil.
+func (r *Registry) LookupZone(ctx context.Context, domain string) *Record {
+	zone, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return nil
+	}
+
+	return r.lookup(ctx, zoneKeyPrefix+zone)
+}
+
+// Restore blocks a domain for both A and AAAA, using a previously exported
+// record. Expired records are ignored.
+func (r *Registry) Restore(ctx context.Context, record *Record) error {
+	return r.block(ctx, record, dnsmessage.TypeA)
+}
+
+// Unblock removes the records of a domain's blocks and all cached responses for the
+// domain.
+func (r *Registry) Unblock(ctx context.Context, domain string) {
+	backend := r.cache.Backend(ctx)
+	backend.Delete(keyPrefix + domain)
+	backend.Delete(zoneKeyPrefix + domain)
+	r.cache.Flush(ctx, domain)
+}
+
+// UnblockRule removes the records of all blocks matched by an allowlist rule:
+// either a domain name, or a domain name prefixed by "*.", which matches all
+// subdomains of this domain.
+func (r *Registry) UnblockRule(ctx context.Context, rule string) {
+	if !strings.HasPrefix(rule, "*.") {
+		r.Unblock(ctx, rule)
+		return
+	}
+
+	backend := r.cache.Backend(ctx)
+	for _, prefix := range []string{keyPrefix, zoneKeyPrefix} {
+		for _, key := range backend.Keys(prefix + rule) {
+			r.Unblock(ctx, key[len(prefix):])
+		}
+	}
+}
+
+func (r *Registry) lookup(ctx context.Context, key string) *Record {
+	j := r.cache.Backend(ctx).Get(key)
+	if j == nil {
+		return nil
+	}
+
+	var record Record
+	if err := json.Unmarshal(j, &record); err != nil {
+		return nil
+	}
+
+	return &record
+}
+
+// Lookup returns the record of a domain's block, or the record of a block of
+// the domain and all its subdomains, or nil.
+func (r *Registry) Lookup(ctx context.Context, domain string) *Record {
+	if record := r.lookup(ctx, keyPrefix+domain); record != nil {
+		return record
+	}
+
+	return r.lookup(ctx, zoneKeyPrefix+domain)
+}
+
+// Domains returns all blocked domains.
+func (r *Registry) Domains(ctx context.Context) []string {
+	backend := r.cache.Backend(ctx)
+	seen := map[string]bool{}
+	domains := []string{}
+
+	for _, prefix := range []string{keyPrefix, zoneKeyPrefix} {
+		for _, key := range backend.Keys(prefix + "*") {
+			if domain := key[len(prefix):]; !seen[domain] {
+				seen[domain] = true
+				domains = append(domains, domain)
+			}
+		}
+	}
+
+	return domains
+}
+
+// Records returns the records of all blocks.
+func (r *Registry) Records(ctx context.Context) []Record {
+	backend := r.cache.Backend(ctx)
+	records := []Record{}
+
+	for _, prefix := range []string{keyPrefix, zoneKeyPrefix} {
+		for _, key := range backend.Keys(prefix + "*") {
+			if record := r.lookup(ctx, key); record != nil {
+				records = append(records, *record)
+			}
+		}
+	}
+
+	return records
+}
+
+// Stats returns statistics about blocked domains.
+func (r *Registry) Stats(ctx context.Context) *Stats {
+	stats := Stats{Sources: map[string]int{}, Categories: map[verdict.Category]int{}}
+
+	for _, record := range r.Records(ctx) {
+		stats.Total++
+		stats.Sources[record.Source]++
+
+		if record.Category != "" {
+			stats.Categories[record.Category]++
+		}
+	}
+
+	return &stats
+}
@@ -0,0 +1,253 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package blocks
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/dns"
+	"github.com/dimkr/dohli/pkg/verdict"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func openRegistry() (*Registry, *cache.Cache) {
+	c, err := cache.OpenCache(&cache.MemoryBackend{})
+	if err != nil {
+		panic(err)
+	}
+
+	return OpenRegistry(c), c
+}
+
+var adminVerdict = verdict.Verdict{Source: SourceAdmin, Confidence: 1}
+
+func TestBlock(t *testing.T) {
+	registry, c := openRegistry()
+
+	v := verdict.Verdict{Source: "hosts", Category: verdict.CategoryAds, Rule: "ads.example.com", Confidence: 1}
+	if err := registry.Block(context.Background(), "ads.example.com", dnsmessage.TypeAAAA, &v); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, requestType := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		response := c.Get(context.Background(), "ads.example.com", requestType)
+		if response == nil {
+			t.Fatal(requestType)
+		}
+
+		var p dnsmessage.Parser
+		if header, err := p.Start(response); err != nil || header.RCode != dnsmessage.RCodeNameError {
+			t.Error(requestType)
+		}
+
+		if _, text, ok := dns.GetExtendedError(response); !ok || text != "hosts (ads): ads.example.com" {
+			t.Error(text)
+		}
+	}
+
+	record := registry.Lookup(context.Background(), "ads.example.com")
+	if record == nil || record.Domain != "ads.example.com" || record.Verdict != v || record.Time.IsZero() {
+		t.Error(record)
+	}
+
+	if domains := registry.Domains(context.Background()); len(domains) != 1 || domains[0] != "ads.example.com" {
+		t.Error(domains)
+	}
+}
+
+func TestBlockExpiry(t *testing.T) {
+	registry, c := openRegistry()
+
+	expires := time.Now().Add(time.Hour)
+	v := verdict.Verdict{Source: "nod", Confidence: 1, Expires: &expires}
+	if err := registry.Block(context.Background(), "new.example.com", dnsmessage.TypeA, &v); err != nil {
+		t.Fatal(err)
+	}
+
+	if record := registry.Lookup(context.Background(), "new.example.com"); record == nil || record.Expires == nil || !record.Expires.Equal(expires) {
+		t.Error(record)
+	}
+
+	expired := time.Now().Add(-time.Hour)
+	record := Record{Domain: "old.example.com", Verdict: verdict.Verdict{Source: "nod", Confidence: 1, Expires: &expired}}
+	if err := registry.Restore(context.Background(), &record); err != nil {
+		t.Fatal(err)
+	}
+
+	if registry.Lookup(context.Background(), "old.example.com") != nil || c.Get(context.Background(), "old.example.com", dnsmessage.TypeA) != nil {
+		t.Error()
+	}
+}
+
+func TestBlockZone(t *testing.T) {
+	registry, c := openRegistry()
+
+	v := verdict.Verdict{Source: "tunnel", Category: verdict.CategoryTunneling, Confidence: 1, Zone: "tunnel.co.uk"}
+	if err := registry.BlockZone(context.Background(), "tunnel.co.uk", &v); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Get(context.Background(), "tunnel.co.uk", dnsmessage.TypeA) == nil {
+		t.Error()
+	}
+
+	for _, domain := range []string{"tunnel.co.uk", "a.tunnel.co.uk", "b.a.tunnel.co.uk"} {
+		if record := registry.LookupZone(context.Background(), domain); record == nil || record.Domain != "tunnel.co.uk" || !record.Subdomains {
+			t.Error(domain, record)
+		}
+	}
+
+	// a block of the registrable domain itself doesn't replace the zone block
+	if err := registry.Block(context.Background(), "tunnel.co.uk", dnsmessage.TypeA, &adminVerdict); err != nil {
+		t.Fatal(err)
+	}
+
+	if record := registry.LookupZone(context.Background(), "a.tunnel.co.uk"); record == nil || record.Source != "tunnel" || !record.Subdomains {
+		t.Error(record)
+	}
+
+	if record := registry.Lookup(context.Background(), "tunnel.co.uk"); record == nil || record.Source != SourceAdmin || record.Subdomains {
+		t.Error(record)
+	}
+
+	if domains := registry.Domains(context.Background()); len(domains) != 1 || domains[0] != "tunnel.co.uk" {
+		t.Error(domains)
+	}
+
+	if records := registry.Records(context.Background()); len(records) != 2 {
+		t.Error(records)
+	}
+
+	if err := registry.Block(context.Background(), "ads.example.com", dnsmessage.TypeA, &adminVerdict); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, domain := range []string{"example.co.uk", "co.uk", "www.ads.example.com", "ads.example.com"} {
+		if record := registry.LookupZone(context.Background(), domain); record != nil {
+			t.Error(domain, record)
+		}
+	}
+
+	registry.Unblock(context.Background(), "tunnel.co.uk")
+
+	if registry.LookupZone(context.Background(), "a.tunnel.co.uk") != nil || registry.Lookup(context.Background(), "tunnel.co.uk") != nil {
+		t.Error()
+	}
+}
+
+func TestBlockOtherType(t *testing.T) {
+	registry, c := openRegistry()
+
+	if err := registry.Block(context.Background(), "ads.example.com", dnsmessage.TypeMX, &adminVerdict); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Get(context.Background(), "ads.example.com", dnsmessage.TypeMX) == nil {
+		t.Error()
+	}
+
+	if c.Get(context.Background(), "ads.example.com", dnsmessage.TypeA) != nil {
+		t.Error()
+	}
+}
+
+func TestUnblock(t *testing.T) {
+	registry, c := openRegistry()
+
+	registry.Block(context.Background(), "ads.example.com", dnsmessage.TypeA, &adminVerdict)
+	registry.Unblock(context.Background(), "ads.example.com")
+
+	if registry.Lookup(context.Background(), "ads.example.com") != nil {
+		t.Error()
+	}
+
+	if c.Get(context.Background(), "ads.example.com", dnsmessage.TypeA) != nil {
+		t.Error()
+	}
+
+	if len(registry.Domains(context.Background())) != 0 {
+		t.Error()
+	}
+}
+
+func TestUnblockRule(t *testing.T) {
+	registry, _ := openRegistry()
+
+	registry.Block(context.Background(), "example.com", dnsmessage.TypeA, &adminVerdict)
+	registry.Block(context.Background(), "ads.example.com", dnsmessage.TypeA, &adminVerdict)
+	registry.BlockZone(context.Background(), "tunnel.example.com", &adminVerdict)
+	registry.Block(context.Background(), "ads.example.org", dnsmessage.TypeA, &adminVerdict)
+
+	// a wildcard rule matches subdomains only
+	registry.UnblockRule(context.Background(), "*.example.com")
+
+	if domains := registry.Domains(context.Background()); len(domains) != 2 || registry.Lookup(context.Background(), "example.com") == nil || registry.Lookup(context.Background(), "ads.example.org") == nil {
+		t.Error(domains)
+	}
+
+	registry.UnblockRule(context.Background(), "example.com")
+
+	if domains := registry.Domains(context.Background()); len(domains) != 1 || domains[0] != "ads.example.org" {
+		t.Error(domains)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	registry, c := openRegistry()
+
+	registry.Block(context.Background(), "ads.example.com", dnsmessage.TypeA, &adminVerdict)
+	records := registry.Records(context.Background())
+	if len(records) != 1 {
+		t.Fatal(records)
+	}
+
+	other, otherCache := openRegistry()
+	if err := other.Restore(context.Background(), &records[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if record := other.Lookup(context.Background(), "ads.example.com"); record == nil || *record != records[0] {
+		t.Error(record)
+	}
+
+	if otherCache.Get(context.Background(), "ads.example.com", dnsmessage.TypeAAAA) == nil || c.Get(context.Background(), "ads.example.com", dnsmessage.TypeAAAA) == nil {
+		t.Error()
+	}
+}
+
+func TestStats(t *testing.T) {
+	registry, _ := openRegistry()
+
+	registry.Block(context.Background(), "ads.example.com", dnsmessage.TypeA, &verdict.Verdict{Source: "hosts", Category: verdict.CategoryAds})
+	registry.Block(context.Background(), "malware.example.com", dnsmessage.TypeA, &verdict.Verdict{Source: "urlhaus", Category: verdict.CategoryMalware})
+	registry.Block(context.Background(), "tracking.example.com", dnsmessage.TypeA, &verdict.Verdict{Source: "hosts", Category: verdict.CategoryAds})
+	registry.Block(context.Background(), "example.com", dnsmessage.TypeA, &adminVerdict)
+
+	stats := registry.Stats(context.Background())
+	if stats.Total != 4 || stats.Sources["hosts"] != 2 || stats.Sources["urlhaus"] != 1 || stats.Sources[SourceAdmin] != 1 || stats.Categories[verdict.CategoryAds] != 2 || stats.Categories[verdict.CategoryMalware] != 1 || len(stats.Categories) != 2 {
+		t.Error(stats)
+	}
+}
@@ -0,0 +1,148 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package blocks
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+const (
+	dryRunKeyPrefix = "dryrun:"
+
+	// counters are kept separately, so they can be updated atomically by
+	// multiple workers
+	dryRunFirstSeenKeyPrefix = "dryrun-first:"
+	dryRunQueriesKeyPrefix   = "dryrun-queries:"
+	dryRunClientsKeyPrefix   = "dryrun-clients:"
+
+	// in seconds; records of domains that are not accessed again expire
+	dryRunTTL = 60 * 60 * 24 * 7
+)
+
+// DryRunRecord describes a domain that would have been blocked.
+//
+// Queries and Clients are lower bounds, because queries answered from the
+// cache are not recorded; the number of clients is approximate.
+type DryRunRecord struct {
+	Domain    string            `json:"domain"`
+	Verdicts  []verdict.Verdict `json:"verdicts"`
+	FirstSeen time.Time         `json:"first_seen"`
+	LastSeen  time.Time         `json:"last_seen"`
+	Queries   int               `json:"queries"`
+	Clients   int               `json:"clients"`
+}
+
+func (r *Registry) lookupDryRun(ctx context.Context, domain string) *DryRunRecord {
+	backend := r.cache.Backend(ctx)
+
+	j := backend.Get(dryRunKeyPrefix + domain)
+	if j == nil {
+		return nil
+	}
+
+	var record DryRunRecord
+	if err := json.Unmarshal(j, &record); err != nil {
+		return nil
+	}
+
+	record.FirstSeen = record.LastSeen
+	if b := backend.Get(dryRunFirstSeenKeyPrefix + domain); b != nil {
+		var firstSeen time.Time
+		if err := firstSeen.UnmarshalText(b); err == nil {
+			record.FirstSeen = firstSeen
+		}
+	}
+
+	record.Queries = int(backend.Counter(dryRunQueriesKeyPrefix + domain))
+	record.Clients = int(backend.PFCount(dryRunClientsKeyPrefix + domain))
+	return &record
+}
+
+// RecordDryRun records that a domain would have been blocked, the verdicts
+// that would have caused the block and the client that accessed the domain.
+func (r *Registry) RecordDryRun(ctx context.Context, domain string, verdicts []*verdict.Verdict, client string) error {
+	backend := r.cache.Backend(ctx)
+	now := time.Now().UTC()
+
+	record := DryRunRecord{Domain: domain, LastSeen: now}
+	for _, v := range verdicts {
+		record.Verdicts = append(record.Verdicts, *v)
+	}
+
+	j, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	firstSeen, err := now.MarshalText()
+	if err != nil {
+		return err
+	}
+
+	backend.Set(dryRunKeyPrefix+domain, j, dryRunTTL)
+
+	if !backend.SetNX(dryRunFirstSeenKeyPrefix+domain, firstSeen, dryRunTTL) {
+		backend.Expire(dryRunFirstSeenKeyPrefix+domain, dryRunTTL)
+	}
+
+	backend.Incr(dryRunQueriesKeyPrefix+domain, dryRunTTL)
+	backend.Expire(dryRunQueriesKeyPrefix+domain, dryRunTTL)
+
+	if client != "" {
+		backend.PFAdd(dryRunClientsKeyPrefix+domain, client)
+		backend.Expire(dryRunClientsKeyPrefix+domain, dryRunTTL)
+	}
+
+	return nil
+}
+
+// DryRunReport returns all domains that would have been blocked, sorted by the
+// number of clients that accessed them.
+func (r *Registry) DryRunReport(ctx context.Context) []DryRunRecord {
+	keys := r.cache.Backend(ctx).Keys(dryRunKeyPrefix + "*")
+
+	records := make([]DryRunRecord, 0, len(keys))
+	for _, key := range keys {
+		if record := r.lookupDryRun(ctx, key[len(dryRunKeyPrefix):]); record != nil {
+			records = append(records, *record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Clients != records[j].Clients {
+			return records[i].Clients > records[j].Clients
+		}
+
+		if records[i].Queries != records[j].Queries {
+			return records[i].Queries > records[j].Queries
+		}
+
+		return records[i].Domain < records[j].Domain
+	})
+
+	return records
+}
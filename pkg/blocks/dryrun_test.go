@@ -0,0 +1,75 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package blocks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dimkr/dohli/pkg/verdict"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestDryRunReport(t *testing.T) {
+	registry, c := openRegistry()
+
+	hosts := &verdict.Verdict{Source: "hosts", Category: verdict.CategoryAds, Confidence: 1}
+	urlhaus := &verdict.Verdict{Source: "urlhaus", Category: verdict.CategoryMalware, Confidence: 1}
+
+	registry.RecordDryRun(context.Background(), "ads.example.com", []*verdict.Verdict{hosts}, "a")
+	registry.RecordDryRun(context.Background(), "malware.example.com", []*verdict.Verdict{urlhaus}, "a")
+	registry.RecordDryRun(context.Background(), "malware.example.com", []*verdict.Verdict{urlhaus, hosts}, "b")
+	registry.RecordDryRun(context.Background(), "malware.example.com", []*verdict.Verdict{urlhaus}, "a")
+	registry.RecordDryRun(context.Background(), "tracking.example.com", []*verdict.Verdict{hosts}, "")
+	registry.RecordDryRun(context.Background(), "tracking.example.com", []*verdict.Verdict{hosts}, "")
+
+	report := registry.DryRunReport(context.Background())
+	if len(report) != 3 {
+		t.Fatal(report)
+	}
+
+	if report[0].Domain != "malware.example.com" || report[0].Clients != 2 || report[0].Queries != 3 || len(report[0].Verdicts) != 1 || report[0].Verdicts[0] != *urlhaus || report[0].FirstSeen.After(report[0].LastSeen) {
+		t.Error(report[0])
+	}
+
+	if report[1].Domain != "ads.example.com" || report[1].Clients != 1 || report[1].Queries != 1 {
+		t.Error(report[1])
+	}
+
+	if report[2].Domain != "tracking.example.com" || report[2].Clients != 0 || report[2].Queries != 2 {
+		t.Error(report[2])
+	}
+
+	// dry-run records are not blocks
+	if len(registry.Domains(context.Background())) != 0 || c.Get(context.Background(), "ads.example.com", dnsmessage.TypeA) != nil {
+		t.Error()
+	}
+
+	// reports don't create or refresh counters
+	backend := c.Backend(context.Background())
+	backend.Delete(dryRunQueriesKeyPrefix + "ads.example.com")
+	registry.DryRunReport(context.Background())
+	if keys := backend.Keys(dryRunQueriesKeyPrefix + "ads.example.com"); len(keys) != 0 {
+		t.Error(keys)
+	}
+}
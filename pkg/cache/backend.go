@@ -24,9 +24,55 @@ package cache
 
 import "context"
 
+// Update adds a number to a counter, or a member to a HyperLogLog if Member is
+// set.
+type Update struct {
+	Key    string
+	Delta  int64
+	Member string
+}
+
 type CacheBackend interface {
 	Connect() error
 	WithContext(context.Context) CacheBackend
 	Set(string, []byte, int)
+
+	// SetNX sets the value of a key only if the key does not exist and
+	// returns whether or not it was set
+	SetNX(string, []byte, int) bool
+
+	// CompareAndSwap sets the value of a key only if its value is still the
+	// given one (nil if the key must not exist) and returns whether or not
+	// it was set
+	CompareAndSwap(string, []byte, []byte, int) bool
 	Get(string) []byte
+
+	// MGet returns the values of multiple keys, with nil for missing keys
+	MGet(...string) [][]byte
+	Delete(string)
+	Keys(string) []string
+
+	// Expire sets the expiry of a key
+	Expire(string, int)
+
+	// Incr increments a counter and returns its new value; the expiry is set
+	// when the counter is created
+	Incr(string, int) int64
+
+	// IncrBy adds a number to a counter and returns its new value; the
+	// expiry is set when the counter is created
+	IncrBy(string, int64, int) int64
+
+	// Counter returns the value of a counter without changing it, or 0
+	Counter(string) int64
+
+	// PFAdd adds a member to a HyperLogLog and PFCount returns the
+	// approximate number of unique members
+	PFAdd(string, string)
+	PFCount(string) int64
+
+	// Apply performs multiple updates at once and returns the new value of
+	// each counter, or the approximate number of unique members of each
+	// HyperLogLog; the expiry of each updated key is reset
+	Apply([]Update, int) []int64
 }
@@ -48,6 +48,14 @@ func (mb *MockBackend) Set(key string, value []byte, ttl int) {
 	mb.Called(key, value, ttl)
 }
 
+func (mb *MockBackend) SetNX(key string, value []byte, ttl int) bool {
+	return mb.Called(key, value, ttl).Bool(0)
+}
+
+func (mb *MockBackend) CompareAndSwap(key string, old, new []byte, ttl int) bool {
+	return mb.Called(key, old, new, ttl).Bool(0)
+}
+
 func (mb *MockBackend) Get(key string) []byte {
 	if val, ok := mb.Called(key).Get(0).([]byte); ok {
 		return val
@@ -56,6 +64,74 @@ func (mb *MockBackend) Get(key string) []byte {
 	return nil
 }
 
+func (mb *MockBackend) MGet(keys ...string) [][]byte {
+	if val, ok := mb.Called(keys).Get(0).([][]byte); ok {
+		return val
+	}
+
+	return make([][]byte, len(keys))
+}
+
+func (mb *MockBackend) Delete(key string) {
+	mb.Called(key)
+}
+
+func (mb *MockBackend) Keys(pattern string) []string {
+	if val, ok := mb.Called(pattern).Get(0).([]string); ok {
+		return val
+	}
+
+	return nil
+}
+
+func (mb *MockBackend) Incr(key string, expiry int) int64 {
+	if val, ok := mb.Called(key, expiry).Get(0).(int64); ok {
+		return val
+	}
+
+	return 0
+}
+
+func (mb *MockBackend) Counter(key string) int64 {
+	if val, ok := mb.Called(key).Get(0).(int64); ok {
+		return val
+	}
+
+	return 0
+}
+
+func (mb *MockBackend) Expire(key string, expiry int) {
+	mb.Called(key, expiry)
+}
+
+func (mb *MockBackend) PFAdd(key string, member string) {
+	mb.Called(key, member)
+}
+
+func (mb *MockBackend) PFCount(key string) int64 {
+	if val, ok := mb.Called(key).Get(0).(int64); ok {
+		return val
+	}
+
+	return 0
+}
+
+func (mb *MockBackend) IncrBy(key string, delta int64, expiry int) int64 {
+	if val, ok := mb.Called(key, delta, expiry).Get(0).(int64); ok {
+		return val
+	}
+
+	return 0
+}
+
+func (mb *MockBackend) Apply(updates []Update, expiry int) []int64 {
+	if val, ok := mb.Called(updates, expiry).Get(0).([]int64); ok {
+		return val
+	}
+
+	return make([]int64, len(updates))
+}
+
 func TestConnect(t *testing.T) {
 	backend := MockBackend{}
 
@@ -105,3 +181,18 @@ func TestSet(t *testing.T) {
 	backend.On("Set", getCacheKey("wikipedia.org", dnsmessage.TypeA), response, 3600).Return(response).Once()
 	cache.Set(context.Background(), "wikipedia.org", dnsmessage.TypeA, response, 3600)
 }
+
+func TestFlush(t *testing.T) {
+	backend := MockBackend{}
+
+	backend.On("Connect").Return(nil).Once()
+	cache, _ := OpenCache(&backend)
+
+	keys := []string{getCacheKey("wikipedia.org", dnsmessage.TypeA), getCacheKey("wikipedia.org", dnsmessage.TypeAAAA)}
+	backend.On("Keys", "wikipedia.org:*").Return(keys).Once()
+	backend.On("Delete", keys[0]).Once()
+	backend.On("Delete", keys[1]).Once()
+	cache.Flush(context.Background(), "wikipedia.org")
+
+	backend.AssertExpectations(t)
+}
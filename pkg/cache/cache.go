@@ -26,6 +26,8 @@ package cache
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"golang.org/x/net/dns/dnsmessage"
 )
@@ -48,6 +50,20 @@ func getCacheKey(domain string, requestType dnsmessage.Type) string {
 	return fmt.Sprintf("%s:%d", domain, int(requestType))
 }
 
+func parseCacheKey(key string) (string, dnsmessage.Type, bool) {
+	i := strings.LastIndexByte(key, ':')
+	if i == -1 {
+		return "", 0, false
+	}
+
+	requestType, err := strconv.ParseUint(key[i+1:], 10, 16)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return key[:i], dnsmessage.Type(requestType), true
+}
+
 // Get returns a cached DNS response, or nil.
 func (c *Cache) Get(ctx context.Context, domain string, requestType dnsmessage.Type) []byte {
 	if response := c.backend.WithContext(ctx).Get(getCacheKey(domain, requestType)); response != nil {
@@ -63,3 +79,55 @@ func (c *Cache) Get(ctx context.Context, domain string, requestType dnsmessage.T
 func (c *Cache) Set(ctx context.Context, domain string, requestType dnsmessage.Type, response []byte, expiry int) {
 	c.backend.WithContext(ctx).Set(getCacheKey(domain, requestType), response, expiry)
 }
+
+// Entries returns all cached DNS responses for a domain, by request type.
+func (c *Cache) Entries(ctx context.Context, domain string) map[dnsmessage.Type][]byte {
+	backend := c.backend.WithContext(ctx)
+	entries := map[dnsmessage.Type][]byte{}
+
+	for _, key := range backend.Keys(domain + ":*") {
+		keyDomain, requestType, ok := parseCacheKey(key)
+		if !ok || keyDomain != domain {
+			continue
+		}
+
+		if response := backend.Get(key); response != nil {
+			entries[requestType] = response
+		}
+	}
+
+	return entries
+}
+
+// Flush removes all cached DNS responses for a domain, regardless of the
+// request type.
+func (c *Cache) Flush(ctx context.Context, domain string) {
+	c.FlushMatching(ctx, domain)
+}
+
+// FlushSuffix removes all cached DNS responses for subdomains of a domain.
+func (c *Cache) FlushSuffix(ctx context.Context, suffix string) {
+	c.FlushMatching(ctx, "*."+suffix)
+}
+
+// FlushMatching removes all cached DNS responses for domains that match a
+// glob-style pattern, and returns the number of removed responses.
+func (c *Cache) FlushMatching(ctx context.Context, pattern string) int {
+	backend := c.backend.WithContext(ctx)
+
+	n := 0
+	for _, key := range backend.Keys(pattern + ":*") {
+		if _, _, ok := parseCacheKey(key); ok {
+			backend.Delete(key)
+			n++
+		}
+	}
+
+	return n
+}
+
+// Backend returns the cache backend, for storage of data other than DNS
+// responses.
+func (c *Cache) Backend(ctx context.Context) CacheBackend {
+	return c.backend.WithContext(ctx)
+}
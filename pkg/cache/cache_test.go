@@ -138,3 +138,204 @@ func TestCacheExpiry(t *testing.T) {
 		t.Error()
 	}
 }
+
+func TestCacheFlush(t *testing.T) {
+	cache, _ := OpenCache(&MemoryBackend{})
+
+	cache.Set(context.Background(), "wikipedia.org", dnsmessage.TypeA, []byte{1, 2, 3, 4}, 3600)
+	cache.Set(context.Background(), "wikipedia.org", dnsmessage.TypeAAAA, []byte{5, 6, 7, 8}, 3600)
+	cache.Set(context.Background(), "en.wikipedia.org", dnsmessage.TypeA, []byte{9, 10, 11, 12}, 3600)
+
+	cache.Flush(context.Background(), "wikipedia.org")
+
+	if cache.Get(context.Background(), "wikipedia.org", dnsmessage.TypeA) != nil {
+		t.Error()
+	}
+
+	if cache.Get(context.Background(), "wikipedia.org", dnsmessage.TypeAAAA) != nil {
+		t.Error()
+	}
+
+	if cache.Get(context.Background(), "en.wikipedia.org", dnsmessage.TypeA) == nil {
+		t.Error()
+	}
+}
+
+func TestCacheFlushSuffix(t *testing.T) {
+	cache, _ := OpenCache(&MemoryBackend{})
+
+	cache.Set(context.Background(), "wikipedia.org", dnsmessage.TypeA, []byte{1, 2, 3, 4}, 3600)
+	cache.Set(context.Background(), "en.wikipedia.org", dnsmessage.TypeA, []byte{5, 6, 7, 8}, 3600)
+	cache.Set(context.Background(), "en.m.wikipedia.org", dnsmessage.TypeA, []byte{9, 10, 11, 12}, 3600)
+
+	cache.FlushSuffix(context.Background(), "wikipedia.org")
+
+	if cache.Get(context.Background(), "wikipedia.org", dnsmessage.TypeA) == nil {
+		t.Error()
+	}
+
+	if cache.Get(context.Background(), "en.wikipedia.org", dnsmessage.TypeA) != nil {
+		t.Error()
+	}
+
+	if cache.Get(context.Background(), "en.m.wikipedia.org", dnsmessage.TypeA) != nil {
+		t.Error()
+	}
+}
+
+func TestCacheEntries(t *testing.T) {
+	cache, _ := OpenCache(&MemoryBackend{})
+
+	cache.Set(context.Background(), "wikipedia.org", dnsmessage.TypeA, []byte{1, 2, 3, 4}, 3600)
+	cache.Set(context.Background(), "wikipedia.org", dnsmessage.TypeAAAA, []byte{5, 6, 7, 8}, 3600)
+	cache.Set(context.Background(), "en.wikipedia.org", dnsmessage.TypeA, []byte{9, 10, 11, 12}, 3600)
+
+	entries := cache.Entries(context.Background(), "wikipedia.org")
+	if len(entries) != 2 || !reflect.DeepEqual(entries[dnsmessage.TypeA], []byte{1, 2, 3, 4}) || !reflect.DeepEqual(entries[dnsmessage.TypeAAAA], []byte{5, 6, 7, 8}) {
+		t.Error(entries)
+	}
+}
+
+func TestCacheFlushMatching(t *testing.T) {
+	cache, _ := OpenCache(&MemoryBackend{})
+
+	cache.Set(context.Background(), "ads1.example.com", dnsmessage.TypeA, []byte{1, 2, 3, 4}, 3600)
+	cache.Set(context.Background(), "ads2.example.com", dnsmessage.TypeA, []byte{5, 6, 7, 8}, 3600)
+	cache.Set(context.Background(), "www.example.com", dnsmessage.TypeA, []byte{9, 10, 11, 12}, 3600)
+
+	if n := cache.FlushMatching(context.Background(), "ads*.example.com"); n != 2 {
+		t.Error(n)
+	}
+
+	if cache.Get(context.Background(), "www.example.com", dnsmessage.TypeA) == nil {
+		t.Error()
+	}
+}
+
+func TestMemoryBackendIncr(t *testing.T) {
+	cache, _ := OpenCache(&MemoryBackend{})
+	backend := cache.Backend(context.Background())
+
+	for i := int64(1); i <= 3; i++ {
+		if n := backend.Incr("counter", 1); n != i {
+			t.Error(n)
+		}
+	}
+
+	if n := backend.IncrBy("counter", 0, 1); n != 3 {
+		t.Error(n)
+	}
+
+	if n := backend.IncrBy("counter", 2, 1); n != 5 {
+		t.Error(n)
+	}
+
+	if n := backend.Counter("counter"); n != 5 {
+		t.Error(n)
+	}
+
+	if n := backend.Counter("missing"); n != 0 {
+		t.Error(n)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	if n := backend.Incr("counter", 1); n != 1 {
+		t.Error(n)
+	}
+}
+
+func TestMemoryBackendSetNX(t *testing.T) {
+	cache, _ := OpenCache(&MemoryBackend{})
+	backend := cache.Backend(context.Background())
+
+	if !backend.SetNX("key", []byte{1}, 0) {
+		t.Error()
+	}
+
+	if backend.SetNX("key", []byte{2}, 0) {
+		t.Error()
+	}
+
+	if value := backend.Get("key"); len(value) != 1 || value[0] != 1 {
+		t.Error(value)
+	}
+}
+
+func TestMemoryBackendPFAdd(t *testing.T) {
+	cache, _ := OpenCache(&MemoryBackend{})
+	backend := cache.Backend(context.Background())
+
+	for _, member := range []string{"b", "a", "b", "c", "a"} {
+		backend.PFAdd("set", member)
+	}
+
+	if n := backend.PFCount("set"); n != 3 {
+		t.Error(n)
+	}
+
+	if n := backend.PFCount("missing"); n != 0 {
+		t.Error(n)
+	}
+
+	backend.Expire("set", 1)
+	time.Sleep(2 * time.Second)
+
+	if n := backend.PFCount("set"); n != 0 {
+		t.Error(n)
+	}
+}
+
+func TestMemoryBackendApply(t *testing.T) {
+	cache, _ := OpenCache(&MemoryBackend{})
+	backend := cache.Backend(context.Background())
+
+	backend.Incr("counter", 60)
+
+	for i, expected := range [][]int64{{3, 1}, {5, 2}, {7, 2}} {
+		member := "a"
+		if i > 0 {
+			member = "b"
+		}
+
+		if results := backend.Apply([]Update{{Key: "counter", Delta: 2}, {Key: "set", Member: member}}, 1); !reflect.DeepEqual(results, expected) {
+			t.Error(i, results)
+		}
+	}
+
+	// the expiry of updated keys is reset
+	time.Sleep(2 * time.Second)
+
+	if n := backend.PFCount("set"); n != 0 {
+		t.Error(n)
+	}
+
+	if n := backend.Incr("counter", 60); n != 1 {
+		t.Error(n)
+	}
+}
+
+func TestMemoryBackendCompareAndSwap(t *testing.T) {
+	cache, _ := OpenCache(&MemoryBackend{})
+	backend := cache.Backend(context.Background())
+
+	if backend.CompareAndSwap("key", []byte{1}, []byte{2}, 0) {
+		t.Error()
+	}
+
+	if !backend.CompareAndSwap("key", nil, []byte{1}, 0) {
+		t.Error()
+	}
+
+	if backend.CompareAndSwap("key", nil, []byte{2}, 0) || backend.CompareAndSwap("key", []byte{2}, []byte{3}, 0) {
+		t.Error()
+	}
+
+	if !backend.CompareAndSwap("key", []byte{1}, []byte{2}, 0) {
+		t.Error()
+	}
+
+	if value := backend.Get("key"); len(value) != 1 || value[0] != 2 {
+		t.Error(value)
+	}
+}
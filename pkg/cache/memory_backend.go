@@ -23,19 +23,54 @@
 package cache
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"path"
+	"sync"
+	"time"
 
 	"github.com/coocood/freecache"
 )
 
-// MemoryBackend is an in-memory, freecache-based caching backend.
+const minSweepAt = 1024
+
+// memorySet is a HyperLogLog of MemoryBackend, which is an exact set.
+type memorySet struct {
+	members map[string]bool
+	expires time.Time
+}
+
+func (ms *memorySet) expired() bool {
+	return !ms.expires.IsZero() && !time.Now().Before(ms.expires)
+}
+
+func (ms *memorySet) expire(expiry int) {
+	if expiry > 0 {
+		ms.expires = time.Now().Add(time.Duration(expiry) * time.Second)
+	} else {
+		ms.expires = time.Time{}
+	}
+}
+
+// MemoryBackend is an in-memory, freecache-based caching backend. Its
+// HyperLogLogs are exact sets, kept outside of the cache because they can
+// grow larger than a cache entry.
 type MemoryBackend struct {
 	CacheBackend
 	cache *freecache.Cache
+	sets  map[string]*memorySet
+
+	// expired sets are removed when the number of sets reaches sweepAt
+	sweepAt int
+
+	// serializes counter increments, conditional sets and access to sets
+	lock sync.Mutex
 }
 
 func (mb *MemoryBackend) Connect() error {
 	mb.cache = freecache.NewCache(0)
+	mb.sets = map[string]*memorySet{}
 	return nil
 }
 
@@ -51,6 +86,206 @@ func (mb *MemoryBackend) Get(key string) []byte {
 	return nil
 }
 
+func (mb *MemoryBackend) MGet(keys ...string) [][]byte {
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i] = mb.Get(key)
+	}
+
+	return values
+}
+
 func (mb *MemoryBackend) Set(key string, value []byte, expiry int) {
 	mb.cache.Set([]byte(key), value, expiry)
 }
+
+func (mb *MemoryBackend) SetNX(key string, value []byte, expiry int) bool {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	if existing, _ := mb.cache.Get([]byte(key)); existing != nil {
+		return false
+	}
+
+	mb.cache.Set([]byte(key), value, expiry)
+	return true
+}
+
+func (mb *MemoryBackend) CompareAndSwap(key string, old, new []byte, expiry int) bool {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	current, _ := mb.cache.Get([]byte(key))
+	if (current == nil) != (old == nil) || !bytes.Equal(current, old) {
+		return false
+	}
+
+	mb.cache.Set([]byte(key), new, expiry)
+	return true
+}
+
+func (mb *MemoryBackend) Delete(key string) {
+	mb.lock.Lock()
+	delete(mb.sets, key)
+	mb.lock.Unlock()
+
+	mb.cache.Del([]byte(key))
+}
+
+func (mb *MemoryBackend) Keys(pattern string) []string {
+	var keys []string
+
+	mb.lock.Lock()
+	for key, set := range mb.sets {
+		if ok, _ := path.Match(pattern, key); ok && !set.expired() {
+			keys = append(keys, key)
+		}
+	}
+	mb.lock.Unlock()
+
+	it := mb.cache.NewIterator()
+	for entry := it.Next(); entry != nil; entry = it.Next() {
+		if ok, _ := path.Match(pattern, string(entry.Key)); ok {
+			keys = append(keys, string(entry.Key))
+		}
+	}
+
+	return keys
+}
+
+func (mb *MemoryBackend) Expire(key string, expiry int) {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	if set := mb.set(key); set != nil {
+		set.expire(expiry)
+	}
+
+	if value, _ := mb.cache.Get([]byte(key)); value != nil {
+		mb.cache.Set([]byte(key), value, expiry)
+	}
+}
+
+func (mb *MemoryBackend) Incr(key string, expiry int) int64 {
+	return mb.IncrBy(key, 1, expiry)
+}
+
+func (mb *MemoryBackend) incrBy(key string, delta int64, expiry int, keepExpiry bool) int64 {
+	n := delta
+	value, _ := mb.cache.Get([]byte(key))
+	ttl, _ := mb.cache.TTL([]byte(key))
+
+	if len(value) == 8 {
+		n += int64(binary.BigEndian.Uint64(value))
+
+		// the counter keeps its original expiry time
+		if keepExpiry && ttl > 0 {
+			expiry = int(ttl)
+		}
+	}
+
+	value = make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(n))
+	mb.cache.Set([]byte(key), value, expiry)
+
+	return n
+}
+
+func (mb *MemoryBackend) IncrBy(key string, delta int64, expiry int) int64 {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	return mb.incrBy(key, delta, expiry, true)
+}
+
+func (mb *MemoryBackend) Counter(key string) int64 {
+	if value, _ := mb.cache.Get([]byte(key)); len(value) == 8 {
+		return int64(binary.BigEndian.Uint64(value))
+	}
+
+	return 0
+}
+
+// set returns a set, or nil if it doesn't exist.
+func (mb *MemoryBackend) set(key string) *memorySet {
+	set, ok := mb.sets[key]
+	if !ok {
+		return nil
+	}
+
+	if set.expired() {
+		delete(mb.sets, key)
+		return nil
+	}
+
+	return set
+}
+
+// sweep removes expired sets.
+func (mb *MemoryBackend) sweep() {
+	for key, set := range mb.sets {
+		if set.expired() {
+			delete(mb.sets, key)
+		}
+	}
+
+	mb.sweepAt = 2 * len(mb.sets)
+	if mb.sweepAt < minSweepAt {
+		mb.sweepAt = minSweepAt
+	}
+}
+
+func (mb *MemoryBackend) pfAdd(key string, member string, expiry int, keepExpiry bool) int64 {
+	set := mb.set(key)
+	if set == nil {
+		if len(mb.sets) >= mb.sweepAt {
+			mb.sweep()
+		}
+
+		set = &memorySet{members: map[string]bool{}}
+		mb.sets[key] = set
+	}
+
+	set.members[member] = true
+
+	// the set keeps its expiry time
+	if !keepExpiry {
+		set.expire(expiry)
+	}
+
+	return int64(len(set.members))
+}
+
+func (mb *MemoryBackend) PFAdd(key string, member string) {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	mb.pfAdd(key, member, 0, true)
+}
+
+func (mb *MemoryBackend) PFCount(key string) int64 {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	if set := mb.set(key); set != nil {
+		return int64(len(set.members))
+	}
+
+	return 0
+}
+
+func (mb *MemoryBackend) Apply(updates []Update, expiry int) []int64 {
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	results := make([]int64, len(updates))
+	for i, update := range updates {
+		if update.Member != "" {
+			results[i] = mb.pfAdd(update.Key, update.Member, expiry, false)
+		} else {
+			results[i] = mb.incrBy(update.Key, update.Delta, expiry, false)
+		}
+	}
+
+	return results
+}
@@ -23,8 +23,10 @@
 package cache
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
+	"errors"
 	"log"
 	"os"
 	"time"
@@ -36,6 +38,13 @@ import (
 // Redis URL.
 const URLEnvironmentVariable = "REDIS_URL"
 
+// errChanged is returned when CompareAndSwap finds an unexpected value.
+var errChanged = errors.New("the value has changed")
+
+// scanBatchSize is the number of keys Redis is asked to examine in each SCAN
+// iteration.
+const scanBatchSize = 1000
+
 // RedisBackend is a Redis-based caching backend.
 type RedisBackend struct {
 	CacheBackend
@@ -70,8 +79,172 @@ func (rb *RedisBackend) Get(key string) []byte {
 	return rawResponse
 }
 
+func (rb *RedisBackend) MGet(keys ...string) [][]byte {
+	values := make([][]byte, len(keys))
+
+	results, err := rb.client.MGet(keys...).Result()
+	if err != nil {
+		return values
+	}
+
+	for i, result := range results {
+		if s, ok := result.(string); ok {
+			if value, err := hex.DecodeString(s); err == nil {
+				values[i] = value
+			}
+		}
+	}
+
+	return values
+}
+
 func (rb *RedisBackend) Set(key string, value []byte, expiry int) {
 	if _, err := rb.client.Set(key, hex.EncodeToString(value), time.Second*time.Duration(expiry)).Result(); err != nil {
 		log.Println("Failed to cache a DNS response: ", err)
 	}
 }
+
+func (rb *RedisBackend) SetNX(key string, value []byte, expiry int) bool {
+	set, err := rb.client.SetNX(key, hex.EncodeToString(value), time.Second*time.Duration(expiry)).Result()
+	if err != nil {
+		log.Println("Failed to set a cache entry: ", err)
+		return false
+	}
+
+	return set
+}
+
+func (rb *RedisBackend) CompareAndSwap(key string, old, new []byte, expiry int) bool {
+	err := rb.client.Watch(func(tx *redis.Tx) error {
+		current, err := tx.Get(key).Result()
+		if err == redis.Nil {
+			if old != nil {
+				return errChanged
+			}
+		} else if err != nil {
+			return err
+		} else if value, err := hex.DecodeString(current); err != nil || old == nil || !bytes.Equal(value, old) {
+			return errChanged
+		}
+
+		_, err = tx.Pipelined(func(pipe *redis.Pipeline) error {
+			pipe.Set(key, hex.EncodeToString(new), time.Second*time.Duration(expiry))
+			return nil
+		})
+		return err
+	}, key)
+
+	if err != nil && err != errChanged && err != redis.TxFailedErr {
+		log.Println("Failed to set a cache entry: ", err)
+	}
+
+	return err == nil
+}
+
+func (rb *RedisBackend) Delete(key string) {
+	if _, err := rb.client.Del(key).Result(); err != nil {
+		log.Println("Failed to delete a cache entry: ", err)
+	}
+}
+
+func (rb *RedisBackend) Keys(pattern string) []string {
+	var keys []string
+	var cursor uint64
+
+	for {
+		batch, next, err := rb.client.Scan(cursor, pattern, scanBatchSize).Result()
+		if err != nil {
+			log.Println("Failed to list cache entries: ", err)
+			return keys
+		}
+
+		keys = append(keys, batch...)
+
+		if next == 0 {
+			return keys
+		}
+
+		cursor = next
+	}
+}
+
+func (rb *RedisBackend) Expire(key string, expiry int) {
+	if _, err := rb.client.Expire(key, time.Second*time.Duration(expiry)).Result(); err != nil {
+		log.Println("Failed to set the expiry of a cache entry: ", err)
+	}
+}
+
+func (rb *RedisBackend) Incr(key string, expiry int) int64 {
+	return rb.IncrBy(key, 1, expiry)
+}
+
+func (rb *RedisBackend) IncrBy(key string, delta int64, expiry int) int64 {
+	n, err := rb.client.IncrBy(key, delta).Result()
+	if err != nil {
+		log.Println("Failed to increment a counter: ", err)
+		return 0
+	}
+
+	if n == delta {
+		if _, err := rb.client.Expire(key, time.Second*time.Duration(expiry)).Result(); err != nil {
+			log.Println("Failed to set the expiry of a counter: ", err)
+		}
+	}
+
+	return n
+}
+
+func (rb *RedisBackend) Counter(key string) int64 {
+	n, err := rb.client.Get(key).Int64()
+	if err != nil && err != redis.Nil {
+		log.Println("Failed to read a counter: ", err)
+	}
+
+	return n
+}
+
+func (rb *RedisBackend) PFAdd(key string, member string) {
+	if _, err := rb.client.PFAdd(key, member).Result(); err != nil {
+		log.Println("Failed to add a member to a HyperLogLog: ", err)
+	}
+}
+
+func (rb *RedisBackend) PFCount(key string) int64 {
+	n, err := rb.client.PFCount(key).Result()
+	if err != nil {
+		log.Println("Failed to count the members of a HyperLogLog: ", err)
+		return 0
+	}
+
+	return n
+}
+
+func (rb *RedisBackend) Apply(updates []Update, expiry int) []int64 {
+	results := make([]int64, len(updates))
+	cmds := make([]*redis.IntCmd, len(updates))
+
+	pipe := rb.client.Pipeline()
+	defer pipe.Close()
+
+	for i, update := range updates {
+		if update.Member != "" {
+			pipe.PFAdd(update.Key, update.Member)
+			cmds[i] = pipe.PFCount(update.Key)
+		} else {
+			cmds[i] = pipe.IncrBy(update.Key, update.Delta)
+		}
+
+		pipe.Expire(update.Key, time.Second*time.Duration(expiry))
+	}
+
+	if _, err := pipe.Exec(); err != nil {
+		log.Println("Failed to update counters: ", err)
+		return results
+	}
+
+	for i, cmd := range cmds {
+		results[i] = cmd.Val()
+	}
+
+	return results
+}
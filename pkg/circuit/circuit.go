@@ -0,0 +1,196 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package circuit protects external services from overload, and workers from
+// slow or unavailable services.
+package circuit
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/cache"
+)
+
+const (
+	keyPrefix = "circuit:"
+
+	// DefaultMaxFailures is the default number of failures that opens the
+	// circuit.
+	DefaultMaxFailures = 5
+
+	// DefaultFailureWindow is the default duration in which failures are
+	// counted, in seconds.
+	DefaultFailureWindow = 60
+
+	// DefaultOpenDuration is the default duration the circuit stays open, in
+	// seconds.
+	DefaultOpenDuration = 60
+)
+
+// ErrOpen is returned when the circuit is open.
+var ErrOpen = errors.New("circuit is open")
+
+// ErrRateLimited is returned when the rate limit is exceeded.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// Breaker is a circuit breaker and a rate limiter for calls to an external
+// service. Its state is stored in the cache, so it's shared by all workers.
+//
+// After MaxFailures failed calls in FailureWindow seconds, the circuit opens
+// and calls fail immediately for OpenDuration seconds. Then, the circuit is
+// half-open: one call is allowed through as a probe, while others keep failing
+// immediately. If the probe succeeds, the circuit closes; otherwise, it opens
+// again.
+//
+// Errors caused by the caller, like cancellation of its context, are not
+// failures of the service; IsFailure can exclude other errors, like responses
+// that indicate the service is up.
+//
+// If Rate is set, the service is called up to Rate times per Interval: a token
+// bucket of this size is refilled at the beginning of each interval.
+type Breaker struct {
+	MaxFailures   int
+	FailureWindow int
+	OpenDuration  int
+
+	Rate     int
+	Interval int
+
+	// IsFailure determines whether or not an error returned by a call is a
+	// failure of the service; if nil, all errors are failures
+	IsFailure func(error) bool
+
+	cache  *cache.Cache
+	prefix string
+
+	now func() time.Time
+}
+
+// OpenBreaker returns a circuit breaker for a service, without a rate limit.
+func OpenBreaker(c *cache.Cache, name string) *Breaker {
+	return &Breaker{
+		MaxFailures:   DefaultMaxFailures,
+		FailureWindow: DefaultFailureWindow,
+		OpenDuration:  DefaultOpenDuration,
+		Interval:      1,
+		cache:         c,
+		prefix:        keyPrefix + name + ":",
+		now:           time.Now,
+	}
+}
+
+// IsOpen returns true if the circuit is open. A half-open circuit is not open.
+func (b *Breaker) IsOpen(ctx context.Context) bool {
+	return b.cache.Backend(ctx).Get(b.prefix+"open") != nil
+}
+
+// take takes a token from the bucket of the current interval.
+func (b *Breaker) take(backend cache.CacheBackend) bool {
+	if b.Rate <= 0 {
+		return true
+	}
+
+	interval := b.Interval
+	if interval <= 0 {
+		interval = 1
+	}
+
+	window := b.now().Unix() / int64(interval)
+	return backend.Incr(b.prefix+"tokens:"+strconv.FormatInt(window, 10), interval+1) <= int64(b.Rate)
+}
+
+// open opens the circuit; once it expires, the circuit stays half-open until a
+// probe succeeds.
+func (b *Breaker) open(backend cache.CacheBackend) {
+	backend.Set(b.prefix+"open", []byte{1}, b.OpenDuration)
+	backend.Set(b.prefix+"half-open", []byte{1}, 0)
+	backend.Delete(b.prefix + "failures")
+	backend.Delete(b.prefix + "probe")
+}
+
+// fail records a failure and opens the circuit if there are too many.
+func (b *Breaker) fail(backend cache.CacheBackend) {
+	if backend.Incr(b.prefix+"failures", b.FailureWindow) >= int64(b.MaxFailures) {
+		b.open(backend)
+	}
+}
+
+// isFailure determines whether or not an error is a failure of the service.
+func (b *Breaker) isFailure(err error) bool {
+	return err != nil && (b.IsFailure == nil || b.IsFailure(err))
+}
+
+// Do calls a service unless the circuit is open or the rate limit is exceeded.
+// Skipped calls are reported to the context, if it was returned by
+// WithSkipped.
+func (b *Breaker) Do(ctx context.Context, call func(context.Context) error) error {
+	backend := b.cache.Backend(ctx)
+
+	if backend.Get(b.prefix+"open") != nil {
+		markSkipped(ctx)
+		return ErrOpen
+	}
+
+	// only one caller probes a half-open circuit; if the probe never ends,
+	// another caller can probe once the probe expires
+	probe := backend.Get(b.prefix+"half-open") != nil
+	if probe && !backend.SetNX(b.prefix+"probe", []byte{1}, b.OpenDuration) {
+		markSkipped(ctx)
+		return ErrOpen
+	}
+
+	if !b.take(backend) {
+		if probe {
+			backend.Delete(b.prefix + "probe")
+		}
+
+		markSkipped(ctx)
+		return ErrRateLimited
+	}
+
+	err := call(ctx)
+
+	switch {
+	case ctx.Err() != nil:
+		// the caller gave up, so the call says nothing about the service
+		// and another caller can probe
+		if probe {
+			backend.Delete(b.prefix + "probe")
+		}
+
+	case b.isFailure(err):
+		if probe {
+			b.open(backend)
+		} else {
+			b.fail(backend)
+		}
+
+	case probe:
+		backend.Delete(b.prefix + "half-open")
+		backend.Delete(b.prefix + "probe")
+	}
+
+	return err
+}
@@ -0,0 +1,207 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package circuit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/cache"
+)
+
+var errFailed = errors.New("call failed")
+
+func openBreakers(t *testing.T) (*Breaker, *Breaker) {
+	c, err := cache.OpenCache(&cache.MemoryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two workers that share a cache
+	return OpenBreaker(c, "test"), OpenBreaker(c, "test")
+}
+
+func TestOpen(t *testing.T) {
+	a, b := openBreakers(t)
+	calls := 0
+
+	fail := func(context.Context) error {
+		calls++
+		return errFailed
+	}
+
+	for i := 0; i < DefaultMaxFailures; i++ {
+		if err := a.Do(context.Background(), fail); err != errFailed {
+			t.Error(err)
+		}
+	}
+
+	ctx, skipped := WithSkipped(context.Background())
+
+	if err := b.Do(ctx, fail); err != ErrOpen {
+		t.Error(err)
+	}
+
+	if calls != DefaultMaxFailures || skipped.Len() != 1 || !a.IsOpen(ctx) {
+		t.Error(calls, skipped.Len())
+	}
+}
+
+func TestClose(t *testing.T) {
+	a, _ := openBreakers(t)
+	a.MaxFailures = 1
+	a.OpenDuration = 1
+
+	a.Do(context.Background(), func(context.Context) error { return errFailed })
+
+	if err := a.Do(context.Background(), func(context.Context) error { return nil }); err != ErrOpen {
+		t.Error(err)
+	}
+
+	time.Sleep(2 * time.Second)
+
+	if err := a.Do(context.Background(), func(context.Context) error { return nil }); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	a, b := openBreakers(t)
+
+	now := time.Unix(1000, 0)
+	for _, breaker := range []*Breaker{a, b} {
+		breaker.Rate = 3
+		breaker.Interval = 10
+		breaker.now = func() time.Time { return now }
+	}
+
+	ctx, skipped := WithSkipped(context.Background())
+	calls := 0
+
+	call := func(context.Context) error {
+		calls++
+		return nil
+	}
+
+	for _, breaker := range []*Breaker{a, b, a, b} {
+		breaker.Do(ctx, call)
+	}
+
+	if calls != 3 || skipped.Len() != 1 {
+		t.Error(calls, skipped.Len())
+	}
+
+	// the bucket is refilled in the next interval
+	now = now.Add(10 * time.Second)
+
+	if err := a.Do(ctx, call); err != nil || calls != 4 {
+		t.Error(err, calls)
+	}
+
+	// rate-limited calls don't open the circuit
+	if a.IsOpen(ctx) {
+		t.Error("circuit is open")
+	}
+}
+
+func TestHalfOpen(t *testing.T) {
+	a, b := openBreakers(t)
+	a.MaxFailures = 1
+
+	a.Do(context.Background(), func(context.Context) error { return errFailed })
+
+	// the circuit is half-open once it expires
+	a.cache.Backend(context.Background()).Delete(a.prefix + "open")
+
+	probed := make(chan struct{})
+	done := make(chan error)
+
+	go func() {
+		done <- a.Do(context.Background(), func(context.Context) error {
+			probed <- struct{}{}
+			<-probed
+			return errFailed
+		})
+	}()
+
+	<-probed
+
+	// only one call probes the service
+	if err := b.Do(context.Background(), func(context.Context) error { return nil }); err != ErrOpen {
+		t.Error(err)
+	}
+
+	probed <- struct{}{}
+
+	// a failed probe opens the circuit again
+	if err := <-done; err != errFailed || !a.IsOpen(context.Background()) {
+		t.Error(err)
+	}
+
+	a.cache.Backend(context.Background()).Delete(a.prefix + "open")
+
+	// a successful probe closes the circuit
+	if err := b.Do(context.Background(), func(context.Context) error { return nil }); err != nil {
+		t.Error(err)
+	}
+
+	calls := 0
+	for _, breaker := range []*Breaker{a, b} {
+		if err := breaker.Do(context.Background(), func(context.Context) error {
+			calls++
+			return nil
+		}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if calls != 2 {
+		t.Error(calls)
+	}
+}
+
+func TestNotFailures(t *testing.T) {
+	a, _ := openBreakers(t)
+	a.MaxFailures = 1
+
+	errNotFound := errors.New("not found")
+	a.IsFailure = func(err error) bool { return err != errNotFound }
+
+	if err := a.Do(context.Background(), func(context.Context) error { return errNotFound }); err != errNotFound || a.IsOpen(context.Background()) {
+		t.Error(err)
+	}
+
+	// calls abandoned by the caller are not failures of the service
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := a.Do(ctx, func(ctx context.Context) error { return ctx.Err() }); err != context.Canceled || a.IsOpen(context.Background()) {
+		t.Error(err)
+	}
+
+	if err := a.Do(context.Background(), func(context.Context) error { return errFailed }); err != errFailed || !a.IsOpen(context.Background()) {
+		t.Error(err)
+	}
+}
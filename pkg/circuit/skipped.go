@@ -0,0 +1,53 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package circuit
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type skippedKey struct{}
+
+// Skipped counts calls skipped by all circuit breakers.
+type Skipped struct {
+	n int32
+}
+
+// WithSkipped returns a context that counts calls skipped by circuit
+// breakers, so the caller can retry them later.
+func WithSkipped(parent context.Context) (context.Context, *Skipped) {
+	skipped := &Skipped{}
+	return context.WithValue(parent, skippedKey{}, skipped), skipped
+}
+
+func markSkipped(ctx context.Context) {
+	if skipped, ok := ctx.Value(skippedKey{}).(*Skipped); ok {
+		atomic.AddInt32(&skipped.n, 1)
+	}
+}
+
+// Len returns the number of skipped calls.
+func (skipped *Skipped) Len() int {
+	return int(atomic.LoadInt32(&skipped.n))
+}
@@ -0,0 +1,195 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package dga detects domain names produced by domain generation algorithms
+// (DGAs) of malware, which no list has seen yet.
+package dga
+
+//go:generate go run gen.go
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Source is the source name in verdicts of Blocker.
+const Source = "dga"
+
+// DefaultThreshold is the default score above which a domain is blocked.
+const DefaultThreshold = 0.5
+
+// shorter labels are too short to tell
+const minLength = 8
+
+// Features are the lexical features of the registered label of a domain: the
+// label under the public suffix.
+type Features struct {
+	Label string `json:"label"`
+
+	// Length is the number of characters
+	Length int `json:"length"`
+
+	// Entropy is the Shannon entropy of characters, in bits
+	Entropy float64 `json:"entropy"`
+
+	// ConsonantRun is the length of the longest run of consonants
+	ConsonantRun int `json:"consonant_run"`
+
+	// DigitRatio is the fraction of digits
+	DigitRatio float64 `json:"digit_ratio"`
+
+	// Likelihood is the average log-probability of each pair of adjacent
+	// characters, according to a model of benign labels
+	Likelihood float64 `json:"likelihood"`
+}
+
+func isConsonant(c byte) bool {
+	return c >= 'a' && c <= 'z' && strings.IndexByte("aeiouy", c) == -1
+}
+
+func likelihood(label string) float64 {
+	s := "^" + label + "$"
+	sum := 0.0
+
+	for i := 0; i < len(s)-1; i++ {
+		a, b := strings.IndexByte(alphabet, s[i]), strings.IndexByte(alphabet, s[i+1])
+		if a == -1 || b == -1 {
+			continue
+		}
+
+		sum += float64(bigramLogProb[a][b])
+	}
+
+	return sum / float64(len(s)-1)
+}
+
+// Extract returns the features of a domain, or nil if the domain has no
+// registered label.
+func Extract(domain string) *Features {
+	suffix, _ := publicsuffix.PublicSuffix(domain)
+	if suffix == domain {
+		return nil
+	}
+
+	rest := strings.TrimSuffix(strings.TrimSuffix(domain, suffix), ".")
+	label := rest[strings.LastIndexByte(rest, '.')+1:]
+
+	f := Features{Label: label, Length: len(label), Likelihood: likelihood(label)}
+
+	frequencies := map[rune]int{}
+	run := 0
+	digits := 0
+
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		frequencies[rune(c)]++
+
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+
+		if isConsonant(c) {
+			run++
+			if run > f.ConsonantRun {
+				f.ConsonantRun = run
+			}
+		} else {
+			run = 0
+		}
+	}
+
+	for _, n := range frequencies {
+		p := float64(n) / float64(len(label))
+		f.Entropy -= p * math.Log2(p)
+	}
+
+	f.DigitRatio = float64(digits) / float64(len(label))
+
+	return &f
+}
+
+// scale maps a value between low and high to a number between 0 and 1.
+func scale(value, low, high float64) float64 {
+	return math.Max(0, math.Min(1, (value-low)/(high-low)))
+}
+
+// Score returns a number between 0 (benign) and 1 (generated).
+func (f *Features) Score() float64 {
+	// punycode labels look random, and short labels are often acronyms
+	if f.Length < minLength || strings.HasPrefix(f.Label, "xn--") {
+		return 0
+	}
+
+	score := 0.6*scale(-f.Likelihood, 3.1, 3.9) +
+		0.15*scale(f.Entropy, 3, 4) +
+		0.15*scale(float64(f.ConsonantRun), 3, 6) +
+		0.1*scale(f.DigitRatio, 0, 0.3)
+
+	// long labels give more evidence
+	return score * (0.7 + 0.3*scale(float64(f.Length), minLength, 16))
+}
+
+func (f *Features) String() string {
+	return fmt.Sprintf("%s: score %.2f, length %d, entropy %.2f, consonant run %d, digit ratio %.2f, likelihood %.2f", f.Label, f.Score(), f.Length, f.Entropy, f.ConsonantRun, f.DigitRatio, f.Likelihood)
+}
+
+// Blocker blocks domains that look algorithmically generated. It does not
+// depend on any list or network access.
+type Blocker struct {
+	// Threshold is the minimum score of blocked domains
+	Threshold float64
+}
+
+// OpenBlocker returns a new blocker, with the default threshold.
+func OpenBlocker() *Blocker {
+	return &Blocker{Threshold: DefaultThreshold}
+}
+
+func (b *Blocker) Connect() error {
+	return nil
+}
+
+func (b *Blocker) IsAsync() bool {
+	return false
+}
+
+// IsBad returns a verdict with the score as its confidence, so weak verdicts
+// can be combined with others by the blocking policy.
+func (b *Blocker) IsBad(_ context.Context, msg *queue.DomainAccessMessage) *verdict.Verdict {
+	f := Extract(msg.Domain)
+	if f == nil {
+		return nil
+	}
+
+	score := f.Score()
+	if score < b.Threshold {
+		return nil
+	}
+
+	return &verdict.Verdict{Source: Source, Category: verdict.CategoryMalware, Rule: f.String(), Confidence: score}
+}
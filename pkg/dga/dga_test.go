@@ -0,0 +1,141 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dga
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dimkr/dohli/pkg/queue"
+)
+
+func loadCorpus(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var domains []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && line[0] != '#' {
+			domains = append(domains, line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return domains
+}
+
+// detectionRate returns the fraction of domains in a corpus that are blocked
+func detectionRate(t *testing.T, b *Blocker, path string) float64 {
+	domains := loadCorpus(t, path)
+	if len(domains) == 0 {
+		t.Fatal(path)
+	}
+
+	detected := 0
+	for _, domain := range domains {
+		if b.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: domain}) != nil {
+			detected++
+		}
+	}
+
+	return float64(detected) / float64(len(domains))
+}
+
+func TestCorpus(t *testing.T) {
+	b := OpenBlocker()
+
+	if rate := detectionRate(t, b, "testdata/dga.txt"); rate < 0.75 {
+		t.Errorf("detection rate: %.2f", rate)
+	}
+
+	if rate := detectionRate(t, b, "testdata/benign.txt"); rate > 0.02 {
+		t.Errorf("false positive rate: %.2f", rate)
+	}
+
+	if rate := detectionRate(t, b, "benign.txt"); rate > 0.02 {
+		t.Errorf("false positive rate on training data: %.2f", rate)
+	}
+}
+
+// lures are words that phishing and scam domains combine with brand names
+var lures = []string{"update", "support", "secure", "verif", "login", "signin", "download", "account", "wallet"}
+
+func TestTrainingLures(t *testing.T) {
+	// training on names like windows10-update raises the likelihood of the
+	// names the blocker should flag
+	for _, label := range loadCorpus(t, "benign.txt") {
+		for _, lure := range lures {
+			if label != lure && strings.Contains(label, lure) {
+				t.Error(label)
+			}
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	f := Extract("www.x7kq2vd9wzpl.co.uk")
+	if f == nil {
+		t.Fatal()
+	}
+
+	if f.Label != "x7kq2vd9wzpl" || f.Length != 12 || f.ConsonantRun != 4 || f.DigitRatio != 0.25 {
+		t.Error(f)
+	}
+
+	if f := Extract("co.uk"); f != nil {
+		t.Error(f)
+	}
+}
+
+func TestShortLabels(t *testing.T) {
+	for _, domain := range []string{"bbc.co.uk", "xkcd.com", "xn--80ak6aa92e.com"} {
+		if f := Extract(domain); f == nil || f.Score() != 0 {
+			t.Error(domain, f)
+		}
+	}
+}
+
+func TestVerdict(t *testing.T) {
+	v := OpenBlocker().IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "qxkzvbrtlmwpfjdh.com"})
+	if v == nil || v.Source != Source || !strings.HasPrefix(v.Rule, "qxkzvbrtlmwpfjdh: score") {
+		t.Fatal(v)
+	}
+
+	if f := Extract("qxkzvbrtlmwpfjdh.com"); v.Confidence != f.Score() || v.Confidence >= 1 {
+		t.Error(v.Confidence)
+	}
+
+	if v := OpenBlocker().IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "wikipedia.org"}); v != nil {
+		t.Error(v)
+	}
+}
@@ -0,0 +1,117 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+//go:build ignore
+// +build ignore
+
+// gen trains the bigram model of model.go on benign.txt.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+)
+
+const alphabet = "^abcdefghijklmnopqrstuvwxyz0123456789-$"
+
+func main() {
+	f, err := os.Open("benign.txt")
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	var counts [len(alphabet)][len(alphabet)]float64
+	var totals [len(alphabet)]float64
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		label := strings.TrimSpace(scanner.Text())
+		if label == "" || strings.HasPrefix(label, "#") {
+			continue
+		}
+
+		s := "^" + strings.ToLower(label) + "$"
+		for i := 0; i < len(s)-1; i++ {
+			a, b := strings.IndexByte(alphabet, s[i]), strings.IndexByte(alphabet, s[i+1])
+			if a == -1 || b == -1 {
+				panic("bad label: " + label)
+			}
+
+			counts[a][b]++
+			totals[a]++
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		panic(err)
+	}
+
+	var buf bytes.Buffer
+
+	// model.go has the license header of this file
+	src, err := ioutil.ReadFile("gen.go")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, line := range strings.SplitAfter(string(src), "\n") {
+		if !strings.HasPrefix(line, "//") {
+			break
+		}
+
+		buf.WriteString(line)
+	}
+
+	fmt.Fprintf(&buf, "\n// Code generated by gen.go; DO NOT EDIT.\n\npackage dga\n\n")
+	fmt.Fprintf(&buf, "const alphabet = %q\n\n", alphabet)
+	fmt.Fprintf(&buf, "// bigramLogProb[a][b] is the log-probability of b after a, in benign labels\n")
+	fmt.Fprintf(&buf, "var bigramLogProb = [len(alphabet)][len(alphabet)]float32{\n")
+
+	for a := range counts {
+		fmt.Fprintf(&buf, "\t{")
+		for b := range counts[a] {
+			// add-one smoothing gives unseen bigrams a low, non-zero
+			// probability
+			p := (counts[a][b] + 1) / (totals[a] + float64(len(alphabet)))
+			fmt.Fprintf(&buf, "%.3f, ", math.Log(p))
+		}
+		fmt.Fprintf(&buf, "},\n")
+	}
+
+	fmt.Fprintf(&buf, "}\n")
+
+	src, err = format.Source(buf.Bytes())
+	if err != nil {
+		panic(err)
+	}
+
+	if err := ioutil.WriteFile("model.go", src, 0644); err != nil {
+		panic(err)
+	}
+}
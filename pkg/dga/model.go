@@ -0,0 +1,70 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Code generated by gen.go; DO NOT EDIT.
+
+package dga
+
+const alphabet = "^abcdefghijklmnopqrstuvwxyz0123456789-$"
+
+// bigramLogProb[a][b] is the log-probability of b after a, in benign labels
+var bigramLogProb = [len(alphabet)][len(alphabet)]float32{
+	{-6.775, -2.844, -2.884, -2.321, -3.062, -3.556, -3.220, -3.138, -3.192, -3.640, -4.377, -4.136, -3.443, -2.884, -3.408, -3.517, -2.904, -5.166, -3.138, -2.406, -2.904, -4.003, -3.885, -3.138, -6.082, -4.829, -4.578, -6.775, -5.677, -5.389, -5.166, -5.677, -6.082, -6.775, -6.082, -6.775, -6.082, -6.775, -6.775},
+	{-6.335, -5.236, -4.256, -2.968, -3.200, -6.335, -4.726, -3.850, -5.642, -3.077, -6.335, -4.389, -2.292, -2.809, -2.058, -5.236, -3.562, -5.642, -2.045, -2.809, -2.528, -3.770, -4.389, -6.335, -5.236, -4.032, -4.032, -6.335, -5.642, -5.642, -6.335, -6.335, -6.335, -6.335, -6.335, -6.335, -6.335, -4.949, -2.423},
+	{-5.226, -1.858, -3.839, -4.533, -4.533, -2.181, -5.226, -5.226, -3.616, -2.587, -5.226, -5.226, -2.335, -3.616, -4.533, -2.335, -5.226, -5.226, -2.587, -3.616, -5.226, -2.828, -5.226, -5.226, -5.226, -4.127, -5.226, -5.226, -5.226, -5.226, -5.226, -5.226, -5.226, -5.226, -5.226, -5.226, -5.226, -5.226, -2.923},
+	{-5.710, -2.184, -5.710, -4.101, -4.612, -2.073, -5.710, -5.017, -1.926, -3.002, -5.710, -2.619, -2.715, -5.710, -5.017, -1.973, -5.710, -5.710, -3.071, -3.631, -3.408, -4.101, -5.017, -5.710, -5.710, -4.612, -5.710, -5.710, -5.710, -5.710, -5.710, -5.710, -5.710, -5.710, -5.710, -5.710, -5.710, -5.017, -3.313},
+	{-5.472, -2.428, -4.779, -3.681, -3.863, -1.735, -4.374, -3.863, -4.779, -1.835, -5.472, -5.472, -3.863, -4.374, -4.086, -2.477, -4.779, -5.472, -3.526, -3.526, -5.472, -3.681, -4.779, -5.472, -4.779, -4.779, -5.472, -5.472, -4.374, -5.472, -5.472, -5.472, -5.472, -5.472, -5.472, -5.472, -5.472, -4.779, -1.976},
+	{-6.494, -2.830, -3.929, -3.449, -3.198, -3.449, -4.548, -4.191, -5.395, -5.395, -5.801, -5.107, -3.028, -4.096, -2.582, -5.107, -4.191, -6.494, -1.830, -2.351, -2.830, -5.395, -4.009, -3.929, -3.929, -4.297, -5.107, -6.494, -6.494, -5.801, -5.801, -6.494, -6.494, -6.494, -6.494, -6.494, -6.494, -5.395, -1.665},
+	{-4.836, -2.128, -4.836, -4.836, -4.836, -2.351, -3.045, -4.836, -4.836, -2.128, -4.836, -4.836, -2.351, -4.836, -4.836, -2.128, -4.836, -4.836, -4.836, -4.143, -2.757, -3.045, -4.836, -4.836, -4.836, -3.450, -4.836, -4.836, -4.836, -4.836, -4.836, -4.836, -4.836, -4.836, -4.836, -4.836, -4.836, -4.836, -3.738},
+	{-5.303, -2.470, -5.303, -5.303, -5.303, -2.125, -4.610, -3.357, -3.694, -2.595, -5.303, -5.303, -3.106, -3.917, -3.512, -2.413, -5.303, -5.303, -2.905, -3.917, -4.205, -3.357, -5.303, -5.303, -5.303, -3.917, -5.303, -5.303, -5.303, -4.610, -5.303, -5.303, -5.303, -5.303, -5.303, -5.303, -5.303, -5.303, -1.590},
+	{-5.182, -2.004, -4.489, -5.182, -5.182, -1.850, -4.489, -3.795, -5.182, -2.879, -5.182, -5.182, -3.795, -4.489, -4.083, -1.814, -5.182, -5.182, -3.572, -5.182, -3.572, -2.543, -5.182, -4.489, -5.182, -4.083, -5.182, -5.182, -5.182, -5.182, -5.182, -5.182, -5.182, -5.182, -5.182, -5.182, -5.182, -5.182, -2.291},
+	{-6.096, -3.005, -3.698, -2.540, -3.205, -3.531, -3.899, -3.263, -5.403, -6.096, -4.997, -4.016, -2.918, -3.263, -1.501, -2.877, -3.793, -4.997, -3.698, -2.877, -2.407, -4.997, -3.531, -6.096, -4.486, -6.096, -4.710, -6.096, -6.096, -6.096, -6.096, -6.096, -6.096, -6.096, -6.096, -6.096, -6.096, -6.096, -3.611},
+	{-3.989, -3.989, -3.989, -3.989, -3.989, -2.603, -3.989, -3.989, -3.989, -2.603, -3.989, -3.989, -3.989, -3.989, -3.989, -2.603, -3.989, -3.989, -3.989, -2.380, -3.989, -2.890, -3.989, -3.989, -3.989, -3.989, -3.989, -3.989, -3.989, -3.989, -3.989, -3.989, -3.989, -3.989, -3.989, -3.989, -3.989, -3.989, -3.989},
+	{-4.860, -3.068, -4.167, -4.860, -4.167, -1.864, -4.860, -4.167, -4.167, -2.221, -4.860, -4.860, -3.474, -4.860, -4.167, -3.474, -4.860, -4.860, -3.474, -3.068, -3.474, -3.250, -4.860, -4.860, -4.860, -3.761, -4.860, -4.860, -4.860, -4.167, -4.860, -4.860, -4.860, -4.860, -4.860, -4.860, -4.860, -4.860, -1.641},
+	{-5.820, -2.209, -4.721, -5.127, -3.517, -1.813, -5.127, -4.721, -5.820, -2.059, -5.820, -5.820, -2.685, -5.127, -5.820, -2.354, -4.211, -5.820, -4.211, -3.741, -3.874, -3.112, -4.434, -5.820, -5.127, -3.112, -5.820, -5.820, -5.820, -5.820, -5.820, -5.820, -5.820, -5.820, -5.820, -5.820, -5.820, -4.721, -2.059},
+	{-5.361, -1.511, -3.059, -5.361, -4.263, -1.648, -5.361, -4.263, -4.668, -2.876, -4.668, -5.361, -4.668, -3.415, -4.668, -2.317, -3.415, -5.361, -5.361, -3.975, -4.668, -3.975, -5.361, -5.361, -5.361, -3.570, -5.361, -5.361, -5.361, -5.361, -5.361, -5.361, -5.361, -5.361, -5.361, -5.361, -5.361, -5.361, -2.528},
+	{-5.996, -2.629, -4.387, -2.905, -2.562, -2.283, -4.898, -2.168, -5.303, -2.818, -5.996, -4.387, -4.051, -5.303, -4.205, -3.432, -4.610, -5.996, -5.996, -2.595, -2.500, -4.610, -4.387, -5.996, -5.996, -4.387, -5.303, -5.996, -5.996, -5.996, -5.996, -5.996, -5.996, -5.996, -5.996, -5.996, -5.996, -4.898, -1.838},
+	{-6.080, -4.694, -3.777, -3.307, -3.595, -5.387, -3.682, -3.515, -4.981, -4.470, -5.387, -3.595, -2.784, -3.135, -2.209, -2.748, -3.247, -6.080, -2.091, -3.307, -2.989, -2.554, -3.777, -3.777, -3.883, -4.981, -4.981, -6.080, -6.080, -5.387, -6.080, -6.080, -6.080, -6.080, -6.080, -6.080, -6.080, -6.080, -2.583},
+	{-5.247, -1.989, -4.554, -4.554, -5.247, -1.951, -5.247, -5.247, -3.455, -2.539, -5.247, -4.554, -2.682, -3.861, -4.554, -2.357, -3.168, -5.247, -2.357, -3.861, -4.554, -3.861, -5.247, -5.247, -4.554, -4.554, -5.247, -5.247, -5.247, -5.247, -5.247, -5.247, -5.247, -5.247, -5.247, -5.247, -5.247, -5.247, -2.682},
+	{-3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.199, -3.892, -3.892, -3.892, -3.892, -3.199, -3.892, -3.892, -3.892, -1.946, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -2.793},
+	{-6.107, -2.278, -4.028, -3.468, -3.468, -1.790, -5.414, -4.028, -6.107, -2.369, -6.107, -3.399, -4.498, -4.161, -3.468, -2.641, -5.008, -6.107, -4.161, -3.217, -2.740, -3.709, -4.721, -6.107, -5.414, -3.622, -6.107, -6.107, -6.107, -6.107, -6.107, -6.107, -6.107, -6.107, -6.107, -6.107, -6.107, -6.107, -1.948},
+	{-5.999, -3.434, -5.306, -3.226, -4.389, -2.703, -4.389, -5.999, -3.226, -3.226, -5.999, -3.802, -4.207, -4.900, -4.389, -3.054, -3.166, -4.900, -5.999, -3.291, -1.765, -3.601, -5.999, -4.900, -5.999, -4.207, -5.306, -5.999, -5.999, -5.999, -5.999, -5.999, -5.999, -5.999, -5.999, -5.999, -5.999, -4.613, -1.254},
+	{-6.045, -2.461, -3.966, -3.742, -4.946, -1.886, -4.946, -4.659, -3.101, -2.307, -6.045, -6.045, -3.848, -4.436, -4.946, -2.713, -4.659, -6.045, -3.000, -2.954, -3.742, -3.212, -4.946, -4.099, -6.045, -3.337, -5.352, -6.045, -6.045, -4.946, -4.946, -6.045, -6.045, -6.045, -6.045, -6.045, -6.045, -4.659, -1.886},
+	{-5.389, -3.597, -2.445, -3.443, -2.904, -3.597, -4.290, -4.290, -5.389, -3.780, -5.389, -5.389, -3.310, -2.824, -2.022, -4.290, -3.780, -5.389, -1.988, -2.616, -2.445, -5.389, -5.389, -4.696, -4.696, -4.290, -4.696, -5.389, -5.389, -5.389, -5.389, -5.389, -5.389, -5.389, -5.389, -5.389, -5.389, -5.389, -3.310},
+	{-4.682, -2.485, -4.682, -4.682, -4.682, -1.281, -4.682, -4.682, -3.989, -1.591, -4.682, -3.989, -4.682, -4.682, -4.682, -3.989, -4.682, -4.682, -4.682, -3.584, -4.682, -3.989, -4.682, -4.682, -4.682, -3.989, -4.682, -4.682, -4.682, -4.682, -4.682, -4.682, -4.682, -4.682, -4.682, -4.682, -4.682, -4.682, -3.073},
+	{-4.673, -1.840, -3.980, -4.673, -4.673, -1.677, -4.673, -4.673, -3.287, -1.965, -4.673, -4.673, -3.980, -4.673, -4.673, -2.881, -4.673, -4.673, -3.980, -3.980, -4.673, -4.673, -4.673, -4.673, -4.673, -3.980, -4.673, -4.673, -4.673, -4.673, -4.673, -4.673, -4.673, -4.673, -4.673, -4.673, -4.673, -4.673, -2.727},
+	{-4.263, -4.263, -3.570, -3.570, -4.263, -3.570, -3.164, -4.263, -4.263, -3.164, -4.263, -4.263, -4.263, -4.263, -4.263, -4.263, -2.653, -4.263, -4.263, -4.263, -3.570, -4.263, -4.263, -4.263, -4.263, -4.263, -4.263, -4.263, -4.263, -4.263, -4.263, -4.263, -4.263, -4.263, -4.263, -4.263, -4.263, -4.263, -1.218},
+	{-4.920, -3.311, -4.227, -3.821, -4.920, -4.227, -3.821, -4.920, -4.920, -3.821, -4.920, -4.920, -4.227, -4.227, -4.920, -3.534, -3.534, -4.920, -3.534, -2.974, -3.311, -4.920, -4.920, -4.920, -4.920, -4.920, -4.920, -4.920, -4.920, -4.920, -4.920, -4.920, -4.920, -4.920, -4.920, -4.920, -4.920, -3.821, -0.761},
+	{-4.277, -2.331, -4.277, -4.277, -4.277, -2.485, -4.277, -4.277, -4.277, -2.485, -4.277, -4.277, -3.178, -4.277, -3.178, -2.485, -4.277, -4.277, -4.277, -4.277, -4.277, -4.277, -4.277, -4.277, -4.277, -4.277, -2.890, -4.277, -4.277, -4.277, -4.277, -4.277, -4.277, -4.277, -4.277, -4.277, -4.277, -3.178, -2.890},
+	{-3.784, -3.784, -3.091, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.091, -3.784, -3.784, -3.091, -3.784, -3.784, -3.091, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.091, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784},
+	{-3.784, -3.091, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.091, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -3.784, -2.398},
+	{-3.892, -3.199, -3.892, -3.892, -3.892, -3.892, -3.892, -3.199, -3.892, -3.892, -3.892, -3.199, -3.892, -3.892, -3.892, -3.199, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.199, -3.892, -3.892, -3.199, -2.282, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892, -3.892},
+	{-3.850, -3.157, -3.850, -3.850, -3.850, -3.850, -3.850, -3.850, -3.850, -3.850, -3.850, -3.850, -3.850, -3.157, -3.157, -3.850, -3.850, -3.850, -3.850, -3.850, -3.850, -3.850, -3.850, -3.850, -3.850, -3.850, -3.850, -3.850, -3.850, -3.850, -3.850, -3.850, -3.850, -2.241, -3.157, -3.850, -3.850, -3.850, -3.850},
+	{-3.807, -3.807, -3.807, -3.114, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.114, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -3.807, -2.197},
+	{-3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.045, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -3.738, -2.639},
+	{-3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761, -2.663, -3.761, -3.761, -3.761, -3.761, -2.663, -3.761, -3.761, -3.761, -3.761, -3.761, -3.761},
+	{-3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.020, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.020, -3.714},
+	{-3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664},
+	{-3.714, -3.714, -3.714, -3.714, -3.020, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.714, -3.020, -3.714, -3.714},
+	{-4.111, -2.725, -3.012, -3.418, -2.725, -3.418, -4.111, -4.111, -3.418, -4.111, -4.111, -4.111, -4.111, -3.418, -4.111, -3.012, -3.418, -4.111, -2.501, -3.418, -3.418, -4.111, -4.111, -4.111, -4.111, -4.111, -3.418, -4.111, -4.111, -4.111, -4.111, -4.111, -4.111, -4.111, -4.111, -4.111, -4.111, -4.111, -4.111},
+	{-3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664, -3.664},
+}
@@ -0,0 +1,77 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dns
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// GetNameservers returns the NS records in the authority section of a DNS
+// response, keyed by zone. Zones and nameservers have no trailing dot.
+func GetNameservers(response []byte) (map[string][]string, error) {
+	var p dnsmessage.Parser
+
+	if _, err := p.Start(response); err != nil {
+		return nil, err
+	}
+
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+
+	if err := p.SkipAllAnswers(); err != nil {
+		return nil, err
+	}
+
+	nameservers := map[string][]string{}
+
+	for {
+		header, err := p.AuthorityHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if header.Type != dnsmessage.TypeNS {
+			if err := p.SkipAuthority(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		ns, err := p.NSResource()
+		if err != nil {
+			return nil, err
+		}
+
+		zone := strings.ToLower(strings.TrimSuffix(header.Name.String(), "."))
+		nameservers[zone] = append(nameservers[zone], strings.ToLower(strings.TrimSuffix(ns.NS.String(), ".")))
+	}
+
+	return nameservers, nil
+}
@@ -0,0 +1,54 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dns
+
+import (
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestGetNameservers(t *testing.T) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true, RCode: dnsmessage.RCodeNameError})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName("www.example.com."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+	b.StartAuthorities()
+	for _, ns := range []string{"NS1.Example.net.", "ns2.example.net."} {
+		b.NSResource(dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("Example.com."), Class: dnsmessage.ClassINET, TTL: 60}, dnsmessage.NSResource{NS: dnsmessage.MustNewName(ns)})
+	}
+	b.SOAResource(dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("example.com."), Class: dnsmessage.ClassINET, TTL: 60}, dnsmessage.SOAResource{NS: dnsmessage.MustNewName("ns1.example.net."), MBox: dnsmessage.MustNewName("hostmaster.example.com.")})
+
+	response, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nameservers, err := GetNameservers(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ns := nameservers["example.com"]; len(nameservers) != 1 || len(ns) != 2 || ns[0] != "ns1.example.net" || ns[1] != "ns2.example.net" {
+		t.Error(nameservers)
+	}
+}
@@ -0,0 +1,212 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dns
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/idna"
+)
+
+const (
+	maxDomainLength = 253
+	maxLabelLength  = 63
+
+	punycodePrefix = "xn--"
+
+	// offset of the question section in a message
+	headerSize = 12
+)
+
+// ErrInvalidName is returned when a domain name is invalid.
+var ErrInvalidName = errors.New("invalid domain name")
+
+// profile is a non-transitional IDNA2008 lookup profile: unlike idna.Lookup,
+// it keeps ß, ς, ZWJ and ZWNJ instead of mapping them, like browsers do.
+//
+// The profile is not transitional by default; idna.Transitional(false) cannot
+// be used, because it enables transitional processing in the pinned x/net.
+var profile = idna.New(idna.MapForLookup(), idna.BidiRule())
+
+func isValidASCIILabel(label string) bool {
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func normalizeLabel(label string) (string, error) {
+	if isASCII(label) {
+		label = strings.ToLower(label)
+
+		// punycode labels are decoded and encoded again, to reject invalid
+		// or non-canonical encodings
+		if strings.HasPrefix(label, punycodePrefix) {
+			decoded, err := profile.ToUnicode(label)
+			if err != nil {
+				return "", ErrInvalidName
+			}
+
+			if encoded, err := profile.ToASCII(decoded); err != nil || encoded != label {
+				return "", ErrInvalidName
+			}
+		}
+	} else {
+		var err error
+		if label, err = profile.ToASCII(label); err != nil {
+			return "", ErrInvalidName
+		}
+	}
+
+	if label == "" || len(label) > maxLabelLength || !isValidASCIILabel(label) {
+		return "", ErrInvalidName
+	}
+
+	return label, nil
+}
+
+// NormalizeName returns a domain name in canonical form: lowercase, encoded
+// using punycode and without the trailing dot.
+//
+// Underscores are allowed, so names like _dmarc.example.com are valid.
+func NormalizeName(name string) (string, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return "", ErrInvalidName
+	}
+
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		normalized, err := normalizeLabel(label)
+		if err != nil {
+			return "", err
+		}
+
+		labels[i] = normalized
+	}
+
+	name = strings.Join(labels, ".")
+	if len(name) > maxDomainLength {
+		return "", ErrInvalidName
+	}
+
+	return name, nil
+}
+
+// encodeName encodes a domain name, without compression.
+func encodeName(name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return []byte{0}, nil
+	}
+
+	encoded := make([]byte, 0, len(name)+2)
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > maxLabelLength {
+			return nil, ErrInvalidName
+		}
+
+		encoded = append(encoded, byte(len(label)))
+		encoded = append(encoded, label...)
+	}
+
+	return append(encoded, 0), nil
+}
+
+// questionNameEnd returns the offset of the end of the name in the question
+// section, or -1 if the name is compressed or truncated.
+func questionNameEnd(msg []byte) int {
+	i := headerSize
+	for i < len(msg) {
+		length := int(msg[i])
+		if length == 0 {
+			return i + 1
+		}
+
+		// compression pointers are not expected in the question section
+		if length > maxLabelLength {
+			return -1
+		}
+
+		i += 1 + length
+	}
+
+	return -1
+}
+
+// SetQuestionName replaces the domain name in the question section of a
+// message, e.g. to echo the casing of a request in a cached response.
+func SetQuestionName(msg []byte, name string) ([]byte, error) {
+	encoded, err := encodeName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	end := questionNameEnd(msg)
+	if end != -1 && end-headerSize == len(encoded) {
+		// names that differ only in casing have the same length, so they
+		// can be replaced without breaking compression pointers
+		replaced := make([]byte, len(msg))
+		copy(replaced, msg)
+		copy(replaced[headerSize:end], encoded)
+		return replaced, nil
+	}
+
+	var m dnsmessage.Message
+	if err := m.Unpack(msg); err != nil {
+		return nil, err
+	}
+
+	if len(m.Questions) != 1 {
+		return nil, ErrInvalidName
+	}
+
+	previous := m.Questions[0].Name
+	if m.Questions[0].Name, err = dnsmessage.NewName(strings.TrimSuffix(name, ".") + "."); err != nil {
+		return nil, err
+	}
+
+	for i := range m.Answers {
+		if strings.EqualFold(m.Answers[i].Header.Name.String(), previous.String()) {
+			m.Answers[i].Header.Name = m.Questions[0].Name
+		}
+	}
+
+	return m.Pack()
+}
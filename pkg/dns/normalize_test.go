@@ -0,0 +1,131 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dns
+
+import (
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func ExampleNormalizeName() {
+	for _, name := range []string{"DoubleClick.NET.", "bücher.example", "XN--BCHER-KVA.example", "_dmarc.example.com", "bad domain.com", "a..com"} {
+		fmt.Println(NormalizeName(name))
+	}
+
+	// Output:
+	// doubleclick.net <nil>
+	// xn--bcher-kva.example <nil>
+	// xn--bcher-kva.example <nil>
+	// _dmarc.example.com <nil>
+	//  invalid domain name
+	//  invalid domain name
+}
+
+func TestNormalizeDeviations(t *testing.T) {
+	// these characters are mapped by transitional processing, which would
+	// send queries for a different domain
+	for name, normalized := range map[string]string{
+		"faß.de":                      "xn--fa-hia.de",
+		"xn--fa-hia.de":               "xn--fa-hia.de",
+		"βόλος.com":                   "xn--nxasmm1c.com",
+		"xn--nxasmm1c.com":            "xn--nxasmm1c.com",
+		"क्\u200cष.example":           "xn--11b2ezcs70k.example",
+		"क्\u200dष.example":           "xn--11b2ezcw70k.example",
+		"نامه\u200cای.example":        "xn--mgba3gch31f060k.example",
+		"xn--mgba3gch31f060k.example": "xn--mgba3gch31f060k.example",
+	} {
+		if n, err := NormalizeName(name); err != nil || n != normalized {
+			t.Error(name, n, err)
+		}
+	}
+
+	// joiners are allowed only where they affect rendering
+	for _, name := range []string{"a\u200db.example", "a\u200cb.example"} {
+		if _, err := NormalizeName(name); err != ErrInvalidName {
+			t.Error(name, err)
+		}
+	}
+}
+
+func TestNormalizeInvalidName(t *testing.T) {
+	for _, name := range []string{
+		"",
+		".",
+		"xn--invalid-.com",
+		string(make([]byte, 64)) + ".com",
+		"a.b/c.com",
+	} {
+		if _, err := NormalizeName(name); err != ErrInvalidName {
+			t.Error(name, err)
+		}
+	}
+}
+
+func buildResponse(t *testing.T, name string) []byte {
+	n := dnsmessage.MustNewName(name)
+
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{Response: true},
+		Questions: []dnsmessage.Question{{Name: n, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+		Answers: []dnsmessage.Resource{
+			{
+				Header: dnsmessage.ResourceHeader{Name: n, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+			},
+		},
+	}
+
+	b := dnsmessage.NewBuilder(nil, msg.Header)
+	b.EnableCompression()
+	b.StartQuestions()
+	b.Question(msg.Questions[0])
+	b.StartAnswers()
+	b.AResource(msg.Answers[0].Header, *msg.Answers[0].Body.(*dnsmessage.AResource))
+
+	response, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return response
+}
+
+func TestSetQuestionName(t *testing.T) {
+	for _, name := range []string{"DoubleClick.NET.", "xn--bcher-kva.example."} {
+		response, err := SetQuestionName(buildResponse(t, "doubleclick.net."), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		summary, err := Summarize(response)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if summary.Questions[0].Name != name || summary.Answers[0].Name != name || summary.Answers[0].Data != "127.0.0.1" {
+			t.Error(summary)
+		}
+	}
+}
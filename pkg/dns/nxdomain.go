@@ -22,12 +22,24 @@
 
 package dns
 
-import "golang.org/x/net/dns/dnsmessage"
+import (
+	"encoding/binary"
+	"unicode/utf8"
 
-// BuildNXDomainResponse crafts a DNS response for a given domain, with the
-// NXDOMAIN error code set.
-func BuildNXDomainResponse(domain string, requestType dnsmessage.Type) ([]byte, error) {
-	msg := dnsmessage.Message{
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	ednsPayloadSize = 1232
+
+	// see RFC 8914
+	extendedDNSErrorOptionCode = 15
+	extendedDNSErrorBlocked    = 15
+	maxExtraTextLength         = 255
+)
+
+func newNXDomainMessage(domain string, requestType dnsmessage.Type) dnsmessage.Message {
+	return dnsmessage.Message{
 		Header: dnsmessage.Header{Response: true, Authoritative: true, RCode: dnsmessage.RCodeNameError},
 		Questions: []dnsmessage.Question{
 			{
@@ -37,6 +49,127 @@ func BuildNXDomainResponse(domain string, requestType dnsmessage.Type) ([]byte,
 			},
 		},
 	}
+}
+
+// BuildNXDomainResponse crafts a DNS response for a given domain, with the
+// NXDOMAIN error code set.
+func BuildNXDomainResponse(domain string, requestType dnsmessage.Type) ([]byte, error) {
+	msg := newNXDomainMessage(domain, requestType)
+	return msg.Pack()
+}
+
+// BuildErrorResponse crafts a DNS response to a query, with an error code set.
+func BuildErrorResponse(header dnsmessage.Header, question dnsmessage.Question, rcode dnsmessage.RCode) ([]byte, error) {
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: header.ID, Response: true, OpCode: header.OpCode, RecursionDesired: header.RecursionDesired, RCode: rcode},
+		Questions: []dnsmessage.Question{question},
+	}
+	return msg.Pack()
+}
+
+// BuildBlockedResponse crafts a NXDOMAIN response like BuildNXDomainResponse,
+// with an Extended DNS Error that explains why the domain is blocked. The
+// response is shared by all clients, so the OPT record must be removed using
+// RemoveOPT before the response is sent to a client that didn't send one.
+func BuildBlockedResponse(domain string, requestType dnsmessage.Type, extraText string) ([]byte, error) {
+	msg := newNXDomainMessage(domain, requestType)
+
+	// the text is truncated without splitting a UTF-8 sequence
+	if len(extraText) > maxExtraTextLength {
+		end := maxExtraTextLength
+		for end > 0 && !utf8.RuneStart(extraText[end]) {
+			end--
+		}
+		extraText = extraText[:end]
+	}
+
+	data := make([]byte, 2, 2+len(extraText))
+	binary.BigEndian.PutUint16(data, extendedDNSErrorBlocked)
+	data = append(data, extraText...)
+
+	opt := dnsmessage.Resource{
+		Body: &dnsmessage.OPTResource{
+			Options: []dnsmessage.Option{{Code: extendedDNSErrorOptionCode, Data: data}},
+		},
+	}
+	if err := opt.Header.SetEDNS0(ednsPayloadSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+
+	msg.Additionals = []dnsmessage.Resource{opt}
+	return msg.Pack()
+}
+
+// GetExtendedError returns the info code and extra text of the first Extended
+// DNS Error in a DNS response.
+func GetExtendedError(response []byte) (uint16, string, bool) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(response); err != nil {
+		return 0, "", false
+	}
+
+	for _, additional := range msg.Additionals {
+		opt, ok := additional.Body.(*dnsmessage.OPTResource)
+		if !ok {
+			continue
+		}
+
+		for _, option := range opt.Options {
+			if option.Code == extendedDNSErrorOptionCode && len(option.Data) >= 2 {
+				return binary.BigEndian.Uint16(option.Data), string(option.Data[2:]), true
+			}
+		}
+	}
+
+	return 0, "", false
+}
+
+// HasOPT determines whether or not a DNS message has an OPT record.
+func HasOPT(msg []byte) bool {
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		return false
+	}
+
+	if p.SkipAllQuestions() != nil || p.SkipAllAnswers() != nil || p.SkipAllAuthorities() != nil {
+		return false
+	}
+
+	for {
+		header, err := p.AdditionalHeader()
+		if err != nil {
+			return false
+		}
+
+		if header.Type == dnsmessage.TypeOPT {
+			return true
+		}
+
+		if err := p.SkipAdditional(); err != nil {
+			return false
+		}
+	}
+}
+
+// RemoveOPT removes the OPT record from a DNS response, for a client that
+// didn't send one (see RFC 6891).
+func RemoveOPT(response []byte) ([]byte, error) {
+	if !HasOPT(response) {
+		return response, nil
+	}
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(response); err != nil {
+		return nil, err
+	}
+
+	additionals := msg.Additionals[:0]
+	for _, additional := range msg.Additionals {
+		if additional.Header.Type != dnsmessage.TypeOPT {
+			additionals = append(additionals, additional)
+		}
+	}
+	msg.Additionals = additionals
 
 	return msg.Pack()
 }
@@ -0,0 +1,116 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dns
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func ExampleGetExtendedError() {
+	response, err := BuildBlockedResponse("ads.example.com", dnsmessage.TypeA, "hosts (ads)")
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(GetExtendedError(response))
+	// Output: 15 hosts (ads) true
+}
+
+func TestBuildBlockedResponse(t *testing.T) {
+	response, err := BuildBlockedResponse("ads.example.com", dnsmessage.TypeAAAA, strings.Repeat("a", 300))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := Summarize(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.RCode != "NameError" || summary.Questions[0].Name != "ads.example.com." || summary.Questions[0].Type != "AAAA" {
+		t.Error(summary)
+	}
+
+	if _, text, ok := GetExtendedError(response); !ok || len(text) != maxExtraTextLength {
+		t.Error(text)
+	}
+
+	// a multi-byte character that crosses the limit is dropped
+	if response, err = BuildBlockedResponse("ads.example.com", dnsmessage.TypeA, strings.Repeat("a", maxExtraTextLength-1)+"ß"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, text, ok := GetExtendedError(response); !ok || text != strings.Repeat("a", maxExtraTextLength-1) || !utf8.ValidString(text) {
+		t.Error(text)
+	}
+}
+
+func TestRemoveOPT(t *testing.T) {
+	response, err := BuildBlockedResponse("ads.example.com", dnsmessage.TypeA, "hosts (ads)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !HasOPT(response) {
+		t.Fatal()
+	}
+
+	stripped, err := RemoveOPT(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if HasOPT(stripped) {
+		t.Error()
+	}
+
+	if summary, err := Summarize(stripped); err != nil || summary.RCode != "NameError" || summary.Questions[0].Name != "ads.example.com." {
+		t.Error(summary, err)
+	}
+
+	response, err = BuildNXDomainResponse("ads.example.com", dnsmessage.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stripped, err := RemoveOPT(response); err != nil || !bytes.Equal(stripped, response) {
+		t.Error(err)
+	}
+}
+
+func TestGetExtendedErrorNoOPT(t *testing.T) {
+	response, err := BuildNXDomainResponse("ads.example.com", dnsmessage.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, ok := GetExtendedError(response); ok {
+		t.Error()
+	}
+}
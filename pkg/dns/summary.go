@@ -0,0 +1,194 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dns
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// QuestionSummary is a human-readable representation of a DNS question.
+type QuestionSummary struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// ResourceSummary is a human-readable representation of a DNS resource record.
+type ResourceSummary struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	TTL  uint32 `json:"ttl"`
+	Data string `json:"data"`
+}
+
+// Summary is a human-readable representation of a DNS message.
+type Summary struct {
+	ID          uint16            `json:"id"`
+	Response    bool              `json:"response"`
+	RCode       string            `json:"rcode"`
+	Questions   []QuestionSummary `json:"questions"`
+	Answers     []ResourceSummary `json:"answers,omitempty"`
+	Authorities []ResourceSummary `json:"authorities,omitempty"`
+	Additionals []ResourceSummary `json:"additionals,omitempty"`
+}
+
+func typeName(t dnsmessage.Type) string {
+	return strings.TrimPrefix(t.String(), "Type")
+}
+
+func summarizeBody(p *dnsmessage.Parser, header dnsmessage.ResourceHeader) (string, error) {
+	switch header.Type {
+	case dnsmessage.TypeA:
+		r, err := p.AResource()
+		return net.IP(r.A[:]).String(), err
+
+	case dnsmessage.TypeAAAA:
+		r, err := p.AAAAResource()
+		return net.IP(r.AAAA[:]).String(), err
+
+	case dnsmessage.TypeCNAME:
+		r, err := p.CNAMEResource()
+		return r.CNAME.String(), err
+
+	case dnsmessage.TypeNS:
+		r, err := p.NSResource()
+		return r.NS.String(), err
+
+	case dnsmessage.TypePTR:
+		r, err := p.PTRResource()
+		return r.PTR.String(), err
+
+	case dnsmessage.TypeMX:
+		r, err := p.MXResource()
+		return fmt.Sprintf("%d %s", r.Pref, r.MX.String()), err
+
+	case dnsmessage.TypeSRV:
+		r, err := p.SRVResource()
+		return fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, r.Target.String()), err
+
+	case dnsmessage.TypeTXT:
+		r, err := p.TXTResource()
+		quoted := make([]string, 0, len(r.TXT))
+		for _, s := range r.TXT {
+			quoted = append(quoted, fmt.Sprintf("%q", s))
+		}
+		return strings.Join(quoted, " "), err
+
+	case dnsmessage.TypeSOA:
+		r, err := p.SOAResource()
+		return fmt.Sprintf("%s %s %d %d %d %d %d", r.NS.String(), r.MBox.String(), r.Serial, r.Refresh, r.Retry, r.Expire, r.MinTTL), err
+
+	case dnsmessage.TypeOPT:
+		r, err := p.OPTResource()
+		options := make([]string, 0, len(r.Options))
+		for _, option := range r.Options {
+			if option.Code == extendedDNSErrorOptionCode && len(option.Data) >= 2 {
+				options = append(options, fmt.Sprintf("EDE %d %q", binary.BigEndian.Uint16(option.Data), option.Data[2:]))
+			} else {
+				options = append(options, fmt.Sprintf("%d:%s", option.Code, hex.EncodeToString(option.Data)))
+			}
+		}
+		return strings.Join(options, " "), err
+	}
+
+	return "", nil
+}
+
+func summarizeSection(p *dnsmessage.Parser, next func() (dnsmessage.ResourceHeader, error), skip func() error) ([]ResourceSummary, error) {
+	var resources []ResourceSummary
+
+	for {
+		header, err := next()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			return resources, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := summarizeBody(p, header)
+		if err != nil {
+			return nil, err
+		}
+
+		// unsupported record types are skipped, but still listed
+		if data == "" {
+			if err := skip(); err != nil {
+				return nil, err
+			}
+		}
+
+		resources = append(resources, ResourceSummary{
+			Name: header.Name.String(),
+			Type: typeName(header.Type),
+			TTL:  header.TTL,
+			Data: data,
+		})
+	}
+}
+
+// Summarize parses a DNS message and returns a human-readable representation
+// of it.
+func Summarize(msg []byte) (*Summary, error) {
+	var p dnsmessage.Parser
+
+	header, err := p.Start(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := Summary{
+		ID:       header.ID,
+		Response: header.Response,
+		RCode:    strings.TrimPrefix(header.RCode.String(), "RCode"),
+	}
+
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, question := range questions {
+		summary.Questions = append(summary.Questions, QuestionSummary{Name: question.Name.String(), Type: typeName(question.Type)})
+	}
+
+	if summary.Answers, err = summarizeSection(&p, p.AnswerHeader, p.SkipAnswer); err != nil {
+		return nil, err
+	}
+
+	if summary.Authorities, err = summarizeSection(&p, p.AuthorityHeader, p.SkipAuthority); err != nil {
+		return nil, err
+	}
+
+	if summary.Additionals, err = summarizeSection(&p, p.AdditionalHeader, p.SkipAdditional); err != nil {
+		return nil, err
+	}
+
+	return &summary, nil
+}
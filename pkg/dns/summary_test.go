@@ -0,0 +1,82 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dns
+
+import (
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func ExampleSummarize() {
+	summary, err := Summarize([]byte(dnsResponseWithOneShortTTL))
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(summary.RCode, summary.Questions[0].Name, summary.Questions[0].Type)
+	for _, answer := range summary.Answers {
+		fmt.Println(answer.Name, answer.Type, answer.TTL, answer.Data)
+	}
+
+	// Output:
+	// Success cnn.com. AAAA
+	// cnn.com. AAAA 300 2a04:4e42:600::323
+	// cnn.com. AAAA 300 2a04:4e42:400::323
+	// cnn.com. AAAA 300 2a04:4e42::323
+	// cnn.com. AAAA 200 2a04:4e42:200::323
+}
+
+func TestSummarizeNXDomain(t *testing.T) {
+	response, err := BuildNXDomainResponse("ads.example.com", dnsmessage.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := Summarize(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.RCode != "NameError" || len(summary.Questions) != 1 || summary.Questions[0].Type != "A" || len(summary.Answers) != 0 {
+		t.Error(summary)
+	}
+}
+
+func TestSummarizeAuthorities(t *testing.T) {
+	summary, err := Summarize([]byte(dnsResponseNoAnswers))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(summary.Authorities) != 1 || summary.Authorities[0].Type != "SOA" {
+		t.Error(summary)
+	}
+}
+
+func TestSummarizeCut(t *testing.T) {
+	if _, err := Summarize([]byte(dnsResponseCut)); err == nil {
+		t.Error()
+	}
+}
@@ -0,0 +1,190 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package feed downloads lists periodically.
+package feed
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/fetch"
+)
+
+const (
+	refreshTimeout = 5 * time.Minute
+
+	keyPrefix = "feed:"
+)
+
+// Status describes the freshness of a feed.
+type Status struct {
+	Name string `json:"name"`
+
+	// Updated is the time of the last successful download
+	Updated time.Time `json:"updated,omitempty"`
+
+	// Checked is the time of the last download attempt
+	Checked time.Time `json:"checked,omitempty"`
+
+	// Entries is the number of entries in the list
+	Entries int `json:"entries"`
+
+	// Error is the reason the last download failed, if it did
+	Error string `json:"error,omitempty"`
+}
+
+// Feed is a list that is downloaded periodically.
+type Feed struct {
+	Source   fetch.Source
+	Interval time.Duration
+
+	// Update is called with the contents of the list after each download,
+	// and returns the number of entries
+	Update func([]byte) (int, error)
+
+	// the default is a Fetcher that uses http.DefaultClient
+	Fetcher *fetch.Fetcher
+
+	// Cache stores the status of the feed, if set
+	Cache *cache.Cache
+
+	lock   sync.Mutex
+	status Status
+}
+
+// Status returns the status of the feed.
+func (f *Feed) Status() Status {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	status := f.status
+	status.Name = f.Source.Name
+	return status
+}
+
+func (f *Feed) setStatus(ctx context.Context, entries int, err error) {
+	f.lock.Lock()
+
+	now := time.Now()
+	f.status.Checked = now
+
+	if err == nil {
+		f.status.Updated = now
+		f.status.Entries = entries
+		f.status.Error = ""
+	} else {
+		f.status.Error = err.Error()
+	}
+
+	f.lock.Unlock()
+
+	if f.Cache == nil {
+		return
+	}
+
+	if j, err := json.Marshal(f.Status()); err == nil {
+		f.Cache.Backend(ctx).Set(keyPrefix+f.Source.Name, j, 0)
+	}
+}
+
+// Refresh downloads the list once.
+func (f *Feed) Refresh(ctx context.Context) error {
+	fetcher := f.Fetcher
+	if fetcher == nil {
+		fetcher = &fetch.Fetcher{}
+	}
+
+	data, err := fetcher.Fetch(ctx, &f.Source)
+	if err != nil {
+		f.setStatus(ctx, 0, err)
+		return err
+	}
+
+	entries, err := f.Update(data)
+	f.setStatus(ctx, entries, err)
+	return err
+}
+
+func (f *Feed) refresh(parent context.Context) {
+	ctx, cancel := context.WithTimeout(parent, refreshTimeout)
+	defer cancel()
+
+	if err := f.Refresh(ctx); err != nil {
+		log.Printf("Failed to refresh %s: %v", f.Source.Name, err)
+	}
+}
+
+// Run refreshes the list periodically, until the context is canceled. The
+// previous contents are kept if a download fails.
+func (f *Feed) Run(ctx context.Context) {
+	ticker := time.NewTicker(f.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.refresh(ctx)
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Start downloads the list, then refreshes it periodically, in the
+// background: a slow download does not delay startup, and failures are
+// logged.
+func (f *Feed) Start(ctx context.Context) {
+	go func() {
+		f.refresh(ctx)
+		f.Run(ctx)
+	}()
+}
+
+// Statuses returns the status of all feeds that store their status in c,
+// sorted by name.
+func Statuses(ctx context.Context, c *cache.Cache) []Status {
+	backend := c.Backend(ctx)
+
+	var statuses []Status
+	for _, key := range backend.Keys(keyPrefix + "*") {
+		j := backend.Get(key)
+		if j == nil {
+			continue
+		}
+
+		var status Status
+		if err := json.Unmarshal(j, &status); err == nil && status.Name == strings.TrimPrefix(key, keyPrefix) {
+			statuses = append(statuses, status)
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
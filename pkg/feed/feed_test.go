@@ -0,0 +1,188 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package feed
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/fetch"
+)
+
+func TestRun(t *testing.T) {
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte("list"))
+	}))
+	defer server.Close()
+
+	updates := make(chan string, 16)
+
+	f := Feed{
+		Source:   fetch.Source{Name: "test", URL: server.URL},
+		Interval: 10 * time.Millisecond,
+		Update: func(data []byte) (int, error) {
+			updates <- string(data)
+			return 1, nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f.Start(ctx)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case data := <-updates:
+			if data != "list" {
+				t.Error(data)
+			}
+
+		case <-time.After(time.Second):
+			t.Fatal("no update")
+		}
+	}
+
+	if atomic.LoadInt32(&requests) < 3 {
+		t.Error(requests)
+	}
+}
+
+func TestStartSlow(t *testing.T) {
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("list"))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	updated := make(chan struct{}, 1)
+
+	f := Feed{
+		Source:   fetch.Source{Name: "test", URL: server.URL},
+		Interval: time.Hour,
+		Update: func([]byte) (int, error) {
+			updated <- struct{}{}
+			return 1, nil
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the first download must not delay startup
+	start := time.Now()
+	f.Start(ctx)
+	if time.Since(start) > time.Second {
+		t.Error("Start waited for the download")
+	}
+
+	release <- struct{}{}
+
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Error("no update")
+	}
+}
+
+func TestRefreshError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("list"))
+	}))
+	defer server.Close()
+
+	errUpdate := errors.New("bad list")
+
+	f := Feed{
+		Source: fetch.Source{Name: "test", URL: server.URL},
+		Update: func([]byte) (int, error) { return 0, errUpdate },
+	}
+
+	if err := f.Refresh(context.Background()); err != errUpdate {
+		t.Error(err)
+	}
+
+	f.Source.URL = server.URL + "/missing"
+	server.Config.Handler = http.NotFoundHandler()
+
+	if err := f.Refresh(context.Background()); err == nil {
+		t.Error("missing list was fetched")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	broken := false
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if broken {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Write([]byte("a\nb\n"))
+	}))
+	defer server.Close()
+
+	c, err := cache.OpenCache(&cache.MemoryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := Feed{
+		Source: fetch.Source{Name: "test", URL: server.URL},
+		Update: func(data []byte) (int, error) { return 2, nil },
+		Cache:  c,
+	}
+
+	if err := f.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	status := f.Status()
+	if status.Name != "test" || status.Entries != 2 || status.Error != "" || status.Updated.IsZero() || !status.Updated.Equal(status.Checked) {
+		t.Error(status)
+	}
+
+	broken = true
+	if err := f.Refresh(context.Background()); err == nil {
+		t.Fatal("broken feed was refreshed")
+	}
+
+	// the list is kept, so its update time and size are kept too
+	statuses := Statuses(context.Background(), c)
+	if len(statuses) != 1 || statuses[0].Entries != 2 || statuses[0].Error == "" || !statuses[0].Checked.After(statuses[0].Updated) {
+		t.Error(statuses)
+	}
+}
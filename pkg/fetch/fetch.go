@@ -0,0 +1,165 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package fetch downloads blocklists and verifies their signatures.
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// maximum size of a list, in bytes
+const maxSize = 256 * 1024 * 1024
+
+var (
+	// ErrUnsigned is returned when a source requires a signature but a list
+	// is not signed.
+	ErrUnsigned = errors.New("list is not signed")
+
+	// ErrNoPublicKey is returned when a source requires a signature but has
+	// no public key.
+	ErrNoPublicKey = errors.New("no public key")
+
+	// ErrTooBig is returned when a list is too big.
+	ErrTooBig = errors.New("list is too big")
+)
+
+// Source is a blocklist source.
+type Source struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+
+	// SignatureURL is the URL of a detached signature of the list; the
+	// default is URL, with the signature suffix of PublicKey
+	SignatureURL string `json:"signature_url,omitempty"`
+
+	// PublicKey is either a base64-encoded ed25519 public key or a minisign
+	// public key
+	PublicKey string `json:"public_key,omitempty"`
+
+	// if RequireSignature is set, unsigned lists are rejected
+	RequireSignature bool `json:"require_signature,omitempty"`
+}
+
+// Fetcher downloads lists from sources.
+type Fetcher struct {
+	// the default is http.DefaultClient
+	Client *http.Client
+}
+
+// errNotFound is returned when a file does not exist.
+var errNotFound = errors.New("not found")
+
+func (f *Fetcher) get(ctx context.Context, url string) ([]byte, error) {
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+
+	case http.StatusNotFound:
+		return nil, errNotFound
+
+	default:
+		return nil, fmt.Errorf("failed to fetch %s: %s", url, response.Status)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(response.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > maxSize {
+		return nil, ErrTooBig
+	}
+
+	return data, nil
+}
+
+// Fetch downloads a list and verifies its signature, if the source has a
+// public key.
+//
+// Lists with a bad signature are always rejected, while unsigned lists are
+// rejected only if the source requires a signature.
+func (f *Fetcher) Fetch(ctx context.Context, src *Source) ([]byte, error) {
+	var key *PublicKey
+
+	if src.PublicKey != "" {
+		var err error
+		if key, err = ParsePublicKey(src.PublicKey); err != nil {
+			return nil, fmt.Errorf("%s: %w", src.Name, err)
+		}
+	} else if src.RequireSignature {
+		return nil, fmt.Errorf("%s: %w", src.Name, ErrNoPublicKey)
+	}
+
+	data, err := f.get(ctx, src.URL)
+	if err == errNotFound {
+		return nil, fmt.Errorf("%s: %s not found", src.Name, src.URL)
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: %w", src.Name, err)
+	}
+
+	if key == nil {
+		return data, nil
+	}
+
+	signatureURL := src.SignatureURL
+	if signatureURL == "" {
+		signatureURL = src.URL + key.SignatureSuffix()
+	}
+
+	signature, err := f.get(ctx, signatureURL)
+	if err == errNotFound {
+		if src.RequireSignature {
+			return nil, fmt.Errorf("%s: %w", src.Name, ErrUnsigned)
+		}
+
+		return data, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: %w", src.Name, err)
+	}
+
+	if err := key.Verify(data, signature); err != nil {
+		return nil, fmt.Errorf("%s: %w", src.Name, err)
+	}
+
+	return data, nil
+}
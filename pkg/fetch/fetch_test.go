@@ -0,0 +1,122 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package fetch
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serveFiles(files map[string][]byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if data, ok := files[r.URL.Path]; ok {
+			w.Write(data)
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestFetchSigned(t *testing.T) {
+	pub, priv := generateKey()
+	data := []byte("0.0.0.0 ads.example.com\n")
+
+	server := serveFiles(map[string][]byte{
+		"/hosts":         data,
+		"/hosts.minisig": minisign(priv, data, minisignHashedAlgorithm),
+		"/other":         []byte("0.0.0.0 example.com\n"),
+		"/other.minisig": minisign(priv, data, minisignHashedAlgorithm),
+	})
+	defer server.Close()
+
+	var f Fetcher
+
+	fetched, err := f.Fetch(context.Background(), &Source{Name: "test", URL: server.URL + "/hosts", PublicKey: minisignPublicKey(pub), RequireSignature: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(fetched) != string(data) {
+		t.Error(string(fetched))
+	}
+
+	if _, err := f.Fetch(context.Background(), &Source{Name: "test", URL: server.URL + "/other", PublicKey: minisignPublicKey(pub)}); !errors.Is(err, ErrBadSignature) {
+		t.Error(err)
+	}
+
+	if _, err := f.Fetch(context.Background(), &Source{Name: "test", URL: server.URL + "/other", SignatureURL: server.URL + "/hosts.minisig", PublicKey: minisignPublicKey(pub)}); !errors.Is(err, ErrBadSignature) {
+		t.Error(err)
+	}
+}
+
+func TestFetchRawSigned(t *testing.T) {
+	pub, priv := generateKey()
+	data := []byte("0.0.0.0 ads.example.com\n")
+
+	server := serveFiles(map[string][]byte{
+		"/hosts":     data,
+		"/hosts.sig": ed25519.Sign(priv, data),
+	})
+	defer server.Close()
+
+	var f Fetcher
+
+	if _, err := f.Fetch(context.Background(), &Source{Name: "test", URL: server.URL + "/hosts", PublicKey: base64.StdEncoding.EncodeToString(pub), RequireSignature: true}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFetchUnsigned(t *testing.T) {
+	pub, _ := generateKey()
+	data := []byte("0.0.0.0 ads.example.com\n")
+
+	server := serveFiles(map[string][]byte{"/hosts": data})
+	defer server.Close()
+
+	var f Fetcher
+
+	if _, err := f.Fetch(context.Background(), &Source{Name: "test", URL: server.URL + "/hosts"}); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := f.Fetch(context.Background(), &Source{Name: "test", URL: server.URL + "/hosts", PublicKey: minisignPublicKey(pub)}); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := f.Fetch(context.Background(), &Source{Name: "test", URL: server.URL + "/hosts", PublicKey: minisignPublicKey(pub), RequireSignature: true}); !errors.Is(err, ErrUnsigned) {
+		t.Error(err)
+	}
+
+	if _, err := f.Fetch(context.Background(), &Source{Name: "test", URL: server.URL + "/hosts", RequireSignature: true}); !errors.Is(err, ErrNoPublicKey) {
+		t.Error(err)
+	}
+
+	if _, err := f.Fetch(context.Background(), &Source{Name: "test", URL: server.URL + "/missing"}); err == nil {
+		t.Error("missing list was fetched")
+	}
+}
@@ -0,0 +1,191 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package fetch
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	// legacy minisign signatures sign the data itself
+	minisignAlgorithm = "Ed"
+	// minisign signatures of a BLAKE2b-512 hash of the data
+	minisignHashedAlgorithm = "ED"
+
+	minisignKeyIDSize       = 8
+	minisignPublicKeySize   = len(minisignAlgorithm) + minisignKeyIDSize + ed25519.PublicKeySize
+	minisignSignatureSize   = len(minisignAlgorithm) + minisignKeyIDSize + ed25519.SignatureSize
+	minisignTrustedComment  = "trusted comment: "
+	minisignCommentPrefix   = "untrusted comment: "
+	minisignSignatureSuffix = ".minisig"
+
+	signatureSuffix = ".sig"
+)
+
+var (
+	// ErrBadPublicKey is returned when a public key cannot be parsed.
+	ErrBadPublicKey = errors.New("bad public key")
+
+	// ErrBadSignature is returned when a signature cannot be parsed or does
+	// not match the signed data.
+	ErrBadSignature = errors.New("bad signature")
+
+	// ErrWrongKey is returned when a signature was made using another key.
+	ErrWrongKey = errors.New("signature made using another key")
+)
+
+// PublicKey is an ed25519 public key, used to verify detached signatures.
+//
+// Minisign keys verify signatures in the minisign format, while raw ed25519
+// keys verify raw ed25519 signatures.
+type PublicKey struct {
+	key      ed25519.PublicKey
+	keyID    []byte
+	minisign bool
+}
+
+// stripComment returns the non-comment line of a key or a signature, in
+// the minisign format.
+func stripComment(s string) string {
+	for _, line := range strings.Split(strings.TrimSpace(s), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, minisignCommentPrefix) {
+			return line
+		}
+	}
+
+	return ""
+}
+
+// ParsePublicKey parses a base64-encoded ed25519 public key, or a minisign
+// public key.
+func ParsePublicKey(s string) (*PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(stripComment(s))
+	if err != nil {
+		return nil, ErrBadPublicKey
+	}
+
+	switch {
+	case len(raw) == ed25519.PublicKeySize:
+		return &PublicKey{key: ed25519.PublicKey(raw)}, nil
+
+	case len(raw) == minisignPublicKeySize && string(raw[:len(minisignAlgorithm)]) == minisignAlgorithm:
+		return &PublicKey{
+			key:      ed25519.PublicKey(raw[len(minisignAlgorithm)+minisignKeyIDSize:]),
+			keyID:    raw[len(minisignAlgorithm) : len(minisignAlgorithm)+minisignKeyIDSize],
+			minisign: true,
+		}, nil
+	}
+
+	return nil, ErrBadPublicKey
+}
+
+// SignatureSuffix returns the file name suffix of signatures verified by a
+// public key.
+func (k *PublicKey) SignatureSuffix() string {
+	if k.minisign {
+		return minisignSignatureSuffix
+	}
+
+	return signatureSuffix
+}
+
+// Verify verifies a detached signature of data.
+func (k *PublicKey) Verify(data, signature []byte) error {
+	if k.minisign {
+		return k.verifyMinisign(data, signature)
+	}
+
+	return k.verifyRaw(data, signature)
+}
+
+func (k *PublicKey) verifyRaw(data, signature []byte) error {
+	// the signature is either raw or base64-encoded
+	if len(signature) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+		if err != nil {
+			return ErrBadSignature
+		}
+
+		signature = decoded
+	}
+
+	if len(signature) != ed25519.SignatureSize || !ed25519.Verify(k.key, data, signature) {
+		return ErrBadSignature
+	}
+
+	return nil
+}
+
+func (k *PublicKey) verifyMinisign(data, signature []byte) error {
+	lines := strings.Split(strings.TrimSpace(string(signature)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], minisignTrustedComment) {
+		return ErrBadSignature
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sig) != minisignSignatureSize {
+		return ErrBadSignature
+	}
+
+	globalSig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(globalSig) != ed25519.SignatureSize {
+		return ErrBadSignature
+	}
+
+	algorithm := string(sig[:len(minisignAlgorithm)])
+	keyID := sig[len(minisignAlgorithm) : len(minisignAlgorithm)+minisignKeyIDSize]
+	sig = sig[len(minisignAlgorithm)+minisignKeyIDSize:]
+
+	if !bytes.Equal(keyID, k.keyID) {
+		return ErrWrongKey
+	}
+
+	switch algorithm {
+	case minisignAlgorithm:
+
+	case minisignHashedAlgorithm:
+		hash := blake2b.Sum512(data)
+		data = hash[:]
+
+	default:
+		return ErrBadSignature
+	}
+
+	if !ed25519.Verify(k.key, data, sig) {
+		return ErrBadSignature
+	}
+
+	// the trusted comment is signed too, to prevent tampering
+	trustedComment := strings.TrimRight(lines[2][len(minisignTrustedComment):], "\r")
+	if !ed25519.Verify(k.key, append(sig, trustedComment...), globalSig) {
+		return ErrBadSignature
+	}
+
+	return nil
+}
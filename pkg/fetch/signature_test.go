@@ -0,0 +1,167 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package fetch
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+var testKeyID = []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+func generateKey() (ed25519.PublicKey, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	return pub, priv
+}
+
+func minisignPublicKey(pub ed25519.PublicKey) string {
+	raw := append(append([]byte(minisignAlgorithm), testKeyID...), pub...)
+	return "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(raw) + "\n"
+}
+
+func minisign(priv ed25519.PrivateKey, data []byte, algorithm string) []byte {
+	if algorithm == minisignHashedAlgorithm {
+		hash := blake2b.Sum512(data)
+		data = hash[:]
+	}
+
+	sig := ed25519.Sign(priv, data)
+	trustedComment := "timestamp:1585000000\tfile:hosts"
+	globalSig := ed25519.Sign(priv, append(append([]byte{}, sig...), trustedComment...))
+
+	raw := append(append([]byte(algorithm), testKeyID...), sig...)
+	return []byte("untrusted comment: signature\n" +
+		base64.StdEncoding.EncodeToString(raw) + "\n" +
+		minisignTrustedComment + trustedComment + "\n" +
+		base64.StdEncoding.EncodeToString(globalSig) + "\n")
+}
+
+func TestRawSignature(t *testing.T) {
+	pub, priv := generateKey()
+	data := []byte("0.0.0.0 ads.example.com\n")
+
+	key, err := ParsePublicKey(base64.StdEncoding.EncodeToString(pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key.SignatureSuffix() != ".sig" {
+		t.Error(key.SignatureSuffix())
+	}
+
+	sig := ed25519.Sign(priv, data)
+
+	if err := key.Verify(data, sig); err != nil {
+		t.Error(err)
+	}
+
+	if err := key.Verify(data, []byte(base64.StdEncoding.EncodeToString(sig)+"\n")); err != nil {
+		t.Error(err)
+	}
+
+	if err := key.Verify([]byte("0.0.0.0 example.com\n"), sig); err != ErrBadSignature {
+		t.Error(err)
+	}
+}
+
+func TestMinisignSignature(t *testing.T) {
+	pub, priv := generateKey()
+	data := []byte("0.0.0.0 ads.example.com\n")
+
+	key, err := ParsePublicKey(minisignPublicKey(pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key.SignatureSuffix() != ".minisig" {
+		t.Error(key.SignatureSuffix())
+	}
+
+	for _, algorithm := range []string{minisignAlgorithm, minisignHashedAlgorithm} {
+		sig := minisign(priv, data, algorithm)
+
+		if err := key.Verify(data, sig); err != nil {
+			t.Error(algorithm, err)
+		}
+
+		if err := key.Verify([]byte("0.0.0.0 example.com\n"), sig); err != ErrBadSignature {
+			t.Error(algorithm, err)
+		}
+	}
+}
+
+func TestMinisignTrustedComment(t *testing.T) {
+	pub, priv := generateKey()
+	data := []byte("0.0.0.0 ads.example.com\n")
+
+	key, err := ParsePublicKey(minisignPublicKey(pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig := minisign(priv, data, minisignHashedAlgorithm)
+	tampered := bytes.Replace(sig, []byte("file:hosts"), []byte("file:other"), 1)
+
+	if err := key.Verify(data, tampered); err != ErrBadSignature {
+		t.Error(err)
+	}
+}
+
+func TestMinisignWrongKey(t *testing.T) {
+	pub, _ := generateKey()
+	_, otherPriv := generateKey()
+
+	key, err := ParsePublicKey(minisignPublicKey(pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("0.0.0.0 ads.example.com\n")
+	sig := minisign(otherPriv, data, minisignHashedAlgorithm)
+
+	if err := key.Verify(data, sig); err != ErrBadSignature {
+		t.Error(err)
+	}
+
+	key.keyID = []byte{8, 7, 6, 5, 4, 3, 2, 1}
+	if err := key.Verify(data, sig); err != ErrWrongKey {
+		t.Error(err)
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	for _, s := range []string{"", "xyz", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := ParsePublicKey(s); err != ErrBadPublicKey {
+			t.Error(s, err)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package history keeps versions of the blocklist, to allow rollback of bad
+// updates.
+package history
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/blocklist"
+	"github.com/dimkr/dohli/pkg/blocks"
+	"github.com/dimkr/dohli/pkg/cache"
+)
+
+const (
+	versionKeyPrefix = "blocklist:version:"
+	domainsKeyPrefix = "blocklist:domains:"
+	indexKey         = "blocklist:versions"
+	activeKey        = "blocklist:active"
+
+	// in hex digits
+	idLength = 12
+
+	maxVersions = 10
+	maxSample   = 10
+
+	maxIndexUpdateAttempts = 10
+)
+
+// ErrNoSuchVersion is returned when a version does not exist.
+var ErrNoSuchVersion = errors.New("no such version")
+
+var errIndexConflict = errors.New("the list of versions is changed by others")
+
+// Diff summarizes the changes between a version and the previous one.
+type Diff struct {
+	Previous      string   `json:"previous,omitempty"`
+	Added         int      `json:"added"`
+	Removed       int      `json:"removed"`
+	AddedSample   []string `json:"added_sample,omitempty"`
+	RemovedSample []string `json:"removed_sample,omitempty"`
+}
+
+// Version describes a blocklist snapshot.
+type Version struct {
+	ID      string            `json:"id"`
+	Created time.Time         `json:"created"`
+	Count   int               `json:"count"`
+	Sources map[string]string `json:"sources"`
+	Diff    Diff              `json:"diff"`
+}
+
+// History is a list of blocklist versions, stored in a cache.
+type History struct {
+	cache *cache.Cache
+}
+
+// OpenHistory opens the history stored in a cache.
+func OpenHistory(c *cache.Cache) *History {
+	return &History{cache: c}
+}
+
+// getVersionID returns the ID of a sorted blocklist, which is derived from
+// its contents.
+func getVersionID(domains []string) string {
+	hash := sha256.New()
+	for _, domain := range domains {
+		hash.Write([]byte(domain))
+		hash.Write([]byte{'\n'})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:idLength]
+}
+
+// diff compares two sorted blocklists.
+func diff(previous, current []string) Diff {
+	var d Diff
+
+	i, j := 0, 0
+	for i < len(previous) || j < len(current) {
+		switch {
+		case j == len(current) || (i < len(previous) && previous[i] < current[j]):
+			d.Removed++
+			if len(d.RemovedSample) < maxSample {
+				d.RemovedSample = append(d.RemovedSample, previous[i])
+			}
+			i++
+
+		case i == len(previous) || current[j] < previous[i]:
+			d.Added++
+			if len(d.AddedSample) < maxSample {
+				d.AddedSample = append(d.AddedSample, current[j])
+			}
+			j++
+
+		default:
+			i++
+			j++
+		}
+	}
+
+	return d
+}
+
+func compress(domains []string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(strings.Join(domains, "\n"))); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decompress(compressed []byte) ([]string, error) {
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return []string{}, nil
+	}
+
+	return strings.Split(string(data), "\n"), nil
+}
+
+func parseIndex(j []byte) []string {
+	var ids []string
+	if j != nil {
+		json.Unmarshal(j, &ids)
+	}
+
+	return ids
+}
+
+func (h *History) index(ctx context.Context) []string {
+	return parseIndex(h.cache.Backend(ctx).Get(indexKey))
+}
+
+// updateIndex changes the list of versions and returns the IDs of removed
+// versions. The index is shared by all replicas, so the change is retried if
+// another replica changes the index at the same time.
+func (h *History) updateIndex(ctx context.Context, update func([]string) []string) ([]string, error) {
+	backend := h.cache.Backend(ctx)
+
+	for i := 0; i < maxIndexUpdateAttempts; i++ {
+		j := backend.Get(indexKey)
+		ids := parseIndex(j)
+
+		updated := update(append([]string(nil), ids...))
+		updatedJSON, err := json.Marshal(updated)
+		if err != nil {
+			return nil, err
+		}
+
+		if !backend.CompareAndSwap(indexKey, j, updatedJSON, 0) {
+			continue
+		}
+
+		kept := make(map[string]bool, len(updated))
+		for _, id := range updated {
+			kept[id] = true
+		}
+
+		var removed []string
+		for _, id := range ids {
+			if !kept[id] {
+				removed = append(removed, id)
+			}
+		}
+
+		return removed, nil
+	}
+
+	return nil, errIndexConflict
+}
+
+// Get returns a version, or nil.
+func (h *History) Get(ctx context.Context, id string) *Version {
+	j := h.cache.Backend(ctx).Get(versionKeyPrefix + id)
+	if j == nil {
+		return nil
+	}
+
+	var version Version
+	if err := json.Unmarshal(j, &version); err != nil {
+		return nil
+	}
+
+	return &version
+}
+
+// Versions returns all versions, from the newest to the oldest.
+func (h *History) Versions(ctx context.Context) []Version {
+	ids := h.index(ctx)
+
+	versions := make([]Version, 0, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		if version := h.Get(ctx, ids[i]); version != nil {
+			versions = append(versions, *version)
+		}
+	}
+
+	return versions
+}
+
+// Domains returns the sorted list of domains in a version.
+func (h *History) Domains(ctx context.Context, id string) ([]string, error) {
+	compressed := h.cache.Backend(ctx).Get(domainsKeyPrefix + id)
+	if compressed == nil {
+		return nil, ErrNoSuchVersion
+	}
+
+	return decompress(compressed)
+}
+
+// Record stores a sorted blocklist as a new version, unless this version
+// already exists. Recording of a new version cancels any previous rollback.
+func (h *History) Record(ctx context.Context, domains []string, sources map[string]string) (*Version, error) {
+	id := getVersionID(domains)
+
+	if version := h.Get(ctx, id); version != nil {
+		return version, nil
+	}
+
+	version := Version{
+		ID:      id,
+		Created: time.Now().UTC(),
+		Count:   len(domains),
+		Sources: sources,
+	}
+
+	ids := h.index(ctx)
+
+	if len(ids) > 0 {
+		previousID := ids[len(ids)-1]
+
+		if previous, err := h.Domains(ctx, previousID); err == nil {
+			version.Diff = diff(previous, domains)
+			version.Diff.Previous = previousID
+		}
+	} else {
+		version.Diff = diff(nil, domains)
+	}
+
+	compressed, err := compress(domains)
+	if err != nil {
+		return nil, err
+	}
+
+	j, err := json.Marshal(version)
+	if err != nil {
+		return nil, err
+	}
+
+	backend := h.cache.Backend(ctx)
+	backend.Set(domainsKeyPrefix+id, compressed, 0)
+	backend.Set(versionKeyPrefix+id, j, 0)
+
+	removed, err := h.updateIndex(ctx, func(ids []string) []string {
+		for _, other := range ids {
+			if other == id {
+				return ids
+			}
+		}
+
+		ids = append(ids, id)
+		if len(ids) > maxVersions {
+			ids = ids[len(ids)-maxVersions:]
+		}
+
+		return ids
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, old := range removed {
+		backend.Delete(domainsKeyPrefix + old)
+		backend.Delete(versionKeyPrefix + old)
+	}
+
+	backend.Delete(activeKey)
+	return &version, nil
+}
+
+// Active returns the version selected by a rollback, or nil if the latest
+// version should be used.
+func (h *History) Active(ctx context.Context) *Version {
+	id := h.cache.Backend(ctx).Get(activeKey)
+	if id == nil {
+		return nil
+	}
+
+	return h.Get(ctx, string(id))
+}
+
+// Rollback selects an earlier version and unblocks all domains blocked by a
+// source, that are missing from this version. It returns the number of
+// unblocked domains.
+func (h *History) Rollback(ctx context.Context, id string, registry *blocks.Registry, source string) (int, error) {
+	domains, err := h.Domains(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	if h.Get(ctx, id) == nil {
+		return 0, ErrNoSuchVersion
+	}
+
+	h.cache.Backend(ctx).Set(activeKey, []byte(id), 0)
+
+	// the version can contain wildcards, which include subdomains
+	table := blocklist.NewTable(&blocklist.Artifact{Rules: domains}, 0)
+	defer table.Close()
+
+	n := 0
+	for _, record := range registry.Records(ctx) {
+		if record.Source == source && table.Match(record.Domain) == "" {
+			registry.Unblock(ctx, record.Domain)
+			n++
+		}
+	}
+
+	return n, nil
+}
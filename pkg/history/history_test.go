@@ -0,0 +1,195 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package history
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/dimkr/dohli/pkg/blocks"
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/verdict"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func openHistory() (*History, *cache.Cache) {
+	c, err := cache.OpenCache(&cache.MemoryBackend{})
+	if err != nil {
+		panic(err)
+	}
+
+	return OpenHistory(c), c
+}
+
+func TestRecord(t *testing.T) {
+	h, _ := openHistory()
+	ctx := context.Background()
+
+	first, err := h.Record(ctx, []string{"a.com", "b.com"}, map[string]string{"x": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Count != 2 || first.Diff.Added != 2 || first.Diff.Removed != 0 {
+		t.Error(first)
+	}
+
+	again, err := h.Record(ctx, []string{"a.com", "b.com"}, map[string]string{"x": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again.ID != first.ID || len(h.Versions(ctx)) != 1 {
+		t.Error(again)
+	}
+
+	second, err := h.Record(ctx, []string{"b.com", "c.com", "d.com"}, map[string]string{"x": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if second.Diff.Previous != first.ID || second.Diff.Added != 2 || second.Diff.Removed != 1 || second.Diff.RemovedSample[0] != "a.com" {
+		t.Error(second.Diff)
+	}
+
+	versions := h.Versions(ctx)
+	if len(versions) != 2 || versions[0].ID != second.ID || versions[1].ID != first.ID {
+		t.Error(versions)
+	}
+
+	domains, err := h.Domains(ctx, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(domains) != 2 || domains[0] != "a.com" || domains[1] != "b.com" {
+		t.Error(domains)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	h, _ := openHistory()
+	ctx := context.Background()
+
+	var ids []string
+	for _, domain := range []string{"a.com", "b.com", "c.com", "d.com", "e.com", "f.com", "g.com", "h.com", "i.com", "j.com", "k.com", "l.com"} {
+		version, err := h.Record(ctx, []string{domain}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, version.ID)
+	}
+
+	if len(h.Versions(ctx)) != maxVersions {
+		t.Error(len(h.Versions(ctx)))
+	}
+
+	if h.Get(ctx, ids[0]) != nil {
+		t.Error(ids[0])
+	}
+
+	if _, err := h.Domains(ctx, ids[0]); err != ErrNoSuchVersion {
+		t.Error(err)
+	}
+}
+
+func TestRecordConcurrently(t *testing.T) {
+	h, _ := openHistory()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < maxVersions; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := h.Record(ctx, []string{fmt.Sprintf("%d.com", i)}, nil); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if versions := h.Versions(ctx); len(versions) != maxVersions {
+		t.Error(len(versions))
+	}
+}
+
+func TestRollback(t *testing.T) {
+	h, c := openHistory()
+	ctx := context.Background()
+	registry := blocks.OpenRegistry(c)
+
+	good, err := h.Record(ctx, []string{"*.e.com", "a.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.Record(ctx, []string{"a.com", "b.com", "c.com", "x.e.com"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.Active(ctx) != nil {
+		t.Error("pinned")
+	}
+
+	// x.e.com is included in the good version by a wildcard
+	for _, domain := range []string{"a.com", "b.com", "x.e.com"} {
+		v := verdict.Verdict{Source: "hosts", Rule: domain, Confidence: 1}
+		registry.Block(ctx, domain, dnsmessage.TypeA, &v)
+	}
+
+	v := verdict.Verdict{Source: blocks.SourceAdmin, Confidence: 1}
+	registry.Block(ctx, "c.com", dnsmessage.TypeA, &v)
+
+	n, err := h.Rollback(ctx, good.ID, registry, "hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 1 {
+		t.Error(n)
+	}
+
+	if registry.Lookup(ctx, "a.com") == nil || registry.Lookup(ctx, "b.com") != nil || registry.Lookup(ctx, "c.com") == nil || registry.Lookup(ctx, "x.e.com") == nil {
+		t.Error(registry.Domains(ctx))
+	}
+
+	if active := h.Active(ctx); active == nil || active.ID != good.ID {
+		t.Error(active)
+	}
+
+	if _, err := h.Rollback(ctx, "nonexistent", registry, "hosts"); err != ErrNoSuchVersion {
+		t.Error(err)
+	}
+
+	if _, err := h.Record(ctx, []string{"d.com"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if h.Active(ctx) != nil {
+		t.Error("still pinned")
+	}
+}
@@ -25,11 +25,22 @@ package hosts
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 
+	"github.com/dimkr/dohli/pkg/blocklist"
 	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
 )
 
 // We want to disable the Firefox DoH client, if Firefox resolves through
@@ -40,10 +51,95 @@ import (
 // for documentation of the canary domain mechanism.
 const canaryDomain = "use-application-dns.net"
 
-var blockedDomains = map[string]bool{}
+// Source is the source name in verdicts of HostsBlacklist.
+const Source = "hosts"
 
-// HostsBlacklist is a domain blacklist.
-type HostsBlacklist struct{}
+// DefaultPath is the path of the blacklist in the container image.
+const DefaultPath = "/hosts.block"
+
+// the name of the list loaded by HostsBlacklist.Load
+const loadedName = "history"
+
+// ErrNoList is returned when a list has no path, reader or table.
+var ErrNoList = errors.New("list has no path, reader or table")
+
+// ErrNoName is returned when a list that is not a file has no name.
+var ErrNoName = errors.New("list has no name")
+
+// List is a blacklist file, or a blacklist already in memory.
+type List struct {
+	// Name labels the rules of this list in verdicts; the default is the
+	// file name without extension
+	Name string
+
+	// Path is a table, a compiled text blocklist or a hosts file, which is
+	// read again on reload
+	Path string
+
+	// Reader is read once, instead of a file
+	Reader io.Reader
+
+	// Table is used instead of a file
+	Table *blocklist.Table
+}
+
+type loadedList struct {
+	name  string
+	table *blocklist.Table
+
+	// tables read from files are closed when replaced
+	owned bool
+}
+
+// HostsBlacklist is a domain blacklist, merged from multiple lists.
+type HostsBlacklist struct {
+	lists []List
+
+	lock   sync.RWMutex
+	loaded []loadedList
+}
+
+// OpenHostsBlacklist loads a blacklist from lists.
+func OpenHostsBlacklist(lists ...List) (*HostsBlacklist, error) {
+	hb := &HostsBlacklist{lists: make([]List, 0, len(lists))}
+
+	for _, list := range lists {
+		switch {
+		case list.Path != "":
+			if list.Name == "" {
+				list.Name = strings.TrimSuffix(filepath.Base(list.Path), filepath.Ext(list.Path))
+			}
+
+		case list.Reader != nil:
+			if list.Name == "" {
+				return nil, ErrNoName
+			}
+
+			t, err := readTable(list.Reader, list.Name)
+			if err != nil {
+				return nil, err
+			}
+
+			list = List{Name: list.Name, Table: t}
+
+		case list.Table != nil:
+			if list.Name == "" {
+				return nil, ErrNoName
+			}
+
+		default:
+			return nil, ErrNoList
+		}
+
+		hb.lists = append(hb.lists, list)
+	}
+
+	if err := hb.Reload(); err != nil {
+		return nil, err
+	}
+
+	return hb, nil
+}
 
 func (hb *HostsBlacklist) Connect() error {
 	return nil
@@ -53,29 +149,217 @@ func (hb *HostsBlacklist) IsAsync() bool {
 	return false
 }
 
-func (hb *HostsBlacklist) IsBad(_ context.Context, msg *queue.DomainAccessMessage) bool {
-	_, ok := blockedDomains[msg.Domain]
-	return ok
+// match returns the name of the list that matches a domain, the matching rule
+// and its category, or empty strings.
+func (hb *HostsBlacklist) match(domain string) (string, string, verdict.Category) {
+	hb.lock.RLock()
+	defer hb.lock.RUnlock()
+
+	for _, list := range hb.loaded {
+		if rule, category := list.table.MatchCategory(domain); rule != "" {
+			return list.name, rule, category
+		}
+	}
+
+	return "", "", ""
 }
 
-func init() {
-	hosts, err := os.Open("/hosts.block")
-	if err != nil {
-		panic(err)
+func (hb *HostsBlacklist) IsBad(_ context.Context, msg *queue.DomainAccessMessage) *verdict.Verdict {
+	if msg.Domain == canaryDomain {
+		return &verdict.Verdict{Source: Source, Rule: canaryDomain, Confidence: 1}
+	}
+
+	name, rule, category := hb.match(msg.Domain)
+	if rule == "" {
+		return nil
+	}
+
+	// hosts files and lists built without categories are ad blocking lists
+	if category == "" {
+		category = verdict.CategoryAds
+	}
+
+	return &verdict.Verdict{Source: Source, Category: category, Rule: name + ":" + rule, Confidence: 1}
+}
+
+// replace replaces the loaded lists and releases the previous ones.
+func (hb *HostsBlacklist) replace(loaded []loadedList) {
+	hb.lock.Lock()
+	previous := hb.loaded
+	hb.loaded = loaded
+	hb.lock.Unlock()
+
+	for _, list := range previous {
+		if list.owned {
+			list.table.Close()
+		}
+	}
+}
+
+// Reload re-reads all lists read from files.
+func (hb *HostsBlacklist) Reload() error {
+	loaded := make([]loadedList, 0, len(hb.lists))
+
+	for _, list := range hb.lists {
+		if list.Path == "" {
+			loaded = append(loaded, loadedList{name: list.Name, table: list.Table})
+			continue
+		}
+
+		t, err := openTable(list.Path)
+		if err != nil {
+			for _, l := range loaded {
+				if l.owned {
+					l.table.Close()
+				}
+			}
+
+			return err
+		}
+
+		loaded = append(loaded, loadedList{name: list.Name, table: t, owned: true})
+	}
+
+	hb.replace(loaded)
+	return nil
+}
+
+// Domains returns all blacklisted domains and wildcards, sorted.
+func (hb *HostsBlacklist) Domains() []string {
+	hb.lock.RLock()
+	defer hb.lock.RUnlock()
+
+	if len(hb.loaded) == 1 {
+		return hb.loaded[0].table.Rules()
+	}
+
+	unique := map[string]bool{}
+	for _, list := range hb.loaded {
+		for _, rule := range list.table.Rules() {
+			unique[rule] = true
+		}
+	}
+
+	domains := make([]string, 0, len(unique))
+	for domain := range unique {
+		domains = append(domains, domain)
+	}
+
+	sort.Strings(domains)
+	return domains
+}
+
+// Sources returns the SHA-256 hash of each source list of the blacklist.
+func (hb *HostsBlacklist) Sources() map[string]string {
+	hb.lock.RLock()
+	defer hb.lock.RUnlock()
+
+	hashes := map[string]string{}
+	for _, list := range hb.loaded {
+		for _, src := range list.table.Sources() {
+			hashes[src.Name] = src.SHA256
+		}
 	}
-	defer hosts.Close()
 
-	scanner := bufio.NewScanner(hosts)
+	return hashes
+}
+
+// categories returns the categories of rules in the loaded lists.
+func (hb *HostsBlacklist) categories(rules []string) map[string]verdict.Category {
+	hb.lock.RLock()
+	defer hb.lock.RUnlock()
+
+	categories := map[string]verdict.Category{}
+	for _, rule := range rules {
+		for _, list := range hb.loaded {
+			if category := list.table.Category(rule); category != "" {
+				categories[rule] = category
+				break
+			}
+		}
+	}
+
+	return categories
+}
+
+// Load replaces the blacklist with a list of domains and wildcards, until the
+// next reload. Rules keep their category if they're in the loaded lists.
+func (hb *HostsBlacklist) Load(domains []string) {
+	var sources []blocklist.CompiledSource
+	for name, hash := range hb.Sources() {
+		sources = append(sources, blocklist.CompiledSource{Name: name, SHA256: hash})
+	}
+
+	t := blocklist.NewTable(&blocklist.Artifact{Sources: sources, Rules: domains, Categories: hb.categories(domains)}, blocklist.DefaultBloomBitsPerKey)
+	hb.replace([]loadedList{{name: loadedName, table: t}})
+}
+
+// readHosts parses a hosts file, where blocked domains point to 0.0.0.0.
+func readHosts(r io.Reader, name string) (*blocklist.Artifact, error) {
+	var artifact blocklist.Artifact
+	hash := sha256.New()
+
+	scanner := bufio.NewScanner(io.TeeReader(r, hash))
 	for scanner.Scan() {
 		line := scanner.Text()
 		if strings.HasPrefix(line, "0.0.0.0 ") {
-			blockedDomains[line[len("0.0.0.0 "):]] = true
+			artifact.Rules = append(artifact.Rules, line[len("0.0.0.0 "):])
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	artifact.Sources = []blocklist.CompiledSource{{Name: name, SHA256: hex.EncodeToString(hash.Sum(nil))}}
+	return &artifact, nil
+}
+
+// readTable reads a table, a compiled text blocklist or a hosts file into
+// memory.
+func readTable(r io.Reader, name string) (*blocklist.Table, error) {
+	br := bufio.NewReader(r)
+	prefix, _ := br.Peek(64)
+
+	if blocklist.IsTable(prefix) {
+		data, err := ioutil.ReadAll(br)
+		if err != nil {
+			return nil, err
+		}
+
+		return blocklist.ParseTable(data)
+	}
+
+	var artifact *blocklist.Artifact
+	var err error
+
+	if blocklist.IsArtifact(prefix) {
+		artifact, err = blocklist.ReadArtifact(br)
+	} else {
+		artifact, err = readHosts(br, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return blocklist.NewTable(artifact, blocklist.DefaultBloomBitsPerKey), nil
+}
+
+// openTable maps a table file to memory, or reads a blocklist file of
+// another format.
+func openTable(path string) (*blocklist.Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	prefix := make([]byte, 8)
+	n, _ := io.ReadFull(f, prefix)
+
+	if blocklist.IsTable(prefix[:n]) {
+		return blocklist.OpenTable(path)
 	}
 
-	blockedDomains[canaryDomain] = true
+	return readTable(io.MultiReader(bytes.NewReader(prefix[:n]), f), path)
 }
@@ -25,24 +25,33 @@ package hosts
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
 
+	"github.com/dimkr/dohli/pkg/blocklist"
 	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
 )
 
 func ExampleHostsBlacklist_IsBad() {
-	blacklist := HostsBlacklist{}
+	blacklist, _ := OpenHostsBlacklist(List{Name: "ads", Reader: strings.NewReader("0.0.0.0 ads.example.com\n")})
 
-	fmt.Println(blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "use-application-dns.net"}))
-	fmt.Println(blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "use-application-dns.ne"}))
-	fmt.Println(blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "a.use-application-dns.net"}))
-	fmt.Println(blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "use-application-dns"}))
-	fmt.Println(blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: ".net"}))
-	fmt.Println(blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "net"}))
-	fmt.Println(blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: ""}))
-	fmt.Print(blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "wikipedia.org"}))
+	fmt.Println(blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "use-application-dns.net"}).Rule)
+	fmt.Println(blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "ads.example.com"}).Rule)
+	fmt.Println(blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "use-application-dns.ne"}) != nil)
+	fmt.Println(blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "a.use-application-dns.net"}) != nil)
+	fmt.Println(blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "use-application-dns"}) != nil)
+	fmt.Println(blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: ".net"}) != nil)
+	fmt.Println(blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "net"}) != nil)
+	fmt.Println(blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: ""}) != nil)
+	fmt.Print(blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "wikipedia.org"}) != nil)
 
 	// Output:
-	// true
+	// use-application-dns.net
+	// ads:ads.example.com
 	// false
 	// false
 	// false
@@ -51,3 +60,160 @@ func ExampleHostsBlacklist_IsBad() {
 	// false
 	// false
 }
+
+func TestMerge(t *testing.T) {
+	table := blocklist.NewTable(&blocklist.Artifact{
+		Sources: []blocklist.CompiledSource{{Name: "compiled", SHA256: "1234"}},
+		Rules:   []string{"*.tracker.example.com", "both.example.com"},
+	}, 10)
+
+	blacklist, err := OpenHostsBlacklist(
+		List{Name: "hosts", Reader: strings.NewReader("0.0.0.0 ads.example.com\n0.0.0.0 both.example.com\n")},
+		List{Name: "compiled", Table: table},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for domain, rule := range map[string]string{
+		"ads.example.com":       "hosts:ads.example.com",
+		"both.example.com":      "hosts:both.example.com",
+		"a.tracker.example.com": "compiled:*.tracker.example.com",
+	} {
+		if v := blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: domain}); v == nil || v.Rule != rule {
+			t.Error(domain, v)
+		}
+	}
+
+	if domains := blacklist.Domains(); strings.Join(domains, ",") != "*.tracker.example.com,ads.example.com,both.example.com" {
+		t.Error(domains)
+	}
+
+	if sources := blacklist.Sources(); len(sources) != 2 || sources["compiled"] != "1234" || sources["hosts"] == "" {
+		t.Error(sources)
+	}
+}
+
+func TestCategories(t *testing.T) {
+	table := blocklist.NewTable(&blocklist.Artifact{
+		Rules:      []string{"*.malware.example.com", "ads.example.com", "other.example.com"},
+		Categories: map[string]verdict.Category{"*.malware.example.com": verdict.CategoryMalware, "ads.example.com": verdict.CategoryAds},
+	}, 10)
+
+	blacklist, err := OpenHostsBlacklist(List{Name: "compiled", Table: table})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for domain, category := range map[string]verdict.Category{
+		"a.malware.example.com": verdict.CategoryMalware,
+		"ads.example.com":       verdict.CategoryAds,
+		"other.example.com":     verdict.CategoryAds,
+	} {
+		if v := blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: domain}); v == nil || v.Category != category {
+			t.Error(domain, v)
+		}
+	}
+
+	// rules loaded from history keep their category
+	blacklist.Load([]string{"*.malware.example.com"})
+
+	if v := blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "a.malware.example.com"}); v == nil || v.Category != verdict.CategoryMalware {
+		t.Error(v)
+	}
+}
+
+func TestBadList(t *testing.T) {
+	if _, err := OpenHostsBlacklist(List{}); err != ErrNoList {
+		t.Error(err)
+	}
+
+	if _, err := OpenHostsBlacklist(List{Reader: strings.NewReader("")}); err != ErrNoName {
+		t.Error(err)
+	}
+
+	if _, err := OpenHostsBlacklist(List{Path: "/nonexistent/hosts.block"}); err == nil {
+		t.Error("missing file was loaded")
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ads.block")
+	if err := (&blocklist.Artifact{Rules: []string{"a.example.com"}}).WriteTableFile(path, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	blacklist, err := OpenHostsBlacklist(List{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "a.example.com"}); v == nil || v.Rule != "ads:a.example.com" {
+		t.Error(v)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("0.0.0.0 b.example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := blacklist.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "a.example.com"}) != nil {
+		t.Error()
+	}
+
+	if blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "b.example.com"}) == nil {
+		t.Error()
+	}
+
+	os.Remove(path)
+
+	if err := blacklist.Reload(); err == nil {
+		t.Error("missing file was reloaded")
+	}
+
+	if blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "b.example.com"}) == nil {
+		t.Error()
+	}
+}
+
+func TestLoad(t *testing.T) {
+	blacklist, err := OpenHostsBlacklist(List{Name: "hosts", Reader: strings.NewReader("0.0.0.0 c.example.com\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blacklist.Load([]string{"b.example.com", "*.a.example.com"})
+
+	if domains := blacklist.Domains(); len(domains) != 2 || domains[0] != "*.a.example.com" || domains[1] != "b.example.com" {
+		t.Error(domains)
+	}
+
+	if v := blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "x.a.example.com"}); v == nil || v.Rule != "history:*.a.example.com" {
+		t.Error(v)
+	}
+
+	if blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "c.example.com"}) != nil {
+		t.Error()
+	}
+
+	if sources := blacklist.Sources(); len(sources) != 1 || sources["hosts"] == "" {
+		t.Error(sources)
+	}
+
+	if err := blacklist.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if blacklist.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "c.example.com"}) == nil {
+		t.Error()
+	}
+}
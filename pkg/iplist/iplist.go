@@ -0,0 +1,305 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package iplist blocks domains that resolve to addresses in IP lists.
+package iplist
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+// Source is the source name in verdicts of Blocker.
+const Source = "ip"
+
+// ErrNoList is returned when a list has no path or reader.
+var ErrNoList = errors.New("list has no path or reader")
+
+// ErrNoName is returned when a list that is not a file has no name.
+var ErrNoName = errors.New("list has no name")
+
+// List is a list of addresses and networks, one per line. Comments start with
+// # or ;, like in the Spamhaus DROP list.
+type List struct {
+	// Name labels the rules of this list in verdicts; the default is the
+	// file name without extension
+	Name string
+
+	// Path is read again on reload
+	Path string
+
+	// Reader is read once, instead of a file
+	Reader io.Reader
+}
+
+type network struct {
+	list string
+	net  *net.IPNet
+}
+
+// networks is a set of networks of one address family, indexed by prefix
+// length.
+type networks struct {
+	// networks with each prefix length, keyed by the masked address
+	byLength map[int]map[string]network
+	// all prefix lengths, from the longest to the shortest
+	lengths []int
+	// the number of bits in an address
+	bits int
+}
+
+func newNetworks(bits int) *networks {
+	return &networks{byLength: map[int]map[string]network{}, bits: bits}
+}
+
+func (ns *networks) add(list string, n *net.IPNet) {
+	ones, _ := n.Mask.Size()
+
+	byKey, ok := ns.byLength[ones]
+	if !ok {
+		byKey = map[string]network{}
+		ns.byLength[ones] = byKey
+		ns.lengths = append(ns.lengths, ones)
+		sort.Sort(sort.Reverse(sort.IntSlice(ns.lengths)))
+	}
+
+	byKey[string(n.IP.Mask(net.CIDRMask(ones, ns.bits)))] = network{list: list, net: n}
+}
+
+func (ns *networks) match(ip net.IP) *network {
+	for _, length := range ns.lengths {
+		if n, ok := ns.byLength[length][string(ip.Mask(net.CIDRMask(length, ns.bits)))]; ok {
+			return &n
+		}
+	}
+
+	return nil
+}
+
+func (ns *networks) len() int {
+	n := 0
+	for _, byKey := range ns.byLength {
+		n += len(byKey)
+	}
+
+	return n
+}
+
+// Set is a set of networks. IPv4 and IPv6 networks are kept apart, so an IPv6
+// network like ::/8 never matches an IPv4 address.
+type Set struct {
+	v4 *networks
+	v6 *networks
+}
+
+func newSet() *Set {
+	return &Set{v4: newNetworks(32), v6: newNetworks(128)}
+}
+
+func parseNetwork(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("bad address: %s", s)
+		}
+
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+
+	_, n, err := net.ParseCIDR(s)
+	return n, err
+}
+
+func (s *Set) add(list string, n *net.IPNet) {
+	if _, bits := n.Mask.Size(); bits == 32 {
+		s.v4.add(list, &net.IPNet{IP: n.IP.To4(), Mask: n.Mask})
+	} else {
+		s.v6.add(list, n)
+	}
+}
+
+func (s *Set) read(list string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i != -1 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		network, err := parseNetwork(fields[0])
+		if err != nil {
+			return fmt.Errorf("%s: line %d: %w", list, n, err)
+		}
+
+		s.add(list, network)
+	}
+
+	return scanner.Err()
+}
+
+// Match returns the name of the list and the network that contain an address,
+// preferring the most specific network. IPv4-mapped IPv6 addresses are matched
+// against IPv4 networks.
+func (s *Set) Match(ip net.IP) (string, *net.IPNet) {
+	var n *network
+
+	if ip4 := ip.To4(); ip4 != nil {
+		n = s.v4.match(ip4)
+	} else if len(ip) == net.IPv6len {
+		n = s.v6.match(ip)
+	}
+
+	if n == nil {
+		return "", nil
+	}
+
+	return n.list, n.net
+}
+
+// Len returns the number of networks in a set.
+func (s *Set) Len() int {
+	return s.v4.len() + s.v6.len()
+}
+
+// Blocker blocks domains that resolve to an address in a list.
+type Blocker struct {
+	lists []List
+	// lists read from a reader
+	static *Set
+
+	lock sync.RWMutex
+	set  *Set
+}
+
+// OpenBlocker loads IP lists.
+func OpenBlocker(lists ...List) (*Blocker, error) {
+	b := &Blocker{static: newSet()}
+
+	for _, list := range lists {
+		switch {
+		case list.Path != "":
+			if list.Name == "" {
+				list.Name = strings.TrimSuffix(filepath.Base(list.Path), filepath.Ext(list.Path))
+			}
+
+			b.lists = append(b.lists, list)
+
+		case list.Reader != nil:
+			if list.Name == "" {
+				return nil, ErrNoName
+			}
+
+			if err := b.static.read(list.Name, list.Reader); err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, ErrNoList
+		}
+	}
+
+	if err := b.Reload(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (b *Blocker) Connect() error {
+	return nil
+}
+
+func (b *Blocker) IsAsync() bool {
+	return false
+}
+
+// Reload re-reads all lists read from files.
+func (b *Blocker) Reload() error {
+	set := newSet()
+
+	for _, ns := range []*networks{b.static.v4, b.static.v6} {
+		for _, byKey := range ns.byLength {
+			for _, n := range byKey {
+				set.add(n.list, n.net)
+			}
+		}
+	}
+
+	for _, list := range b.lists {
+		f, err := os.Open(list.Path)
+		if err != nil {
+			return err
+		}
+
+		err = set.read(list.Name, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	b.lock.Lock()
+	b.set = set
+	b.lock.Unlock()
+
+	return nil
+}
+
+func (b *Blocker) IsBad(_ context.Context, msg *queue.DomainAccessMessage) *verdict.Verdict {
+	b.lock.RLock()
+	set := b.set
+	b.lock.RUnlock()
+
+	for _, addr := range msg.Addresses {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+
+		if list, n := set.Match(ip); n != nil {
+			return &verdict.Verdict{Source: Source, Category: verdict.CategoryMalware, Rule: fmt.Sprintf("%s:%s (%s)", list, n, addr), Confidence: 1}
+		}
+	}
+
+	return nil
+}
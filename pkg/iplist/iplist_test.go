@@ -0,0 +1,160 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package iplist
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dimkr/dohli/pkg/queue"
+)
+
+const drop = `; Spamhaus DROP List
+1.10.16.0/20 ; SBL256894
+1.10.16.128/25 ; more specific
+203.0.113.7
+2001:db8::/32 # documentation
+`
+
+func ExampleBlocker_IsBad() {
+	b, err := OpenBlocker(List{Name: "drop", Reader: strings.NewReader(drop)})
+	if err != nil {
+		panic(err)
+	}
+
+	for _, addrs := range [][]string{
+		{"1.10.16.5"},
+		{"8.8.8.8", "1.10.16.200"},
+		{"203.0.113.7"},
+		{"2001:db8::1"},
+		{"8.8.8.8", "2001:4860::8888"},
+		nil,
+	} {
+		fmt.Println(b.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "example.com", Addresses: addrs}))
+	}
+
+	// Output:
+	// ip (malware): drop:1.10.16.0/20 (1.10.16.5)
+	// ip (malware): drop:1.10.16.128/25 (1.10.16.200)
+	// ip (malware): drop:203.0.113.7/32 (203.0.113.7)
+	// ip (malware): drop:2001:db8::/32 (2001:db8::1)
+	// <nil>
+	// <nil>
+}
+
+func TestSetMatchMapped(t *testing.T) {
+	b, err := OpenBlocker(List{Name: "drop", Reader: strings.NewReader(drop)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if list, n := b.set.Match(net.ParseIP("::ffff:1.10.16.1")); list != "drop" || n == nil {
+		t.Error(list, n)
+	}
+
+	if b.set.Len() != 4 {
+		t.Error(b.set.Len())
+	}
+}
+
+func TestSetMatchFamily(t *testing.T) {
+	b, err := OpenBlocker(List{Name: "bogons6", Reader: strings.NewReader("::/8\n")}, List{Name: "bogons", Reader: strings.NewReader("0.0.0.0/8\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ::/8 contains all IPv4-mapped addresses, but it's an IPv6 network
+	if list, n := b.set.Match(net.ParseIP("208.80.154.224")); n != nil {
+		t.Error(list, n)
+	}
+
+	if list, n := b.set.Match(net.ParseIP("::1")); list != "bogons6" || n == nil {
+		t.Error(list, n)
+	}
+
+	if list, n := b.set.Match(net.ParseIP("0.1.2.3")); list != "bogons" || n == nil {
+		t.Error(list, n)
+	}
+
+	// 0.0.0.0/8 must not match IPv6 addresses that start with 8 zero bits
+	if list, n := b.set.Match(net.ParseIP("::2")); list != "bogons6" {
+		t.Error(list, n)
+	}
+}
+
+func TestReload(t *testing.T) {
+	f, err := ioutil.TempFile("", "bogons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("10.0.0.0/8\n")
+	f.Close()
+
+	b, err := OpenBlocker(List{Name: "drop", Reader: strings.NewReader(drop)}, List{Name: "bogons", Path: f.Name()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := queue.DomainAccessMessage{Domain: "example.com", Addresses: []string{"10.1.2.3"}}
+	if v := b.IsBad(context.Background(), &msg); v == nil || v.Rule != "bogons:10.0.0.0/8 (10.1.2.3)" {
+		t.Error(v)
+	}
+
+	if err := ioutil.WriteFile(f.Name(), []byte("192.168.0.0/16\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := b.IsBad(context.Background(), &msg); v != nil {
+		t.Error(v)
+	}
+
+	msg.Addresses = []string{"1.10.16.1"}
+	if v := b.IsBad(context.Background(), &msg); v == nil {
+		t.Error("static list was lost")
+	}
+}
+
+func TestBadList(t *testing.T) {
+	if _, err := OpenBlocker(List{Name: "bad", Reader: strings.NewReader("1.2.3.0/33\n")}); err == nil {
+		t.Error("bad network was accepted")
+	}
+
+	if _, err := OpenBlocker(List{Reader: strings.NewReader("")}); err != ErrNoName {
+		t.Error(err)
+	}
+
+	if _, err := OpenBlocker(List{Name: "empty"}); err != ErrNoList {
+		t.Error(err)
+	}
+}
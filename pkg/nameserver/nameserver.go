@@ -0,0 +1,173 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package nameserver blocks domains served by blacklisted nameservers.
+package nameserver
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/blocklist"
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/circuit"
+	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Source is the source name in verdicts of Blocker.
+const Source = "nameserver"
+
+const (
+	lookupTimeout = 5 * time.Second
+)
+
+// Blocker blocks domains if the nameservers of their registered domain match
+// a blacklist of nameservers.
+//
+// Verdicts are cached per registered domain, so all names under a registered
+// domain cost one lookup.
+type Blocker struct {
+	// Verdicts caches the verdict of each registered domain
+	Verdicts *verdict.Cache
+
+	// Breaker protects the resolver and the worker from each other
+	Breaker *circuit.Breaker
+
+	path string
+
+	lock  sync.RWMutex
+	table *blocklist.Table
+
+	lookupNS func(context.Context, string) ([]*net.NS, error)
+}
+
+// OpenBlocker loads a blacklist of nameservers from a file, in the domains
+// format of listbuild: one nameserver per line, or a wildcard that matches
+// all nameservers under a domain, e.g. *.example.com.
+func OpenBlocker(c *cache.Cache, path string) (*Blocker, error) {
+	b := &Blocker{Verdicts: verdict.OpenCache(c, Source), Breaker: circuit.OpenBreaker(c, Source), path: path, lookupNS: net.DefaultResolver.LookupNS}
+	b.Breaker.IsFailure = isFailure
+
+	if err := b.Reload(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (b *Blocker) Connect() error {
+	return nil
+}
+
+func (b *Blocker) IsAsync() bool {
+	return true
+}
+
+// Reload re-reads the blacklist. Cached verdicts are not invalidated.
+func (b *Blocker) Reload() error {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rules, err := blocklist.Parse(blocklist.FormatDomains, f)
+	if err != nil {
+		return err
+	}
+
+	table := blocklist.NewTable(&blocklist.Artifact{Rules: blocklist.Compile(rules)}, blocklist.DefaultBloomBitsPerKey)
+
+	b.lock.Lock()
+	b.table = table
+	b.lock.Unlock()
+
+	return nil
+}
+
+// isFailure determines whether or not a lookup failed because of the resolver:
+// a missing zone is not a failure.
+func isFailure(err error) bool {
+	dnsErr, ok := err.(*net.DNSError)
+	return !ok || !dnsErr.IsNotFound
+}
+
+func (b *Blocker) nameservers(parent context.Context, zone string, msg *queue.DomainAccessMessage) ([]string, error) {
+	if nameservers, ok := msg.Nameservers[zone]; ok && len(nameservers) > 0 {
+		return nameservers, nil
+	}
+
+	var records []*net.NS
+
+	if err := b.Breaker.Do(parent, func(parent context.Context) error {
+		ctx, cancel := context.WithTimeout(parent, lookupTimeout)
+		defer cancel()
+
+		var err error
+		records, err = b.lookupNS(ctx, zone)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	nameservers := make([]string, 0, len(records))
+	for _, record := range records {
+		nameservers = append(nameservers, strings.ToLower(strings.TrimSuffix(record.Host, ".")))
+	}
+
+	return nameservers, nil
+}
+
+func (b *Blocker) check(nameservers []string) *verdict.Verdict {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	for _, ns := range nameservers {
+		if rule := b.table.Match(ns); rule != "" {
+			return &verdict.Verdict{Source: Source, Category: verdict.CategoryMalware, Rule: ns + " (" + rule + ")", Confidence: 1}
+		}
+	}
+
+	return nil
+}
+
+func (b *Blocker) IsBad(ctx context.Context, msg *queue.DomainAccessMessage) *verdict.Verdict {
+	zone, err := publicsuffix.EffectiveTLDPlusOne(msg.Domain)
+	if err != nil {
+		return nil
+	}
+
+	return b.Verdicts.Lookup(ctx, zone, func(ctx context.Context) (*verdict.Verdict, error) {
+		nameservers, err := b.nameservers(ctx, zone, msg)
+		if err != nil {
+			return nil, err
+		}
+
+		return b.check(nameservers), nil
+	})
+}
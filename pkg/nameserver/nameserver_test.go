@@ -0,0 +1,130 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package nameserver
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/queue"
+)
+
+func openBlocker(t *testing.T) (*Blocker, map[string]int) {
+	f, err := ioutil.TempFile("", "nameservers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("# bulletproof hosting\n*.bulletproof-dns.example\nns1.parking.example\n")
+	f.Close()
+
+	c, err := cache.OpenCache(&cache.MemoryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := OpenBlocker(c, f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookups := map[string]int{}
+	b.lookupNS = func(_ context.Context, zone string) ([]*net.NS, error) {
+		lookups[zone]++
+
+		switch zone {
+		case "bad.com":
+			return []*net.NS{{Host: "NS2.Bulletproof-DNS.example."}}, nil
+
+		case "parked.co.uk":
+			return []*net.NS{{Host: "ns1.parking.example."}}, nil
+
+		case "good.com":
+			return []*net.NS{{Host: "ns1.good.com."}}, nil
+		}
+
+		return nil, errors.New("no such zone")
+	}
+
+	return b, lookups
+}
+
+func TestIsBad(t *testing.T) {
+	b, lookups := openBlocker(t)
+
+	for domain, rule := range map[string]string{
+		"www.bad.com":      "ns2.bulletproof-dns.example (*.bulletproof-dns.example)",
+		"a.b.parked.co.uk": "ns1.parking.example (ns1.parking.example)",
+		"good.com":         "",
+		"broken.com":       "",
+	} {
+		v := b.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: domain})
+		if (rule == "" && v != nil) || (rule != "" && (v == nil || v.Rule != rule)) {
+			t.Error(domain, v)
+		}
+	}
+
+	if lookups["parked.co.uk"] != 1 {
+		t.Error(lookups)
+	}
+}
+
+func TestZoneCache(t *testing.T) {
+	b, lookups := openBlocker(t)
+
+	for _, domain := range []string{"a.bad.com", "b.bad.com", "bad.com", "good.com", "www.good.com", "broken.com", "broken.com"} {
+		b.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: domain})
+	}
+
+	// failed lookups are not cached
+	if lookups["bad.com"] != 1 || lookups["good.com"] != 1 || lookups["broken.com"] != 2 {
+		t.Error(lookups)
+	}
+
+	if v := b.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "c.bad.com"}); v == nil {
+		t.Error("cached verdict was lost")
+	}
+}
+
+func TestAuthorityNameservers(t *testing.T) {
+	b, lookups := openBlocker(t)
+
+	msg := queue.DomainAccessMessage{
+		Domain:      "www.other.com",
+		Nameservers: map[string][]string{"other.com": {"ns1.parking.example"}},
+	}
+
+	if v := b.IsBad(context.Background(), &msg); v == nil {
+		t.Error()
+	}
+
+	if len(lookups) != 0 {
+		t.Error(lookups)
+	}
+}
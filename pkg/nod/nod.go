@@ -0,0 +1,277 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package nod keeps track of newly observed domains (NODs): registrable
+// domains seen for the first time recently, which are often malicious.
+package nod
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Source is the source name in verdicts of Blocker.
+const Source = "nod"
+
+const (
+	seenKeyPrefix = "seen:"
+	dayKeyPrefix  = "nod:"
+
+	// the time the tracker started recording domains
+	startKey = "nod-start"
+
+	// DayFormat is the format of days in the list of newly observed domains.
+	DayFormat = "2006-01-02"
+
+	// DefaultRetention is the default number of seconds a domain is
+	// remembered after it was last seen.
+	DefaultRetention = 60 * 60 * 24 * 30
+
+	// DefaultHistory is the default number of days newly observed domains
+	// are listed.
+	DefaultHistory = 7
+)
+
+// Domain is a newly observed domain.
+type Domain struct {
+	Domain    string    `json:"domain"`
+	FirstSeen time.Time `json:"first_seen"`
+}
+
+// Day is the number of domains first seen during a day.
+type Day struct {
+	Day     string `json:"day"`
+	Domains int    `json:"domains"`
+}
+
+// Tracker records the time each registrable domain was first seen.
+type Tracker struct {
+	cache *cache.Cache
+
+	// Retention is the number of seconds a domain is remembered after it
+	// was last seen
+	Retention int
+
+	// History is the number of days newly observed domains are listed
+	History int
+}
+
+// OpenTracker opens the record of first-seen times stored in a cache.
+func OpenTracker(c *cache.Cache) *Tracker {
+	return &Tracker{cache: c, Retention: DefaultRetention, History: DefaultHistory}
+}
+
+func parseTime(value []byte) (time.Time, bool) {
+	sec, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(sec, 0).UTC(), true
+}
+
+// Observe records an access to a domain and returns the time its registrable
+// domain was first seen, or false if it has no registrable domain.
+func (t *Tracker) Observe(ctx context.Context, domain string, now time.Time) (time.Time, bool) {
+	registrable, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	backend := t.cache.Backend(ctx)
+	key := seenKeyPrefix + registrable
+
+	now = now.UTC()
+	value := []byte(strconv.FormatInt(now.Unix(), 10))
+
+	// only one worker records the first sighting of a domain
+	if backend.SetNX(key, value, t.Retention) {
+		backend.Set(dayKeyPrefix+now.Format(DayFormat)+":"+registrable, value, (t.History+1)*60*60*24)
+		return now.Truncate(time.Second), true
+	}
+
+	// the record is renewed on every access, so only domains that are not
+	// accessed for a long time are forgotten
+	backend.Expire(key, t.Retention)
+
+	if firstSeen, ok := parseTime(backend.Get(key)); ok {
+		return firstSeen, true
+	}
+
+	return now.Truncate(time.Second), true
+}
+
+// Start returns the time the tracker started recording domains, which is now
+// if it did not start yet.
+func (t *Tracker) Start(ctx context.Context, now time.Time) time.Time {
+	backend := t.cache.Backend(ctx)
+	value := []byte(strconv.FormatInt(now.Unix(), 10))
+
+	if !backend.SetNX(startKey, value, 0) {
+		if start, ok := parseTime(backend.Get(startKey)); ok {
+			return start
+		}
+	}
+
+	return now.UTC().Truncate(time.Second)
+}
+
+// FirstSeen returns the time a registrable domain was first seen, or false if
+// it was not seen.
+func (t *Tracker) FirstSeen(ctx context.Context, domain string) (time.Time, bool) {
+	value := t.cache.Backend(ctx).Get(seenKeyPrefix + domain)
+	if value == nil {
+		return time.Time{}, false
+	}
+
+	return parseTime(value)
+}
+
+// Days returns the number of newly observed domains of each day, from the
+// most recent day.
+func (t *Tracker) Days(ctx context.Context) []Day {
+	counts := map[string]int{}
+
+	for _, key := range t.cache.Backend(ctx).Keys(dayKeyPrefix + "*") {
+		day := strings.TrimPrefix(key, dayKeyPrefix)
+		if i := strings.IndexByte(day, ':'); i != -1 {
+			counts[day[:i]]++
+		}
+	}
+
+	days := make([]Day, 0, len(counts))
+	for day, n := range counts {
+		days = append(days, Day{Day: day, Domains: n})
+	}
+
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Day > days[j].Day
+	})
+
+	return days
+}
+
+// Domains returns the domains first seen during a day, in the order they were
+// seen.
+func (t *Tracker) Domains(ctx context.Context, day time.Time) []Domain {
+	prefix := dayKeyPrefix + day.UTC().Format(DayFormat) + ":"
+	backend := t.cache.Backend(ctx)
+
+	keys := backend.Keys(prefix + "*")
+	domains := make([]Domain, 0, len(keys))
+
+	for _, key := range keys {
+		if firstSeen, ok := parseTime(backend.Get(key)); ok {
+			domains = append(domains, Domain{Domain: key[len(prefix):], FirstSeen: firstSeen})
+		}
+	}
+
+	sort.Slice(domains, func(i, j int) bool {
+		if domains[i].FirstSeen.Equal(domains[j].FirstSeen) {
+			return domains[i].Domain < domains[j].Domain
+		}
+
+		return domains[i].FirstSeen.Before(domains[j].FirstSeen)
+	})
+
+	return domains
+}
+
+// Blocker records the first-seen time of every domain it checks, and blocks
+// domains first seen recently.
+//
+// Every domain is new to a tracker that just started, so domains are not
+// blocked during a learning period as long as Window, since the tracker
+// started.
+type Blocker struct {
+	Tracker *Tracker
+
+	// Window is the time after the first sighting of a domain, during
+	// which it is blocked; if zero, domains are only recorded
+	Window time.Duration
+
+	lock sync.Mutex
+
+	// start is the time the tracker started, once the learning period is
+	// over
+	start time.Time
+}
+
+// learning determines whether or not the learning period was still running at
+// a given time.
+func (b *Blocker) learning(ctx context.Context, now time.Time) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.start.IsZero() {
+		start := b.Tracker.Start(ctx, now)
+		if now.Before(start.Add(b.Window)) {
+			return true
+		}
+
+		b.start = start
+	}
+
+	return now.Before(b.start.Add(b.Window))
+}
+
+// OpenBlocker returns a new blocker that only records domains.
+func OpenBlocker(c *cache.Cache) *Blocker {
+	return &Blocker{Tracker: OpenTracker(c)}
+}
+
+func (b *Blocker) Connect() error {
+	return nil
+}
+
+func (b *Blocker) IsAsync() bool {
+	return false
+}
+
+func (b *Blocker) IsBad(ctx context.Context, msg *queue.DomainAccessMessage) *verdict.Verdict {
+	// delayed or retried messages are judged by the time of the query
+	now := msg.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	firstSeen, ok := b.Tracker.Observe(ctx, msg.Domain, now)
+	if !ok || b.Window <= 0 || b.learning(ctx, now) {
+		return nil
+	}
+
+	expires := firstSeen.Add(b.Window)
+	if !now.Before(expires) {
+		return nil
+	}
+
+	return &verdict.Verdict{Source: Source, Category: verdict.CategoryNewDomain, Rule: "first seen " + firstSeen.Format(time.RFC3339), Confidence: 1, Expires: &expires}
+}
@@ -0,0 +1,191 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package nod
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+func openTracker(t *testing.T) *Tracker {
+	c, err := cache.OpenCache(&cache.MemoryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return OpenTracker(c)
+}
+
+func TestObserve(t *testing.T) {
+	tracker := openTracker(t)
+	ctx := context.Background()
+
+	first := time.Date(2020, 4, 1, 10, 0, 0, 0, time.UTC)
+
+	if firstSeen, ok := tracker.Observe(ctx, "www.example.co.uk", first); !ok || !firstSeen.Equal(first) {
+		t.Error(firstSeen)
+	}
+
+	// subdomains of a registrable domain share its first-seen time
+	if firstSeen, ok := tracker.Observe(ctx, "mail.example.co.uk", first.Add(time.Hour)); !ok || !firstSeen.Equal(first) {
+		t.Error(firstSeen)
+	}
+
+	if firstSeen, ok := tracker.FirstSeen(ctx, "example.co.uk"); !ok || !firstSeen.Equal(first) {
+		t.Error(firstSeen)
+	}
+
+	if _, ok := tracker.FirstSeen(ctx, "example.com"); ok {
+		t.Error()
+	}
+
+	if _, ok := tracker.Observe(ctx, "co.uk", first); ok {
+		t.Error()
+	}
+}
+
+func TestObserveConcurrently(t *testing.T) {
+	tracker := openTracker(t)
+	ctx := context.Background()
+
+	first := time.Date(2020, 4, 1, 10, 0, 0, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	firstSeen := make([]time.Time, 16)
+
+	for i := range firstSeen {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			firstSeen[i], _ = tracker.Observe(ctx, "example.com", first.Add(time.Duration(i)*time.Second))
+		}(i)
+	}
+
+	wg.Wait()
+
+	// all workers agree on the first sighting, which is recorded once
+	for _, seen := range firstSeen {
+		if !seen.Equal(firstSeen[0]) {
+			t.Error(firstSeen)
+			break
+		}
+	}
+
+	if domains := tracker.Domains(ctx, first); len(domains) != 1 || !domains[0].FirstSeen.Equal(firstSeen[0]) {
+		t.Error(domains)
+	}
+}
+
+func TestStart(t *testing.T) {
+	tracker := openTracker(t)
+	ctx := context.Background()
+
+	start := time.Date(2020, 4, 1, 10, 0, 0, 0, time.UTC)
+
+	if s := tracker.Start(ctx, start); !s.Equal(start) {
+		t.Error(s)
+	}
+
+	if s := tracker.Start(ctx, start.Add(time.Hour)); !s.Equal(start) {
+		t.Error(s)
+	}
+}
+
+func TestDays(t *testing.T) {
+	tracker := openTracker(t)
+	ctx := context.Background()
+
+	first := time.Date(2020, 4, 1, 10, 0, 0, 0, time.UTC)
+	tracker.Observe(ctx, "b.example.com", first.Add(time.Minute))
+	tracker.Observe(ctx, "a.example.org", first)
+	tracker.Observe(ctx, "example.net", first.Add(24*time.Hour))
+	tracker.Observe(ctx, "www.example.org", first.Add(24*time.Hour))
+
+	if days := tracker.Days(ctx); len(days) != 2 || days[0] != (Day{Day: "2020-04-02", Domains: 1}) || days[1] != (Day{Day: "2020-04-01", Domains: 2}) {
+		t.Error(days)
+	}
+
+	domains := tracker.Domains(ctx, first)
+	if len(domains) != 2 || domains[0].Domain != "example.org" || !domains[0].FirstSeen.Equal(first) || domains[1].Domain != "example.com" {
+		t.Error(domains)
+	}
+
+	if domains := tracker.Domains(ctx, first.Add(-24*time.Hour)); len(domains) != 0 {
+		t.Error(domains)
+	}
+}
+
+func TestIsBad(t *testing.T) {
+	c, err := cache.OpenCache(&cache.MemoryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := OpenBlocker(c)
+
+	// without a window, domains are only recorded
+	if v := b.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "www.example.com"}); v != nil {
+		t.Error(v)
+	}
+
+	if _, ok := b.Tracker.FirstSeen(context.Background(), "example.com"); !ok {
+		t.Error()
+	}
+
+	b.Window = time.Hour
+
+	// domains are not blocked during the learning period
+	if v := b.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "www.example.net"}); v != nil {
+		t.Error(v)
+	}
+
+	c.Backend(context.Background()).Set(startKey, []byte(strconv.FormatInt(time.Now().Add(-2*time.Hour).Unix(), 10)), 0)
+
+	v := b.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "www.example.com"})
+	if v == nil || v.Source != Source || v.Category != verdict.CategoryNewDomain || v.Expires == nil || time.Until(*v.Expires) > time.Hour || time.Until(*v.Expires) < 59*time.Minute {
+		t.Error(v)
+	}
+
+	b.Tracker.Observe(context.Background(), "example.org", time.Now().Add(-2*time.Hour))
+	if v := b.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "example.org"}); v != nil {
+		t.Error(v)
+	}
+
+	// a delayed message is judged by the time of the query
+	b.Tracker.Observe(context.Background(), "example.info", time.Now().Add(-70*time.Minute))
+	if v := b.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "example.info", Time: time.Now().Add(-50 * time.Minute)}); v == nil || v.Expires == nil || time.Until(*v.Expires) > -9*time.Minute {
+		t.Error(v)
+	}
+
+	// and so is a message sent during the learning period
+	if v := b.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "www.example.com", Time: time.Now().Add(-3 * time.Hour)}); v != nil {
+		t.Error(v)
+	}
+}
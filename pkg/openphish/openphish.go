@@ -0,0 +1,126 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package openphish blocks domains listed by OpenPhish.
+package openphish
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/blocklist"
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/feed"
+	"github.com/dimkr/dohli/pkg/fetch"
+	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+// Source is the source name in verdicts of Blocker.
+const Source = "openphish"
+
+const (
+	feedURL      = "https://openphish.com/feed.txt"
+	feedInterval = time.Hour
+	feedTimeout  = 5 * time.Minute
+
+	// the rule of all verdicts
+	feedRule = "feed"
+)
+
+// Blocker blocks the hosts of phishing URLs in the OpenPhish community feed,
+// which is downloaded periodically.
+type Blocker struct {
+	feed feed.Feed
+
+	lock  sync.RWMutex
+	hosts map[string]bool
+}
+
+// OpenBlocker returns a new OpenPhish blocker; the status of the feed is
+// stored in c.
+func OpenBlocker(c *cache.Cache) *Blocker {
+	b := &Blocker{}
+
+	b.feed = feed.Feed{
+		Source:   fetch.Source{Name: Source, URL: feedURL},
+		Interval: feedInterval,
+		Update:   b.update,
+		Cache:    c,
+	}
+
+	return b
+}
+
+// Connect starts downloading and refreshing the feed in the background.
+func (b *Blocker) Connect() error {
+	b.feed.Start(context.Background())
+	return nil
+}
+
+func (b *Blocker) IsAsync() bool {
+	return false
+}
+
+// Reload downloads the feed again.
+func (b *Blocker) Reload() error {
+	ctx, cancel := context.WithTimeout(context.Background(), feedTimeout)
+	defer cancel()
+
+	return b.feed.Refresh(ctx)
+}
+
+// Status returns the freshness of the feed.
+func (b *Blocker) Status() feed.Status {
+	return b.feed.Status()
+}
+
+func (b *Blocker) update(data []byte) (int, error) {
+	rules, err := blocklist.Parse(blocklist.FormatURLs, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+
+	hosts := make(map[string]bool, len(rules))
+	for _, host := range rules {
+		hosts[host] = true
+	}
+
+	b.lock.Lock()
+	b.hosts = hosts
+	b.lock.Unlock()
+
+	return len(hosts), nil
+}
+
+func (b *Blocker) IsBad(_ context.Context, msg *queue.DomainAccessMessage) *verdict.Verdict {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	if !b.hosts[msg.Domain] {
+		return nil
+	}
+
+	return &verdict.Verdict{Source: Source, Category: verdict.CategoryPhishing, Rule: feedRule, Confidence: 1}
+}
@@ -0,0 +1,71 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package openphish
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+func TestIsBad(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer server.Close()
+
+	c, err := cache.OpenCache(&cache.MemoryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := OpenBlocker(c)
+	b.feed.Source.URL = server.URL + "/feed.txt"
+
+	if err := b.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	for domain, bad := range map[string]bool{
+		"secure-login.paypa1-account.example":     true,
+		"appleid.verify-account.example.net":      true,
+		"office365-mail.example.org":              true,
+		"docs.google.com":                         false,
+		"paypa1-account.example":                  false,
+		"www.secure-login.paypa1-account.example": false,
+	} {
+		v := b.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: domain})
+		if (v != nil) != bad {
+			t.Error(domain, v)
+		} else if v != nil && (v.Source != Source || v.Category != verdict.CategoryPhishing) {
+			t.Error(domain, v)
+		}
+	}
+
+	if status := b.Status(); status.Entries != 3 || status.Updated.IsZero() {
+		t.Error(status)
+	}
+}
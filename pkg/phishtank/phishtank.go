@@ -0,0 +1,201 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package phishtank blocks domains listed by PhishTank.
+package phishtank
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/blocklist"
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/feed"
+	"github.com/dimkr/dohli/pkg/fetch"
+	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+// Source is the source name in verdicts of Blocker.
+const Source = "phishtank"
+
+const (
+	feedURL      = "http://data.phishtank.com/data/online-valid.csv"
+	keyFeedURL   = "http://data.phishtank.com/data/%s/online-valid.csv"
+	feedInterval = time.Hour
+	feedTimeout  = 5 * time.Minute
+
+	// PhishTank does not name the target of many phishing sites
+	unknownTarget = "Other"
+)
+
+// ErrBadFeed is returned when the feed has no url column.
+var ErrBadFeed = errors.New("no url column")
+
+// Blocker blocks the hosts of verified, online phishing URLs listed by
+// PhishTank. The list is downloaded periodically.
+type Blocker struct {
+	feed feed.Feed
+
+	lock sync.RWMutex
+
+	// the rule of each host: the phish ID and target
+	hosts map[string]string
+}
+
+// OpenBlocker returns a new PhishTank blocker; the status of the feed is
+// stored in c. The application key is optional, but downloads without a key
+// are limited.
+func OpenBlocker(c *cache.Cache, appKey string) *Blocker {
+	b := &Blocker{}
+
+	url := feedURL
+	if appKey != "" {
+		url = fmt.Sprintf(keyFeedURL, appKey)
+	}
+
+	b.feed = feed.Feed{
+		Source:   fetch.Source{Name: Source, URL: url},
+		Interval: feedInterval,
+		Update:   b.update,
+		Cache:    c,
+	}
+
+	return b
+}
+
+// Connect starts downloading and refreshing the list in the background.
+func (b *Blocker) Connect() error {
+	b.feed.Start(context.Background())
+	return nil
+}
+
+func (b *Blocker) IsAsync() bool {
+	return false
+}
+
+// Reload downloads the list again.
+func (b *Blocker) Reload() error {
+	ctx, cancel := context.WithTimeout(context.Background(), feedTimeout)
+	defer cancel()
+
+	return b.feed.Refresh(ctx)
+}
+
+// Status returns the freshness of the list.
+func (b *Blocker) Status() feed.Status {
+	return b.feed.Status()
+}
+
+// parse parses the CSV export of PhishTank and returns the rule of each host.
+func parse(r io.Reader) (map[string]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+
+	urlColumn, ok := columns["url"]
+	if !ok {
+		return nil, ErrBadFeed
+	}
+
+	idColumn, hasID := columns["phish_id"]
+	targetColumn, hasTarget := columns["target"]
+
+	hosts := map[string]string{}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if urlColumn >= len(record) {
+			continue
+		}
+
+		host := blocklist.URLHost(record[urlColumn])
+		if host == "" {
+			continue
+		}
+
+		// the first phish of each host is enough
+		if _, ok := hosts[host]; ok {
+			continue
+		}
+
+		var rule string
+		if hasID && idColumn < len(record) {
+			rule = record[idColumn]
+		}
+
+		if hasTarget && targetColumn < len(record) && record[targetColumn] != "" && record[targetColumn] != unknownTarget {
+			rule += " (" + record[targetColumn] + ")"
+		}
+
+		hosts[host] = rule
+	}
+
+	return hosts, nil
+}
+
+func (b *Blocker) update(data []byte) (int, error) {
+	hosts, err := parse(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+
+	b.lock.Lock()
+	b.hosts = hosts
+	b.lock.Unlock()
+
+	return len(hosts), nil
+}
+
+func (b *Blocker) IsBad(_ context.Context, msg *queue.DomainAccessMessage) *verdict.Verdict {
+	b.lock.RLock()
+	rule, ok := b.hosts[msg.Domain]
+	b.lock.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	return &verdict.Verdict{Source: Source, Category: verdict.CategoryPhishing, Rule: rule, Confidence: 1}
+}
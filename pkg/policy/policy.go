@@ -0,0 +1,223 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package policy decides whether or not to block a domain, using the verdicts
+// of multiple blockers.
+package policy
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+const (
+	// DefaultThreshold is the score that causes a domain to be blocked, if no
+	// other threshold is specified: by default, any blocker can block a
+	// domain.
+	DefaultThreshold = 1
+
+	defaultWeight = 1
+)
+
+// The environment variables read by ParseEnvironment.
+const (
+	ThresholdEnvironmentVariable = "BLOCK_THRESHOLD"
+	WeightsEnvironmentVariable   = "BLOCKER_WEIGHTS"
+	MonitorEnvironmentVariable   = "MONITOR_SOURCES"
+	DryRunEnvironmentVariable    = "DRY_RUN"
+)
+
+// Policy assigns a weight to each source of verdicts, and blocks domains when
+// the weighted sum of their verdicts' confidence reaches a threshold.
+type Policy struct {
+	Threshold float64
+
+	// Weights maps sources to weights; sources that are not listed have a
+	// weight of 1.
+	Weights map[string]float64
+
+	// Monitor is a set of sources in dry-run mode: their verdicts are
+	// recorded but never cause a block.
+	Monitor map[string]bool
+
+	// DryRun puts all sources in dry-run mode.
+	DryRun bool
+}
+
+// Decision is the result of applying a policy to verdicts.
+type Decision struct {
+	Block bool
+
+	// WouldBlock is set when the domain is not blocked, but would have been
+	// blocked if no source was in dry-run mode.
+	WouldBlock bool
+
+	Score float64
+
+	// Verdicts are the verdicts that contributed to the score, from the
+	// strongest to the weakest.
+	Verdicts []*verdict.Verdict
+
+	// Monitored are verdicts from monitored sources.
+	Monitored []*verdict.Verdict
+}
+
+// New creates a policy that blocks domains when any blocker says so.
+func New() *Policy {
+	return &Policy{
+		Threshold: DefaultThreshold,
+		Weights:   map[string]float64{},
+		Monitor:   map[string]bool{},
+	}
+}
+
+// Parse creates a policy from a textual threshold, a comma-separated list of
+// source=weight pairs and a comma-separated list of monitored sources. Empty
+// strings mean the default value.
+func Parse(threshold, weights, monitor string) (*Policy, error) {
+	p := New()
+
+	if threshold != "" {
+		t, err := strconv.ParseFloat(threshold, 64)
+		if err != nil || t <= 0 {
+			return nil, fmt.Errorf("bad threshold: %s", threshold)
+		}
+
+		p.Threshold = t
+	}
+
+	for _, pair := range splitList(weights) {
+		i := strings.IndexByte(pair, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("bad weight: %s", pair)
+		}
+
+		w, err := strconv.ParseFloat(pair[i+1:], 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("bad weight: %s", pair)
+		}
+
+		p.Weights[pair[:i]] = w
+	}
+
+	for _, source := range splitList(monitor) {
+		p.Monitor[source] = true
+	}
+
+	return p, nil
+}
+
+// ParseEnvironment creates a policy from environment variables, so all
+// containers that block domains apply the same policy.
+func ParseEnvironment() (*Policy, error) {
+	p, err := Parse(os.Getenv(ThresholdEnvironmentVariable), os.Getenv(WeightsEnvironmentVariable), os.Getenv(MonitorEnvironmentVariable))
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun := os.Getenv(DryRunEnvironmentVariable); dryRun != "" {
+		if p.DryRun, err = strconv.ParseBool(dryRun); err != nil {
+			return nil, fmt.Errorf("bad dry-run mode: %s", dryRun)
+		}
+	}
+
+	return p, nil
+}
+
+func splitList(list string) []string {
+	var items []string
+
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func (p *Policy) weight(source string) float64 {
+	if w, ok := p.Weights[source]; ok {
+		return w
+	}
+
+	return defaultWeight
+}
+
+func (p *Policy) score(v *verdict.Verdict) float64 {
+	return p.weight(v.Source) * v.Confidence
+}
+
+func (p *Policy) sort(verdicts []*verdict.Verdict) {
+	sort.SliceStable(verdicts, func(i, j int) bool {
+		return p.score(verdicts[i]) > p.score(verdicts[j])
+	})
+}
+
+// Decide applies the policy to verdicts; nil verdicts are ignored.
+func (p *Policy) Decide(verdicts []*verdict.Verdict) *Decision {
+	var d Decision
+	var monitoredScore float64
+
+	for _, v := range verdicts {
+		if v == nil {
+			continue
+		}
+
+		score := p.score(v)
+
+		if p.Monitor[v.Source] {
+			d.Monitored = append(d.Monitored, v)
+			if score > 0 {
+				monitoredScore += score
+			}
+			continue
+		}
+
+		if score > 0 {
+			d.Score += score
+			d.Verdicts = append(d.Verdicts, v)
+		}
+	}
+
+	p.sort(d.Verdicts)
+	p.sort(d.Monitored)
+
+	d.Block = d.Score > 0 && d.Score >= p.Threshold
+
+	fullScore := d.Score + monitoredScore
+	wouldBlock := fullScore > 0 && fullScore >= p.Threshold
+
+	if p.DryRun {
+		d.WouldBlock = wouldBlock
+		d.Block = false
+	} else {
+		d.WouldBlock = wouldBlock && !d.Block
+	}
+
+	return &d
+}
@@ -0,0 +1,156 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package policy
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+var (
+	hostsVerdict   = &verdict.Verdict{Source: "hosts", Category: verdict.CategoryAds, Confidence: 1}
+	urlhausVerdict = &verdict.Verdict{Source: "urlhaus", Category: verdict.CategoryMalware, Confidence: 1}
+	noisyVerdict   = &verdict.Verdict{Source: "noisy", Confidence: 0.8}
+)
+
+func ExamplePolicy_Decide() {
+	p, err := Parse("1.2", "noisy=0.5,urlhaus=2", "")
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(p.Decide([]*verdict.Verdict{noisyVerdict}).Block)
+	fmt.Println(p.Decide([]*verdict.Verdict{noisyVerdict, hostsVerdict}).Block)
+	fmt.Println(p.Decide([]*verdict.Verdict{urlhausVerdict, nil}).Block)
+
+	// Output:
+	// false
+	// true
+	// true
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	p := New()
+
+	if p.Decide(nil).Block {
+		t.Error()
+	}
+
+	if p.Decide([]*verdict.Verdict{nil, nil}).Block {
+		t.Error()
+	}
+
+	if d := p.Decide([]*verdict.Verdict{nil, hostsVerdict}); !d.Block || d.Score != 1 || len(d.Verdicts) != 1 || d.Verdicts[0] != hostsVerdict {
+		t.Error(d)
+	}
+}
+
+func TestDecideOrder(t *testing.T) {
+	p, _ := Parse("", "urlhaus=2", "")
+
+	d := p.Decide([]*verdict.Verdict{noisyVerdict, hostsVerdict, urlhausVerdict})
+	if !d.Block || d.Score != 3.8 || len(d.Verdicts) != 3 || d.Verdicts[0] != urlhausVerdict || d.Verdicts[1] != hostsVerdict || d.Verdicts[2] != noisyVerdict {
+		t.Error(d)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	p, _ := Parse("", "", "hosts, noisy")
+
+	d := p.Decide([]*verdict.Verdict{noisyVerdict, hostsVerdict})
+	if d.Block || !d.WouldBlock || d.Score != 0 || len(d.Verdicts) != 0 || len(d.Monitored) != 2 || d.Monitored[0] != hostsVerdict {
+		t.Error(d)
+	}
+
+	if d := p.Decide([]*verdict.Verdict{noisyVerdict}); d.Block || d.WouldBlock {
+		t.Error(d)
+	}
+
+	if d := p.Decide([]*verdict.Verdict{hostsVerdict, urlhausVerdict}); !d.Block || d.WouldBlock || len(d.Monitored) != 1 {
+		t.Error(d)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	p := New()
+	p.DryRun = true
+
+	if d := p.Decide([]*verdict.Verdict{hostsVerdict}); d.Block || !d.WouldBlock || len(d.Verdicts) != 1 {
+		t.Error(d)
+	}
+
+	if d := p.Decide([]*verdict.Verdict{nil}); d.Block || d.WouldBlock {
+		t.Error(d)
+	}
+}
+
+func TestZeroWeight(t *testing.T) {
+	p, _ := Parse("", "hosts=0", "")
+
+	if d := p.Decide([]*verdict.Verdict{hostsVerdict}); d.Block || len(d.Verdicts) != 0 {
+		t.Error(d)
+	}
+}
+
+func TestParseEnvironment(t *testing.T) {
+	os.Setenv(ThresholdEnvironmentVariable, "2")
+	os.Setenv(MonitorEnvironmentVariable, "noisy")
+	os.Setenv(DryRunEnvironmentVariable, "true")
+	defer os.Unsetenv(ThresholdEnvironmentVariable)
+	defer os.Unsetenv(MonitorEnvironmentVariable)
+	defer os.Unsetenv(DryRunEnvironmentVariable)
+
+	p, err := ParseEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Threshold != 2 || !p.Monitor["noisy"] || !p.DryRun {
+		t.Error(p)
+	}
+
+	os.Setenv(DryRunEnvironmentVariable, "maybe")
+
+	if _, err := ParseEnvironment(); err == nil {
+		t.Error("bad dry-run mode was accepted")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, args := range [][3]string{
+		{"x", "", ""},
+		{"0", "", ""},
+		{"-1", "", ""},
+		{"", "hosts", ""},
+		{"", "=1", ""},
+		{"", "hosts=x", ""},
+		{"", "hosts=-1", ""},
+	} {
+		if _, err := Parse(args[0], args[1], args[2]); err == nil {
+			t.Error(args)
+		}
+	}
+}
@@ -22,9 +22,46 @@
 
 package queue
 
-import "golang.org/x/net/dns/dnsmessage"
+import (
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// EventsChannel is the name of the channel used to broadcast events to
+	// all workers.
+	EventsChannel = "events"
+
+	// RefreshEvent asks workers to reload their blocklists.
+	RefreshEvent = "refresh"
+
+	// AlertsChannel is the name of the channel used to broadcast alerts
+	// about suspicious activity.
+	AlertsChannel = "alerts"
+)
 
 type DomainAccessMessage struct {
 	Domain      string          `json:"domain"`
 	RequestType dnsmessage.Type `json:"request_type"`
+
+	// Client is an opaque identifier of the client, which does not reveal
+	// its address.
+	Client string `json:"client,omitempty"`
+
+	// Time is the time of the query, or zero in messages queued by older
+	// versions.
+	Time time.Time `json:"time"`
+
+	// Addresses are the addresses in the A and AAAA answer records of the
+	// response.
+	Addresses []string `json:"addresses,omitempty"`
+
+	// Nameservers are the NS records in the authority section of the
+	// response, keyed by zone.
+	Nameservers map[string][]string `json:"nameservers,omitempty"`
+
+	// Retries is the number of times the message was retried, because
+	// checks were skipped.
+	Retries int `json:"retries,omitempty"`
 }
@@ -24,11 +24,24 @@
 package queue
 
 import (
+	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"gopkg.in/redis.v5"
 )
 
+const (
+	messagesKey = "messages"
+
+	// a sorted set of messages, scored by the time they should be pushed
+	delayedKey = "messages:delayed"
+
+	minResubscribeDelay = time.Second
+	maxResubscribeDelay = time.Minute
+)
+
 // Queue is a task queue.
 type Queue struct {
 	redisClient *redis.Client
@@ -47,16 +60,102 @@ func OpenQueue() (*Queue, error) {
 
 // Push pushes a new task to the queue.
 func (q *Queue) Push(msg string) error {
-	_, err := q.redisClient.RPush("messages", msg).Result()
+	_, err := q.redisClient.RPush(messagesKey, msg).Result()
 	return err
 }
 
+// PushLater pushes a task to the queue at a later time, once PushDue is called
+// after that time.
+func (q *Queue) PushLater(msg string, at time.Time) error {
+	_, err := q.redisClient.ZAdd(delayedKey, redis.Z{Score: float64(at.Unix()), Member: msg}).Result()
+	return err
+}
+
+// PushDue pushes delayed tasks that are due and returns their number. Each
+// task is pushed once, even if multiple workers call PushDue.
+func (q *Queue) PushDue(now time.Time) (int, error) {
+	due, err := q.redisClient.ZRangeByScore(delayedKey, redis.ZRangeBy{Min: "-inf", Max: strconv.FormatInt(now.Unix(), 10)}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, msg := range due {
+		// only the worker that removes the task pushes it
+		if removed, err := q.redisClient.ZRem(delayedKey, msg).Result(); err != nil {
+			return n, err
+		} else if removed == 0 {
+			continue
+		}
+
+		if err := q.Push(msg); err != nil {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, nil
+}
+
 // Pop pops a task and blocks if the queue is empty.
 func (q *Queue) Pop() (string, error) {
-	popped, err := q.redisClient.BLPop(0, "messages").Result()
+	popped, err := q.redisClient.BLPop(0, messagesKey).Result()
 	if err != nil {
 		return "", err
 	}
 
 	return popped[1], nil
 }
+
+// Len returns the number of tasks in the queue.
+func (q *Queue) Len() (int64, error) {
+	return q.redisClient.LLen(messagesKey).Result()
+}
+
+// Publish broadcasts an event to all subscribers of a channel.
+func (q *Queue) Publish(channel string, event string) error {
+	_, err := q.redisClient.Publish(channel, event).Result()
+	return err
+}
+
+// Subscribe returns a channel of events broadcast to a channel. When receiving
+// fails, Subscribe subscribes again, with a growing delay between attempts.
+func (q *Queue) Subscribe(channel string) (<-chan string, error) {
+	pubsub, err := q.redisClient.Subscribe(channel)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan string)
+	go q.receive(channel, pubsub, events)
+
+	return events, nil
+}
+
+func (q *Queue) receive(channel string, pubsub *redis.PubSub, events chan<- string) {
+	for {
+		msg, err := pubsub.ReceiveMessage()
+		if err == nil {
+			events <- msg.Payload
+			continue
+		}
+
+		log.Printf("Failed to receive events from %s: %v", channel, err)
+		pubsub.Close()
+
+		for delay := minResubscribeDelay; ; {
+			time.Sleep(delay)
+
+			if pubsub, err = q.redisClient.Subscribe(channel); err == nil {
+				break
+			}
+
+			log.Printf("Failed to subscribe to %s: %v", channel, err)
+
+			if delay *= 2; delay > maxResubscribeDelay {
+				delay = maxResubscribeDelay
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package rules
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+// Source is the source name in verdicts of RuleBlocker.
+const Source = "rules"
+
+// RuleBlocker blocks domains that match rules loaded from a file.
+type RuleBlocker struct {
+	path string
+
+	lock    sync.RWMutex
+	matcher *Matcher
+}
+
+// OpenRuleBlocker loads rules from a file.
+func OpenRuleBlocker(path string) (*RuleBlocker, error) {
+	rb := &RuleBlocker{path: path}
+
+	if err := rb.Reload(); err != nil {
+		return nil, err
+	}
+
+	return rb, nil
+}
+
+func (rb *RuleBlocker) Connect() error {
+	return nil
+}
+
+func (rb *RuleBlocker) IsAsync() bool {
+	return false
+}
+
+// Reload re-reads the rules.
+func (rb *RuleBlocker) Reload() error {
+	f, err := os.Open(rb.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	matcher, err := Load(f)
+	if err != nil {
+		return err
+	}
+
+	rb.lock.Lock()
+	rb.matcher = matcher
+	rb.lock.Unlock()
+
+	return nil
+}
+
+// Check returns a verdict if a normalized domain matches a rule, or nil.
+func (rb *RuleBlocker) Check(domain string) *verdict.Verdict {
+	rb.lock.RLock()
+	rule := rb.matcher.Match(domain)
+	rb.lock.RUnlock()
+
+	if rule == nil {
+		return nil
+	}
+
+	return &verdict.Verdict{Source: Source, Category: rule.Category, Rule: rule.Pattern, Confidence: 1}
+}
+
+func (rb *RuleBlocker) IsBad(_ context.Context, msg *queue.DomainAccessMessage) *verdict.Verdict {
+	return rb.Check(msg.Domain)
+}
@@ -0,0 +1,271 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package rules implements blocking of domains by TLD, glob and regular
+// expression rules.
+package rules
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/dimkr/dohli/pkg/dns"
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+// Kind is the type of a rule.
+type Kind string
+
+const (
+	// KindTLD matches all domains under a top-level domain, e.g. ".zip"
+	KindTLD Kind = "tld"
+
+	// KindGlob matches domains using "*" (any number of characters) and
+	// "?" (one character), e.g. "ad*.example.com"
+	KindGlob Kind = "glob"
+
+	// KindRegexp matches domains using a RE2 regular expression, e.g.
+	// "/^ad[sv]?\d*\./"
+	KindRegexp Kind = "regexp"
+)
+
+// Rule is a pattern, which matches domains associated with a category.
+type Rule struct {
+	Pattern  string           `json:"pattern"`
+	Kind     Kind             `json:"kind"`
+	Category verdict.Category `json:"category,omitempty"`
+}
+
+// ParseRule parses a rule: a TLD prefixed by ".", a regular expression
+// between slashes or a glob pattern.
+func ParseRule(pattern string, category verdict.Category) (*Rule, error) {
+	switch {
+	case len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/"):
+		if _, err := regexp.Compile(pattern[1 : len(pattern)-1]); err != nil {
+			return nil, err
+		}
+
+		return &Rule{Pattern: pattern, Kind: KindRegexp, Category: category}, nil
+
+	case strings.HasPrefix(pattern, ".") && !strings.Contains(pattern[1:], "."):
+		tld, err := dns.NormalizeName(pattern[1:])
+		if err != nil {
+			return nil, fmt.Errorf("bad TLD: %s", pattern)
+		}
+
+		return &Rule{Pattern: "." + tld, Kind: KindTLD, Category: category}, nil
+
+	case pattern != "" && !strings.ContainsAny(pattern, " \t/"):
+		glob, err := normalizeGlob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("bad glob: %s", pattern)
+		}
+
+		return &Rule{Pattern: glob, Kind: KindGlob, Category: category}, nil
+	}
+
+	return nil, fmt.Errorf("bad rule: %s", pattern)
+}
+
+// normalizeGlob converts a glob pattern to the form of the names it matches:
+// labels without wildcards are normalized like domain names, so Unicode
+// labels are encoded using punycode, while labels with wildcards must be
+// ASCII.
+func normalizeGlob(pattern string) (string, error) {
+	labels := strings.Split(strings.TrimSuffix(pattern, "."), ".")
+
+	for i, label := range labels {
+		if !strings.ContainsAny(label, "*?") {
+			normalized, err := dns.NormalizeName(label)
+			if err != nil {
+				return "", err
+			}
+
+			labels[i] = normalized
+			continue
+		}
+
+		for j := 0; j < len(label); j++ {
+			if label[j] >= 0x80 {
+				return "", dns.ErrInvalidName
+			}
+		}
+
+		labels[i] = strings.ToLower(label)
+	}
+
+	return strings.Join(labels, "."), nil
+}
+
+// expression returns the regular expression equivalent to a rule.
+func (r *Rule) expression() string {
+	switch r.Kind {
+	case KindRegexp:
+		return r.Pattern[1 : len(r.Pattern)-1]
+
+	case KindGlob:
+		var b strings.Builder
+		b.WriteByte('^')
+		for _, c := range r.Pattern {
+			switch c {
+			case '*':
+				b.WriteString(".*")
+
+			case '?':
+				b.WriteByte('.')
+
+			default:
+				b.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		}
+		b.WriteByte('$')
+		return b.String()
+	}
+
+	return ""
+}
+
+// Matcher matches domains against a compiled list of rules.
+type Matcher struct {
+	rules []Rule
+	tlds  map[string]int
+
+	// all glob and regexp rules, as alternatives in one regular expression
+	re *regexp.Regexp
+	// the index of the rule matched by each subexpression, or -1
+	groups []int
+}
+
+// Compile compiles a list of rules into a matcher.
+func Compile(rules []Rule) (*Matcher, error) {
+	m := Matcher{rules: rules, tlds: map[string]int{}}
+
+	var alternatives []string
+	groups := []int{-1}
+
+	for i := range rules {
+		if rules[i].Kind == KindTLD {
+			if _, ok := m.tlds[rules[i].Pattern[1:]]; !ok {
+				m.tlds[rules[i].Pattern[1:]] = i
+			}
+			continue
+		}
+
+		expression := rules[i].expression()
+		re, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, err
+		}
+
+		// the group of each rule is followed by the groups inside it
+		groups = append(groups, i)
+		for j := 0; j < re.NumSubexp(); j++ {
+			groups = append(groups, -1)
+		}
+
+		alternatives = append(alternatives, "("+expression+")")
+	}
+
+	if len(alternatives) == 0 {
+		return &m, nil
+	}
+
+	re, err := regexp.Compile(strings.Join(alternatives, "|"))
+	if err != nil {
+		return nil, err
+	}
+
+	m.re = re
+	m.groups = groups
+	return &m, nil
+}
+
+// Match returns the rule that matches a normalized domain, or nil.
+func (m *Matcher) Match(domain string) *Rule {
+	if i := strings.LastIndexByte(domain, '.'); i != -1 {
+		if rule, ok := m.tlds[domain[i+1:]]; ok {
+			return &m.rules[rule]
+		}
+	}
+
+	if m.re == nil {
+		return nil
+	}
+
+	match := m.re.FindStringSubmatchIndex(domain)
+	if match == nil {
+		return nil
+	}
+
+	for group := 1; group < len(m.groups); group++ {
+		if match[group*2] != -1 && m.groups[group] != -1 {
+			return &m.rules[m.groups[group]]
+		}
+	}
+
+	return nil
+}
+
+// Len returns the number of rules in a matcher.
+func (m *Matcher) Len() int {
+	return len(m.rules)
+}
+
+// Load parses a list of rules, one per line, each optionally followed by a
+// category. Empty lines and lines starting with # are ignored.
+func Load(r io.Reader) (*Matcher, error) {
+	var rules []Rule
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: too many fields", n)
+		}
+
+		var category verdict.Category
+		if len(fields) == 2 {
+			category = verdict.Category(fields[1])
+		}
+
+		rule, err := ParseRule(fields[0], category)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+
+		rules = append(rules, *rule)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return Compile(rules)
+}
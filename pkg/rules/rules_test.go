@@ -0,0 +1,143 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package rules
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dimkr/dohli/pkg/queue"
+)
+
+const testRules = `# comment
+.zip malware
+.MOV malware
+/^ad[sv]?\d*\./ ads
+/(^|\.)[0-9a-f]{16,}\./ tracking
+pixel-?.example.com tracking
+*.doubleclick.net ads
+*.Пример.рф phishing
+`
+
+func ExampleMatcher_Match() {
+	m, err := Load(strings.NewReader(testRules))
+	if err != nil {
+		panic(err)
+	}
+
+	for _, domain := range []string{
+		"invoice.zip",
+		"movie.mov",
+		"ads.example.com",
+		"adv2.example.org",
+		"a0b1c2d3e4f5a6b7.cdn.example.com",
+		"pixel-1.example.com",
+		"stats.g.doubleclick.net",
+		"doubleclick.net",
+		"login.xn--e1afmkfd.xn--p1ai",
+		"example.com",
+	} {
+		if rule := m.Match(domain); rule != nil {
+			fmt.Println(domain, rule.Kind, rule.Pattern, rule.Category)
+		} else {
+			fmt.Println(domain, "no match")
+		}
+	}
+
+	// Output:
+	// invoice.zip tld .zip malware
+	// movie.mov tld .mov malware
+	// ads.example.com regexp /^ad[sv]?\d*\./ ads
+	// adv2.example.org regexp /^ad[sv]?\d*\./ ads
+	// a0b1c2d3e4f5a6b7.cdn.example.com regexp /(^|\.)[0-9a-f]{16,}\./ tracking
+	// pixel-1.example.com glob pixel-?.example.com tracking
+	// stats.g.doubleclick.net glob *.doubleclick.net ads
+	// doubleclick.net no match
+	// login.xn--e1afmkfd.xn--p1ai glob *.xn--e1afmkfd.xn--p1ai phishing
+	// example.com no match
+}
+
+func TestNestedGroups(t *testing.T) {
+	m, err := Load(strings.NewReader("/^(a)(b)(c)\\./\n/^((x)|(y))z\\./\n/^(?P<rule0>q)\\./\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for domain, pattern := range map[string]string{
+		"abc.example.com": `/^(a)(b)(c)\./`,
+		"yz.example.com":  `/^((x)|(y))z\./`,
+		"q.example.com":   `/^(?P<rule0>q)\./`,
+	} {
+		if rule := m.Match(domain); rule == nil || rule.Pattern != pattern {
+			t.Error(domain, rule)
+		}
+	}
+}
+
+func TestBadRules(t *testing.T) {
+	for _, rules := range []string{"/(/\n", ".\n", "a b c\n", "//\n", "a/b\n", "a..b\n", "*.пр?мер.рф\n"} {
+		if _, err := Load(strings.NewReader(rules)); err == nil {
+			t.Error(rules)
+		}
+	}
+}
+
+func TestRuleBlocker(t *testing.T) {
+	f, err := ioutil.TempFile("", "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString(".zip malware\n")
+	f.Close()
+
+	rb, err := OpenRuleBlocker(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := rb.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "invoice.zip"}); v == nil || v.String() != "rules (malware): .zip" {
+		t.Error(v)
+	}
+
+	if err := ioutil.WriteFile(f.Name(), []byte(".mov\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rb.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if rb.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "invoice.zip"}) != nil {
+		t.Error()
+	}
+
+	if rb.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "movie.mov"}) == nil {
+		t.Error()
+	}
+}
@@ -0,0 +1,213 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package safebrowsing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	clientID      = "dohli"
+	clientVersion = "1.0"
+
+	// API responses are truncated to this size
+	maxResponseSize = 64 * 1024 * 1024
+)
+
+// StatusError is returned when the API responds with an unexpected HTTP
+// status.
+type StatusError struct {
+	StatusCode int
+}
+
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("bad status: %d", err.StatusCode)
+}
+
+type clientInfo struct {
+	ClientID      string `json:"clientId"`
+	ClientVersion string `json:"clientVersion"`
+}
+
+// ThreatList identifies a threat list.
+type ThreatList struct {
+	ThreatType      string `json:"threatType"`
+	PlatformType    string `json:"platformType"`
+	ThreatEntryType string `json:"threatEntryType"`
+}
+
+func (list ThreatList) String() string {
+	return list.ThreatType + "/" + list.PlatformType + "/" + list.ThreatEntryType
+}
+
+type constraints struct {
+	SupportedCompressions []string `json:"supportedCompressions"`
+}
+
+type listUpdateRequest struct {
+	ThreatList
+	State       []byte      `json:"state,omitempty"`
+	Constraints constraints `json:"constraints"`
+}
+
+type fetchRequest struct {
+	Client             clientInfo          `json:"client"`
+	ListUpdateRequests []listUpdateRequest `json:"listUpdateRequests"`
+}
+
+type rawHashes struct {
+	PrefixSize int    `json:"prefixSize"`
+	RawHashes  []byte `json:"rawHashes"`
+}
+
+type rawIndices struct {
+	Indices []int `json:"indices"`
+}
+
+type threatEntrySet struct {
+	CompressionType string      `json:"compressionType"`
+	RawHashes       *rawHashes  `json:"rawHashes,omitempty"`
+	RawIndices      *rawIndices `json:"rawIndices,omitempty"`
+}
+
+type checksum struct {
+	SHA256 []byte `json:"sha256"`
+}
+
+const fullUpdate = "FULL_UPDATE"
+
+type listUpdateResponse struct {
+	ThreatList
+	ResponseType   string           `json:"responseType"`
+	Additions      []threatEntrySet `json:"additions,omitempty"`
+	Removals       []threatEntrySet `json:"removals,omitempty"`
+	NewClientState []byte           `json:"newClientState"`
+	Checksum       checksum         `json:"checksum"`
+}
+
+type fetchResponse struct {
+	ListUpdateResponses []listUpdateResponse `json:"listUpdateResponses"`
+	MinimumWaitDuration duration             `json:"minimumWaitDuration,omitempty"`
+}
+
+type threatEntry struct {
+	Hash []byte `json:"hash"`
+}
+
+type threatInfo struct {
+	ThreatTypes      []string      `json:"threatTypes"`
+	PlatformTypes    []string      `json:"platformTypes"`
+	ThreatEntryTypes []string      `json:"threatEntryTypes"`
+	ThreatEntries    []threatEntry `json:"threatEntries"`
+}
+
+type findRequest struct {
+	Client       clientInfo `json:"client"`
+	ClientStates [][]byte   `json:"clientStates"`
+	ThreatInfo   threatInfo `json:"threatInfo"`
+}
+
+type threatMatch struct {
+	ThreatList
+	Threat        threatEntry `json:"threat"`
+	CacheDuration duration    `json:"cacheDuration"`
+}
+
+type findResponse struct {
+	Matches               []threatMatch `json:"matches,omitempty"`
+	MinimumWaitDuration   duration      `json:"minimumWaitDuration,omitempty"`
+	NegativeCacheDuration duration      `json:"negativeCacheDuration,omitempty"`
+}
+
+// duration is a duration in the JSON encoding of protocol buffers, like
+// "300.5s".
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%gs", time.Duration(d).Seconds()))
+}
+
+func (d *duration) UnmarshalJSON(j []byte) error {
+	var s string
+	if err := json.Unmarshal(j, &s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = duration(parsed)
+	return nil
+}
+
+func (b *Blocker) baseURL() string {
+	if b.BaseURL != "" {
+		return strings.TrimSuffix(b.BaseURL, "/")
+	}
+
+	return DefaultBaseURL
+}
+
+func (b *Blocker) httpClient() *http.Client {
+	if b.HTTPClient != nil {
+		return b.HTTPClient
+	}
+
+	return http.DefaultClient
+}
+
+// call sends a request to an API method and parses the response.
+func (b *Blocker) call(ctx context.Context, method string, request, response interface{}) error {
+	j, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, b.baseURL()+"/v4/"+method+"?key="+url.QueryEscape(b.APIKey), bytes.NewReader(j))
+	if err != nil {
+		return err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+
+	httpResponse, err := b.httpClient().Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return &StatusError{StatusCode: httpResponse.StatusCode}
+	}
+
+	return json.NewDecoder(io.LimitReader(httpResponse.Body, maxResponseSize)).Decode(response)
+}
@@ -0,0 +1,214 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package safebrowsing
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"sort"
+)
+
+var errBadPrefixSize = errors.New("bad prefix size")
+
+var errBadIndex = errors.New("bad removal index")
+
+// chunks is a sorted array of fixed-size hash prefixes.
+type chunks struct {
+	size int
+	data []byte
+}
+
+func (c *chunks) Len() int {
+	return len(c.data) / c.size
+}
+
+func (c *chunks) at(i int) []byte {
+	return c.data[i*c.size : (i+1)*c.size]
+}
+
+func (c *chunks) Less(i, j int) bool {
+	return bytes.Compare(c.at(i), c.at(j)) < 0
+}
+
+func (c *chunks) Swap(i, j int) {
+	var tmp [sha256.Size]byte
+	copy(tmp[:], c.at(i))
+	copy(c.at(i), c.at(j))
+	copy(c.at(j), tmp[:c.size])
+}
+
+func (c *chunks) contains(prefix []byte) bool {
+	n := c.Len()
+	i := sort.Search(n, func(i int) bool { return bytes.Compare(c.at(i), prefix) >= 0 })
+	return i < n && bytes.Equal(c.at(i), prefix)
+}
+
+// prefixSet is the local copy of a threat list: hash prefixes of 4 to 32
+// bytes, stored in one array per prefix size, so millions of prefixes don't
+// cost millions of allocations.
+type prefixSet struct {
+	bySize map[int]*chunks
+}
+
+func newPrefixSet() *prefixSet {
+	return &prefixSet{bySize: map[int]*chunks{}}
+}
+
+// each calls f for each prefix, in the lexicographic order used by removal
+// indices and checksums.
+func (s *prefixSet) each(f func([]byte)) {
+	lists := make([]*chunks, 0, len(s.bySize))
+	positions := make([]int, 0, len(s.bySize))
+	for _, c := range s.bySize {
+		lists = append(lists, c)
+		positions = append(positions, 0)
+	}
+
+	for {
+		next := -1
+		for i, c := range lists {
+			if positions[i] == c.Len() {
+				continue
+			}
+
+			if next == -1 || bytes.Compare(c.at(positions[i]), lists[next].at(positions[next])) < 0 {
+				next = i
+			}
+		}
+
+		if next == -1 {
+			return
+		}
+
+		f(lists[next].at(positions[next]))
+		positions[next]++
+	}
+}
+
+// Len returns the number of prefixes.
+func (s *prefixSet) Len() int {
+	n := 0
+	for _, c := range s.bySize {
+		n += c.Len()
+	}
+
+	return n
+}
+
+// contains checks whether or not a full hash starts with any prefix.
+func (s *prefixSet) contains(hash []byte) []byte {
+	for size, c := range s.bySize {
+		if c.contains(hash[:size]) {
+			return hash[:size]
+		}
+	}
+
+	return nil
+}
+
+// remove removes the prefixes at sorted indices.
+func (s *prefixSet) remove(indices []int) error {
+	if len(indices) == 0 {
+		return nil
+	}
+
+	sort.Ints(indices)
+	if indices[0] < 0 || indices[len(indices)-1] >= s.Len() {
+		return errBadIndex
+	}
+
+	kept := newPrefixSet()
+	i := 0
+	j := 0
+
+	s.each(func(prefix []byte) {
+		if j < len(indices) && indices[j] == i {
+			for j < len(indices) && indices[j] == i {
+				j++
+			}
+		} else {
+			kept.append(prefix)
+		}
+
+		i++
+	})
+
+	s.bySize = kept.bySize
+	return nil
+}
+
+// append appends a prefix, which must be greater than all prefixes of the
+// same size.
+func (s *prefixSet) append(prefix []byte) {
+	c, ok := s.bySize[len(prefix)]
+	if !ok {
+		c = &chunks{size: len(prefix)}
+		s.bySize[len(prefix)] = c
+	}
+
+	c.data = append(c.data, prefix...)
+}
+
+// add adds concatenated prefixes of the same size.
+func (s *prefixSet) add(size int, raw []byte) error {
+	if size < 4 || size > sha256.Size || len(raw)%size != 0 {
+		return errBadPrefixSize
+	}
+
+	added := &chunks{size: size, data: append([]byte{}, raw...)}
+	sort.Sort(added)
+
+	existing, ok := s.bySize[size]
+	if !ok {
+		s.bySize[size] = added
+		return nil
+	}
+
+	merged := &chunks{size: size, data: make([]byte, 0, len(existing.data)+len(added.data))}
+	i := 0
+	j := 0
+
+	for i < existing.Len() || j < added.Len() {
+		if j == added.Len() || (i < existing.Len() && bytes.Compare(existing.at(i), added.at(j)) <= 0) {
+			merged.data = append(merged.data, existing.at(i)...)
+			i++
+		} else {
+			merged.data = append(merged.data, added.at(j)...)
+			j++
+		}
+	}
+
+	s.bySize[size] = merged
+	return nil
+}
+
+// checksum returns the SHA-256 hash of all prefixes, in lexicographic order.
+func (s *prefixSet) checksum() []byte {
+	hash := sha256.New()
+	s.each(func(prefix []byte) {
+		hash.Write(prefix)
+	})
+
+	return hash.Sum(nil)
+}
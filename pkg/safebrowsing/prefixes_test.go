@@ -0,0 +1,102 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package safebrowsing
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func prefixesOf(s *prefixSet) []string {
+	var prefixes []string
+	s.each(func(prefix []byte) {
+		prefixes = append(prefixes, string(prefix))
+	})
+
+	return prefixes
+}
+
+func TestPrefixSet(t *testing.T) {
+	s := newPrefixSet()
+
+	if err := s.add(4, []byte("dddd"+"bbbb"+"ffff")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.add(5, []byte("ccccc"+"bbbbb")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.add(4, []byte("aaaa"+"eeee")); err != nil {
+		t.Fatal(err)
+	}
+
+	// prefixes of different sizes are ordered together
+	if prefixes := prefixesOf(s); len(prefixes) != 7 || prefixes[0] != "aaaa" || prefixes[1] != "bbbb" || prefixes[2] != "bbbbb" || prefixes[3] != "ccccc" || prefixes[6] != "ffff" {
+		t.Error(prefixes)
+	}
+
+	// bbbbb and eeee
+	if err := s.remove([]int{5, 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if prefixes := prefixesOf(s); len(prefixes) != 5 || prefixes[2] != "ccccc" || prefixes[4] != "ffff" {
+		t.Error(prefixes)
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte("aaaa" + "bbbb" + "ccccc" + "dddd" + "ffff"))
+	if !bytes.Equal(s.checksum(), hash.Sum(nil)) {
+		t.Error("bad checksum")
+	}
+
+	for hash, prefix := range map[string]string{
+		"ccccc" + "0000000000000000000000000aa": "ccccc",
+		"dddd" + "00000000000000000000000000aa": "dddd",
+		"bbbbb" + "0000000000000000000000000aa": "bbbb",
+		"cccc0" + "0000000000000000000000000aa": "",
+	} {
+		if matched := s.contains([]byte(hash)); string(matched) != prefix {
+			t.Error(hash, string(matched))
+		}
+	}
+}
+
+func TestPrefixSetBadUpdate(t *testing.T) {
+	s := newPrefixSet()
+
+	if err := s.add(4, []byte("aaaabb")); err != errBadPrefixSize {
+		t.Error(err)
+	}
+
+	if err := s.add(2, []byte("aabb")); err != errBadPrefixSize {
+		t.Error(err)
+	}
+
+	s.add(4, []byte("aaaa"))
+	if err := s.remove([]int{1}); err != errBadIndex {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,510 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package safebrowsing blocks domains listed by Google Safe Browsing.
+//
+// The threat lists are synced into a local database of hash prefixes, using
+// the Safe Browsing Update API. Domains are checked locally, and only the hash
+// prefixes of domains that match the local database are sent to Google.
+package safebrowsing
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/circuit"
+	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+// Source is the source name in verdicts of Blocker.
+const Source = "safebrowsing"
+
+// DefaultBaseURL is the default API URL.
+const DefaultBaseURL = "https://safebrowsing.googleapis.com"
+
+const (
+	updateInterval = 30 * time.Minute
+	updateTimeout  = 5 * time.Minute
+	lookupTimeout  = 5 * time.Second
+
+	// used if the API does not specify the caching duration of a lookup
+	defaultCacheDuration = 5 * time.Minute
+
+	// the number of host suffixes checked, in addition to the host
+	maxSuffixes = 4
+)
+
+// DefaultLists are the threat lists used by default.
+var DefaultLists = []ThreatList{
+	{ThreatType: "MALWARE", PlatformType: "ANY_PLATFORM", ThreatEntryType: "URL"},
+	{ThreatType: "SOCIAL_ENGINEERING", PlatformType: "ANY_PLATFORM", ThreatEntryType: "URL"},
+	{ThreatType: "UNWANTED_SOFTWARE", PlatformType: "ANY_PLATFORM", ThreatEntryType: "URL"},
+}
+
+// ErrChecksum is returned when a list does not match its checksum after an
+// update; the next update of this list is a full update.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// ErrTooEarly is returned when an update is requested before the minimum wait
+// duration specified by the API has passed.
+var ErrTooEarly = errors.New("too early")
+
+type localList struct {
+	state    []byte
+	prefixes *prefixSet
+}
+
+type cachedHash struct {
+	expires time.Time
+	lists   []ThreatList
+}
+
+// Blocker blocks domains listed by Safe Browsing.
+type Blocker struct {
+	APIKey string
+
+	// BaseURL is the API URL; the default is DefaultBaseURL
+	BaseURL string
+
+	// HTTPClient sends API requests; the default is http.DefaultClient
+	HTTPClient *http.Client
+
+	// Breaker protects the API and the worker from each other, during
+	// full hash lookups
+	Breaker *circuit.Breaker
+
+	lists []ThreatList
+
+	lock       sync.RWMutex
+	local      map[ThreatList]*localList
+	nextUpdate time.Time
+
+	cacheLock sync.Mutex
+
+	// matching full hashes and the time they expire
+	fullHashes map[string]cachedHash
+
+	// the time looked up prefixes expire, if they match no full hash
+	negative map[string]time.Time
+
+	now func() time.Time
+}
+
+// OpenBlocker returns a new Safe Browsing blocker, which syncs lists. The
+// state of the circuit breaker is stored in c.
+func OpenBlocker(c *cache.Cache, apiKey string, lists ...ThreatList) *Blocker {
+	if len(lists) == 0 {
+		lists = DefaultLists
+	}
+
+	b := &Blocker{
+		APIKey:     apiKey,
+		Breaker:    circuit.OpenBreaker(c, Source),
+		lists:      lists,
+		local:      map[ThreatList]*localList{},
+		fullHashes: map[string]cachedHash{},
+		negative:   map[string]time.Time{},
+		now:        time.Now,
+	}
+
+	for _, list := range lists {
+		b.local[list] = &localList{prefixes: newPrefixSet()}
+	}
+
+	return b
+}
+
+// Connect starts syncing the lists in the background, at once and then
+// periodically: a slow sync does not delay startup. Domains are not blocked
+// until the first sync succeeds.
+func (b *Blocker) Connect() error {
+	go b.run()
+	return nil
+}
+
+func (b *Blocker) IsAsync() bool {
+	return true
+}
+
+func (b *Blocker) run() {
+	if err := b.Reload(); err != nil {
+		log.Println("Failed to update Safe Browsing lists: ", err)
+	}
+
+	for {
+		wait := updateInterval
+
+		b.lock.RLock()
+		if minimum := b.nextUpdate.Sub(b.now()); minimum > wait {
+			wait = minimum
+		}
+		b.lock.RUnlock()
+
+		time.Sleep(wait)
+
+		if err := b.Reload(); err != nil {
+			log.Println("Failed to update Safe Browsing lists: ", err)
+		}
+	}
+}
+
+// Reload syncs the lists.
+func (b *Blocker) Reload() error {
+	ctx, cancel := context.WithTimeout(context.Background(), updateTimeout)
+	defer cancel()
+
+	return b.Update(ctx)
+}
+
+// Len returns the number of hash prefixes in the local database.
+func (b *Blocker) Len() int {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	n := 0
+	for _, list := range b.local {
+		n += list.prefixes.Len()
+	}
+
+	return n
+}
+
+// apply applies an update to a copy of a list.
+func apply(list *localList, update *listUpdateResponse) (*localList, error) {
+	prefixes := newPrefixSet()
+
+	// a partial update changes a copy of the list, so lookups can continue
+	if update.ResponseType != fullUpdate {
+		for size, c := range list.prefixes.bySize {
+			prefixes.bySize[size] = &chunks{size: size, data: append([]byte{}, c.data...)}
+		}
+	}
+
+	// removal indices refer to the list before additions
+	for _, removal := range update.Removals {
+		if removal.RawIndices != nil {
+			if err := prefixes.remove(removal.RawIndices.Indices); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, addition := range update.Additions {
+		if addition.RawHashes != nil {
+			if err := prefixes.add(addition.RawHashes.PrefixSize, addition.RawHashes.RawHashes); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &localList{state: update.NewClientState, prefixes: prefixes}, nil
+}
+
+// Update syncs the lists.
+func (b *Blocker) Update(ctx context.Context) error {
+	b.lock.RLock()
+	if b.now().Before(b.nextUpdate) {
+		b.lock.RUnlock()
+		return ErrTooEarly
+	}
+
+	request := fetchRequest{Client: clientInfo{ClientID: clientID, ClientVersion: clientVersion}}
+	for _, list := range b.lists {
+		request.ListUpdateRequests = append(request.ListUpdateRequests, listUpdateRequest{
+			ThreatList:  list,
+			State:       b.local[list].state,
+			Constraints: constraints{SupportedCompressions: []string{"RAW"}},
+		})
+	}
+	b.lock.RUnlock()
+
+	var response fetchResponse
+	if err := b.call(ctx, "threatListUpdates:fetch", &request, &response); err != nil {
+		return err
+	}
+
+	var updateErr error
+
+	for i := range response.ListUpdateResponses {
+		update := &response.ListUpdateResponses[i]
+
+		b.lock.RLock()
+		list, ok := b.local[update.ThreatList]
+		b.lock.RUnlock()
+		if !ok {
+			continue
+		}
+
+		updated, err := apply(list, update)
+		if err == nil && !bytes.Equal(updated.prefixes.checksum(), update.Checksum.SHA256) {
+			err = ErrChecksum
+		}
+
+		if err != nil {
+			log.Printf("Failed to update %s: %v", update.ThreatList, err)
+			updateErr = err
+
+			// the next update of this list replaces it
+			updated = &localList{prefixes: list.prefixes}
+		}
+
+		b.lock.Lock()
+		b.local[update.ThreatList] = updated
+		b.lock.Unlock()
+	}
+
+	b.lock.Lock()
+	b.nextUpdate = b.now().Add(time.Duration(response.MinimumWaitDuration))
+	b.lock.Unlock()
+
+	b.pruneCache()
+	return updateErr
+}
+
+// pruneCache removes expired lookup results.
+func (b *Blocker) pruneCache() {
+	now := b.now()
+
+	b.cacheLock.Lock()
+	defer b.cacheLock.Unlock()
+
+	for hash, cached := range b.fullHashes {
+		if now.After(cached.expires) {
+			delete(b.fullHashes, hash)
+		}
+	}
+
+	for prefix, expires := range b.negative {
+		if now.After(expires) {
+			delete(b.negative, prefix)
+		}
+	}
+}
+
+// expressions returns the Safe Browsing expressions of a host: the host and up
+// to 4 of its suffixes, excluding the TLD, with the root path.
+func expressions(host string) []string {
+	exprs := []string{host + "/"}
+	if net.ParseIP(host) != nil {
+		return exprs
+	}
+
+	labels := strings.Split(host, ".")
+
+	start := len(labels) - maxSuffixes - 1
+	if start < 1 {
+		start = 1
+	}
+
+	for i := start; i < len(labels)-1; i++ {
+		exprs = append(exprs, strings.Join(labels[i:], ".")+"/")
+	}
+
+	return exprs
+}
+
+type candidate struct {
+	expression string
+	hash       []byte
+	prefix     []byte
+}
+
+// candidates returns the expressions of a host that match a prefix in the
+// local database.
+func (b *Blocker) candidates(host string) []candidate {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	var candidates []candidate
+
+	for _, expression := range expressions(host) {
+		hash := sha256.Sum256([]byte(expression))
+
+		for _, list := range b.local {
+			if prefix := list.prefixes.contains(hash[:]); prefix != nil {
+				candidates = append(candidates, candidate{expression: expression, hash: hash[:], prefix: prefix})
+				break
+			}
+		}
+	}
+
+	return candidates
+}
+
+// cached returns the cached matches of a candidate; the boolean is false if
+// the candidate must be looked up.
+func (b *Blocker) cached(c candidate) ([]ThreatList, bool) {
+	now := b.now()
+
+	b.cacheLock.Lock()
+	defer b.cacheLock.Unlock()
+
+	if cached, ok := b.fullHashes[string(c.hash)]; ok && now.Before(cached.expires) {
+		return cached.lists, true
+	}
+
+	if expires, ok := b.negative[string(c.prefix)]; ok && now.Before(expires) {
+		return nil, true
+	}
+
+	return nil, false
+}
+
+// find looks up full hashes that match prefixes, and caches the result.
+func (b *Blocker) find(parent context.Context, prefixes [][]byte) error {
+	request := findRequest{Client: clientInfo{ClientID: clientID, ClientVersion: clientVersion}}
+
+	threatTypes := map[string]bool{}
+	platformTypes := map[string]bool{}
+	entryTypes := map[string]bool{}
+
+	for _, list := range b.lists {
+		if !threatTypes[list.ThreatType] {
+			threatTypes[list.ThreatType] = true
+			request.ThreatInfo.ThreatTypes = append(request.ThreatInfo.ThreatTypes, list.ThreatType)
+		}
+
+		if !platformTypes[list.PlatformType] {
+			platformTypes[list.PlatformType] = true
+			request.ThreatInfo.PlatformTypes = append(request.ThreatInfo.PlatformTypes, list.PlatformType)
+		}
+
+		if !entryTypes[list.ThreatEntryType] {
+			entryTypes[list.ThreatEntryType] = true
+			request.ThreatInfo.ThreatEntryTypes = append(request.ThreatInfo.ThreatEntryTypes, list.ThreatEntryType)
+		}
+	}
+
+	b.lock.RLock()
+	for _, list := range b.lists {
+		request.ClientStates = append(request.ClientStates, b.local[list].state)
+	}
+	b.lock.RUnlock()
+
+	for _, prefix := range prefixes {
+		request.ThreatInfo.ThreatEntries = append(request.ThreatInfo.ThreatEntries, threatEntry{Hash: prefix})
+	}
+
+	var response findResponse
+
+	if err := b.Breaker.Do(parent, func(parent context.Context) error {
+		ctx, cancel := context.WithTimeout(parent, lookupTimeout)
+		defer cancel()
+
+		return b.call(ctx, "fullHashes:find", &request, &response)
+	}); err != nil {
+		return err
+	}
+
+	now := b.now()
+
+	negativeCacheDuration := time.Duration(response.NegativeCacheDuration)
+	if negativeCacheDuration <= 0 {
+		negativeCacheDuration = defaultCacheDuration
+	}
+
+	b.cacheLock.Lock()
+	defer b.cacheLock.Unlock()
+
+	for _, prefix := range prefixes {
+		b.negative[string(prefix)] = now.Add(negativeCacheDuration)
+	}
+
+	// a full hash can match multiple lists
+	matches := map[string]cachedHash{}
+
+	for _, match := range response.Matches {
+		cacheDuration := time.Duration(match.CacheDuration)
+		if cacheDuration <= 0 {
+			cacheDuration = defaultCacheDuration
+		}
+
+		cached := matches[string(match.Threat.Hash)]
+		cached.expires = now.Add(cacheDuration)
+		cached.lists = append(cached.lists, match.ThreatList)
+		matches[string(match.Threat.Hash)] = cached
+	}
+
+	for hash, cached := range matches {
+		b.fullHashes[hash] = cached
+	}
+
+	return nil
+}
+
+func buildVerdict(expression string, lists []ThreatList) *verdict.Verdict {
+	category := verdict.CategoryMalware
+	for _, list := range lists {
+		if list.ThreatType == "SOCIAL_ENGINEERING" {
+			category = verdict.CategoryPhishing
+			break
+		}
+	}
+
+	return &verdict.Verdict{Source: Source, Category: category, Rule: lists[0].ThreatType + " (" + strings.TrimSuffix(expression, "/") + ")", Confidence: 1}
+}
+
+func (b *Blocker) IsBad(ctx context.Context, msg *queue.DomainAccessMessage) *verdict.Verdict {
+	candidates := b.candidates(msg.Domain)
+
+	// most domains don't match any prefix, so they're never sent to Google
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	var uncached [][]byte
+	for _, c := range candidates {
+		lists, ok := b.cached(c)
+		if !ok {
+			uncached = append(uncached, c.prefix)
+		} else if len(lists) > 0 {
+			return buildVerdict(c.expression, lists)
+		}
+	}
+
+	if len(uncached) == 0 {
+		return nil
+	}
+
+	if err := b.find(ctx, uncached); err != nil {
+		log.Printf("Failed to look up %s using Safe Browsing: %v", msg.Domain, err)
+		return nil
+	}
+
+	for _, c := range candidates {
+		if lists, _ := b.cached(c); len(lists) > 0 {
+			return buildVerdict(c.expression, lists)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,432 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package safebrowsing
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+const prefixSize = 4
+
+var (
+	malware  = ThreatList{ThreatType: "MALWARE", PlatformType: "ANY_PLATFORM", ThreatEntryType: "URL"}
+	phishing = ThreatList{ThreatType: "SOCIAL_ENGINEERING", PlatformType: "ANY_PLATFORM", ThreatEntryType: "URL"}
+)
+
+func hashOf(expression string) []byte {
+	hash := sha256.Sum256([]byte(expression))
+	return hash[:]
+}
+
+// fakeServer implements the Safe Browsing API, for a database of full
+// hashes.
+type fakeServer struct {
+	lock sync.Mutex
+
+	// the full hashes of each list, in each version of the database
+	versions []map[ThreatList][][]byte
+
+	badChecksum bool
+
+	states [][]byte
+	finds  []findRequest
+}
+
+func (server *fakeServer) publish(database map[ThreatList][][]byte) {
+	server.lock.Lock()
+	server.versions = append(server.versions, database)
+	server.lock.Unlock()
+}
+
+func sortedPrefixes(hashes [][]byte) []string {
+	var prefixes []string
+	for _, hash := range hashes {
+		prefixes = append(prefixes, string(hash[:prefixSize]))
+	}
+
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+func (server *fakeServer) fetch(request *fetchRequest) *fetchResponse {
+	response := fetchResponse{MinimumWaitDuration: duration(time.Minute)}
+	current := len(server.versions) - 1
+
+	for _, listRequest := range request.ListUpdateRequests {
+		server.states = append(server.states, listRequest.State)
+
+		update := listUpdateResponse{ThreatList: listRequest.ThreatList, ResponseType: fullUpdate, NewClientState: []byte(strconv.Itoa(current))}
+
+		next := sortedPrefixes(server.versions[current][listRequest.ThreatList])
+		added := next
+
+		if version, err := strconv.Atoi(string(listRequest.State)); err == nil && len(listRequest.State) > 0 {
+			update.ResponseType = "PARTIAL_UPDATE"
+			added = nil
+
+			previous := sortedPrefixes(server.versions[version][listRequest.ThreatList])
+			kept := map[string]bool{}
+			for _, prefix := range next {
+				kept[prefix] = true
+			}
+
+			removal := threatEntrySet{CompressionType: "RAW", RawIndices: &rawIndices{}}
+			for i, prefix := range previous {
+				if !kept[prefix] {
+					removal.RawIndices.Indices = append(removal.RawIndices.Indices, i)
+				}
+				delete(kept, prefix)
+			}
+			update.Removals = append(update.Removals, removal)
+
+			for prefix := range kept {
+				added = append(added, prefix)
+			}
+		}
+
+		var raw []byte
+		for _, prefix := range added {
+			raw = append(raw, prefix...)
+		}
+		update.Additions = append(update.Additions, threatEntrySet{CompressionType: "RAW", RawHashes: &rawHashes{PrefixSize: prefixSize, RawHashes: raw}})
+
+		hash := sha256.New()
+		for _, prefix := range next {
+			hash.Write([]byte(prefix))
+		}
+		update.Checksum.SHA256 = hash.Sum(nil)
+
+		if server.badChecksum {
+			update.Checksum.SHA256[0]++
+		}
+
+		response.ListUpdateResponses = append(response.ListUpdateResponses, update)
+	}
+
+	return &response
+}
+
+func (server *fakeServer) find(request *findRequest) *findResponse {
+	server.finds = append(server.finds, *request)
+
+	response := findResponse{NegativeCacheDuration: duration(5 * time.Minute)}
+
+	for _, entry := range request.ThreatInfo.ThreatEntries {
+		for list, hashes := range server.versions[len(server.versions)-1] {
+			for _, hash := range hashes {
+				if bytes.HasPrefix(hash, entry.Hash) {
+					response.Matches = append(response.Matches, threatMatch{ThreatList: list, Threat: threatEntry{Hash: hash}, CacheDuration: duration(10 * time.Minute)})
+				}
+			}
+		}
+	}
+
+	return &response
+}
+
+func (server *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	if r.URL.Query().Get("key") != "secret" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var response interface{}
+
+	switch r.URL.Path {
+	case "/v4/threatListUpdates:fetch":
+		var request fetchRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response = server.fetch(&request)
+
+	case "/v4/fullHashes:find":
+		var request findRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response = server.find(&request)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// collision returns a full hash that shares the prefix of an expression.
+func collision(expression string) []byte {
+	hash := hashOf(expression)
+	hash[len(hash)-1]++
+	return hash
+}
+
+func openBlocker(t *testing.T) (*Blocker, *fakeServer, *time.Time, func()) {
+	server := &fakeServer{}
+	server.publish(map[ThreatList][][]byte{
+		malware:  {hashOf("malware.example.com/"), collision("innocent.example.org/")},
+		phishing: {hashOf("example.net/")},
+	})
+
+	httpServer := httptest.NewServer(server)
+
+	c, err := cache.OpenCache(&cache.MemoryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := OpenBlocker(c, "secret", malware, phishing)
+	b.BaseURL = httpServer.URL
+
+	now := time.Unix(1000, 0)
+	b.now = func() time.Time { return now }
+
+	if err := b.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return b, server, &now, httpServer.Close
+}
+
+func TestConnectSlow(t *testing.T) {
+	server := &fakeServer{}
+	server.publish(map[ThreatList][][]byte{malware: {hashOf("malware.example.com/")}})
+
+	release := make(chan struct{})
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		server.ServeHTTP(w, r)
+	}))
+	defer httpServer.Close()
+
+	c, err := cache.OpenCache(&cache.MemoryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := OpenBlocker(c, "secret", malware)
+	b.BaseURL = httpServer.URL
+
+	// the first sync must not delay startup
+	start := time.Now()
+	if err := b.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("Connect waited for the sync")
+	}
+
+	close(release)
+
+	for i := 0; b.Len() == 0; i++ {
+		if i == 100 {
+			t.Fatal("no sync")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func isBad(b *Blocker, domain string) *verdict.Verdict {
+	return b.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: domain})
+}
+
+func TestExpressions(t *testing.T) {
+	for host, expected := range map[string][]string{
+		"a.b.c.d.e.f.g": {"a.b.c.d.e.f.g/", "c.d.e.f.g/", "d.e.f.g/", "e.f.g/", "f.g/"},
+		"a.b.c":         {"a.b.c/", "b.c/"},
+		"example.com":   {"example.com/"},
+		"192.0.2.1":     {"192.0.2.1/"},
+	} {
+		if exprs := expressions(host); len(exprs) != len(expected) {
+			t.Error(host, exprs)
+		} else {
+			for i := range exprs {
+				if exprs[i] != expected[i] {
+					t.Error(host, exprs)
+				}
+			}
+		}
+	}
+}
+
+func TestIsBad(t *testing.T) {
+	b, server, _, done := openBlocker(t)
+	defer done()
+
+	if b.Len() != 3 {
+		t.Error(b.Len())
+	}
+
+	for domain, expected := range map[string]*verdict.Verdict{
+		"malware.example.com":   {Category: verdict.CategoryMalware, Rule: "MALWARE (malware.example.com)"},
+		"login.www.example.net": {Category: verdict.CategoryPhishing, Rule: "SOCIAL_ENGINEERING (example.net)"},
+		"innocent.example.org":  nil,
+		"example.com":           nil,
+	} {
+		v := isBad(b, domain)
+		if expected == nil {
+			if v != nil {
+				t.Error(domain, v)
+			}
+		} else if v == nil || v.Source != Source || v.Category != expected.Category || v.Rule != expected.Rule {
+			t.Error(domain, v)
+		}
+	}
+
+	// only prefixes of domains that match the local database are sent
+	if len(server.finds) != 3 {
+		t.Fatal(len(server.finds))
+	}
+
+	for _, find := range server.finds {
+		if len(find.ThreatInfo.ThreatEntries) != 1 || len(find.ThreatInfo.ThreatEntries[0].Hash) != prefixSize {
+			t.Error(find.ThreatInfo)
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	b, server, now, done := openBlocker(t)
+	defer done()
+
+	for i := 0; i < 2; i++ {
+		isBad(b, "malware.example.com")
+		isBad(b, "innocent.example.org")
+	}
+
+	if len(server.finds) != 2 {
+		t.Error(len(server.finds))
+	}
+
+	// the negative result expires before the match
+	*now = now.Add(6 * time.Minute)
+
+	isBad(b, "malware.example.com")
+	isBad(b, "innocent.example.org")
+
+	if len(server.finds) != 3 {
+		t.Error(len(server.finds))
+	}
+}
+
+func TestPartialUpdate(t *testing.T) {
+	b, server, now, done := openBlocker(t)
+	defer done()
+
+	server.publish(map[ThreatList][][]byte{
+		malware:  {hashOf("malware.example.com/"), hashOf("new.example.com/")},
+		phishing: {hashOf("example.net/")},
+	})
+
+	if err := b.Update(context.Background()); err != ErrTooEarly {
+		t.Error(err)
+	}
+
+	*now = now.Add(time.Minute)
+
+	if err := b.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the client sends the state of each list
+	if len(server.states) != 4 || string(server.states[2]) != "0" || string(server.states[3]) != "0" {
+		t.Error(server.states)
+	}
+
+	if b.Len() != 3 {
+		t.Error(b.Len())
+	}
+
+	if v := isBad(b, "new.example.com"); v == nil {
+		t.Error("added domain is not blocked")
+	}
+
+	// the removed prefix is not checked
+	if v := isBad(b, "innocent.example.org"); v != nil || len(server.finds) != 1 {
+		t.Error(v, len(server.finds))
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	b, server, now, done := openBlocker(t)
+	defer done()
+
+	server.badChecksum = true
+	*now = now.Add(time.Minute)
+
+	if err := b.Update(context.Background()); err != ErrChecksum {
+		t.Error(err)
+	}
+
+	// lookups continue using the previous version
+	if v := isBad(b, "malware.example.com"); v == nil {
+		t.Error("listed domain is not blocked")
+	}
+
+	server.badChecksum = false
+	*now = now.Add(time.Minute)
+
+	if err := b.Update(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the list is replaced after a checksum mismatch
+	if len(server.states) != 6 || len(server.states[4]) != 0 || len(server.states[5]) != 0 {
+		t.Error(server.states)
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	b, _, now, done := openBlocker(t)
+	defer done()
+
+	b.APIKey = "wrong"
+	*now = now.Add(time.Minute)
+
+	if err, ok := b.Update(context.Background()).(*StatusError); !ok || err.StatusCode != http.StatusForbidden {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,190 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package threatfox blocks domains listed by ThreatFox.
+package threatfox
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/blocklist"
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/feed"
+	"github.com/dimkr/dohli/pkg/fetch"
+	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+// Source is the source name in verdicts of Blocker.
+const Source = "threatfox"
+
+const (
+	feedURL      = "https://threatfox.abuse.ch/export/csv/domains/recent/"
+	feedInterval = 30 * time.Minute
+	feedTimeout  = 5 * time.Minute
+)
+
+// columns of the CSV export
+const (
+	iocValueColumn         = 2
+	iocTypeColumn          = 3
+	threatTypeColumn       = 4
+	malwarePrintableColumn = 7
+	confidenceColumn       = 9
+	minColumns             = 10
+)
+
+// Blocker blocks domains of indicators of compromise listed by ThreatFox, like
+// botnet command and control servers. The list is downloaded periodically.
+type Blocker struct {
+	feed feed.Feed
+
+	lock  sync.RWMutex
+	hosts map[string]*verdict.Verdict
+}
+
+// OpenBlocker returns a new ThreatFox blocker; the status of the feed is stored
+// in c.
+func OpenBlocker(c *cache.Cache) *Blocker {
+	b := &Blocker{}
+
+	b.feed = feed.Feed{
+		Source:   fetch.Source{Name: Source, URL: feedURL},
+		Interval: feedInterval,
+		Update:   b.update,
+		Cache:    c,
+	}
+
+	return b
+}
+
+// Connect starts downloading and refreshing the list in the background.
+func (b *Blocker) Connect() error {
+	b.feed.Start(context.Background())
+	return nil
+}
+
+func (b *Blocker) IsAsync() bool {
+	return false
+}
+
+// Reload downloads the list again.
+func (b *Blocker) Reload() error {
+	ctx, cancel := context.WithTimeout(context.Background(), feedTimeout)
+	defer cancel()
+
+	return b.feed.Refresh(ctx)
+}
+
+// Status returns the freshness of the list.
+func (b *Blocker) Status() feed.Status {
+	return b.feed.Status()
+}
+
+// parse parses the CSV export of ThreatFox and returns a verdict for each
+// host.
+func parse(r io.Reader) (map[string]*verdict.Verdict, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.LazyQuotes = true
+
+	hosts := map[string]*verdict.Verdict{}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if len(record) < minColumns {
+			continue
+		}
+
+		var host string
+		switch record[iocTypeColumn] {
+		case "domain":
+			host = blocklist.URLHost("//" + record[iocValueColumn])
+
+		case "url":
+			host = blocklist.URLHost(record[iocValueColumn])
+		}
+		if host == "" {
+			continue
+		}
+
+		confidence := 1.0
+		if level, err := strconv.Atoi(record[confidenceColumn]); err == nil && level >= 0 && level < 100 {
+			confidence = float64(level) / 100
+		}
+
+		// the most confident indicator of each host wins
+		if v, ok := hosts[host]; ok && v.Confidence >= confidence {
+			continue
+		}
+
+		hosts[host] = &verdict.Verdict{
+			Source:     Source,
+			Category:   verdict.CategoryMalware,
+			Rule:       record[malwarePrintableColumn] + " (" + record[threatTypeColumn] + ")",
+			Confidence: confidence,
+		}
+	}
+
+	return hosts, nil
+}
+
+func (b *Blocker) update(data []byte) (int, error) {
+	hosts, err := parse(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+
+	b.lock.Lock()
+	b.hosts = hosts
+	b.lock.Unlock()
+
+	return len(hosts), nil
+}
+
+func (b *Blocker) IsBad(_ context.Context, msg *queue.DomainAccessMessage) *verdict.Verdict {
+	b.lock.RLock()
+	v, ok := b.hosts[msg.Domain]
+	b.lock.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	// verdicts are shared by all lookups of the same host
+	copied := *v
+	return &copied
+}
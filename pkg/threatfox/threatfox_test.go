@@ -0,0 +1,72 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package threatfox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/queue"
+)
+
+func TestIsBad(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer server.Close()
+
+	c, err := cache.OpenCache(&cache.MemoryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := OpenBlocker(c)
+	b.feed.Source.URL = server.URL + "/recent.csv"
+
+	if err := b.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	for domain, expected := range map[string]struct {
+		rule       string
+		confidence float64
+	}{
+		"cdn-update.example":         {"Cobalt Strike (botnet_cc)", 1},
+		"invoice-portal.example.net": {"SocGholish (payload_delivery)", 0.75},
+		"docs.google.com":            {},
+	} {
+		v := b.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: domain})
+		if expected.rule == "" {
+			if v != nil {
+				t.Error(domain, v)
+			}
+		} else if v == nil || v.Rule != expected.rule || v.Confidence != expected.confidence {
+			t.Error(domain, v)
+		}
+	}
+
+	if status := b.Status(); status.Entries != 2 || status.Updated.IsZero() {
+		t.Error(status)
+	}
+}
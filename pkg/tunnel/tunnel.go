@@ -0,0 +1,310 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package tunnel detects DNS tunnels, which carry traffic or exfiltrated data
+// in subdomains of a domain controlled by an attacker.
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Source is the source name in verdicts of Detector.
+const Source = "tunnel"
+
+const keyPrefix = "tunnel:"
+
+// typeNULL is the type of NULL records, which can contain arbitrary data
+const typeNULL dnsmessage.Type = 10
+
+// Thresholds define suspicious queries of a client under a registrable domain,
+// during a window of time.
+type Thresholds struct {
+	// Window is the length of the window, in seconds
+	Window int
+
+	// MinUnique is the number of unique subdomains, below which nothing is
+	// suspicious
+	MinUnique int
+
+	// MaxUnique is the number of unique subdomains that is suspicious, no
+	// matter how the subdomains look
+	MaxUnique int
+
+	// LongLabel is the length of a long label
+	LongLabel int
+
+	// HighEntropy is the entropy of random-looking subdomains, in bits
+	HighEntropy float64
+
+	// Ratio is the suspicious fraction of queries with long labels,
+	// random-looking subdomains or the TXT and NULL types
+	Ratio float64
+}
+
+// DefaultThresholds are the thresholds of a new Detector.
+var DefaultThresholds = Thresholds{
+	Window:      60 * 10,
+	MinUnique:   50,
+	MaxUnique:   500,
+	LongLabel:   30,
+	HighEntropy: 3.8,
+	Ratio:       0.5,
+}
+
+// DefaultBlockDuration is the time a new Detector blocks a suspected tunnel
+// for: the suspicion comes from the queries of one client, so it shouldn't
+// last forever.
+const DefaultBlockDuration = 24 * time.Hour
+
+// Alert describes a suspected tunnel.
+type Alert struct {
+	Zone   string    `json:"zone"`
+	Client string    `json:"client,omitempty"`
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+}
+
+// Detector counts the queries of each client under each registrable domain,
+// and flags registrable domains that look like the endpoint of a tunnel.
+//
+// The counts are shared by all workers and kept in two overlapping windows,
+// which start half a window apart: the older one always covers between half a
+// window and a full window of recent queries.
+type Detector struct {
+	Thresholds
+
+	// OnAlert is called when a tunnel is suspected, once per window for each
+	// client and registrable domain
+	OnAlert func(*Alert)
+
+	// BlockDuration is the time verdicts expire after
+	BlockDuration time.Duration
+
+	cache *cache.Cache
+}
+
+// OpenDetector returns a new detector, with the default thresholds and block
+// duration.
+func OpenDetector(c *cache.Cache) *Detector {
+	return &Detector{Thresholds: DefaultThresholds, BlockDuration: DefaultBlockDuration, cache: c}
+}
+
+type counts struct {
+	queries, unique, long, random, txt int64
+}
+
+type query struct {
+	subdomain   string
+	longLabel   bool
+	highEntropy bool
+	txt         bool
+}
+
+// entropy returns the Shannon entropy of characters, in bits.
+func entropy(s string) float64 {
+	frequencies := map[rune]int{}
+	for _, c := range s {
+		frequencies[c]++
+	}
+
+	h := 0.0
+	for _, n := range frequencies {
+		p := float64(n) / float64(len(s))
+		h -= p * math.Log2(p)
+	}
+
+	return h
+}
+
+func (d *Detector) parse(msg *queue.DomainAccessMessage, zone string) *query {
+	q := query{txt: msg.RequestType == dnsmessage.TypeTXT || msg.RequestType == typeNULL}
+
+	if !strings.HasSuffix(msg.Domain, "."+zone) {
+		return &q
+	}
+
+	q.subdomain = msg.Domain[:len(msg.Domain)-len(zone)-1]
+
+	for _, label := range strings.Split(q.subdomain, ".") {
+		if len(label) >= d.LongLabel {
+			q.longLabel = true
+		}
+	}
+
+	q.highEntropy = entropy(strings.Replace(q.subdomain, ".", "", -1)) >= d.HighEntropy
+
+	return &q
+}
+
+func delta(cond bool) int64 {
+	if cond {
+		return 1
+	}
+
+	return 0
+}
+
+// count adds a query to the counts of two windows at once, and returns the
+// counts of the first one.
+func (d *Detector) count(backend cache.CacheBackend, readPrefix, otherPrefix string, q *query) *counts {
+	updates := []cache.Update{
+		{Key: "queries", Delta: 1},
+		{Key: "long", Delta: delta(q.longLabel)},
+		{Key: "random", Delta: delta(q.highEntropy)},
+		{Key: "txt", Delta: delta(q.txt)},
+	}
+
+	// unique subdomains are counted using a HyperLogLog, so each subdomain
+	// doesn't need a key of its own
+	if q.subdomain != "" {
+		updates = append(updates, cache.Update{Key: "unique", Member: q.subdomain})
+	}
+
+	n := len(updates)
+	for i := 0; i < n; i++ {
+		update := updates[i]
+		updates[i].Key = readPrefix + update.Key
+
+		// unchanged counts of the other window are not read
+		if update.Delta != 0 || update.Member != "" {
+			update.Key = otherPrefix + update.Key
+			updates = append(updates, update)
+		}
+	}
+
+	results := backend.Apply(updates, d.Window+1)
+	c := counts{queries: results[0], long: results[1], random: results[2], txt: results[3]}
+
+	if q.subdomain != "" {
+		c.unique = results[4]
+	} else {
+		c.unique = backend.PFCount(readPrefix + "unique")
+	}
+
+	return &c
+}
+
+// explain returns the reason the counts of a window are suspicious, or an
+// empty string.
+func (d *Detector) explain(c *counts) string {
+	if c.unique < int64(d.MinUnique) {
+		return ""
+	}
+
+	reasons := []string{fmt.Sprintf("%d unique subdomains", c.unique)}
+
+	for _, ratio := range []struct {
+		n    int64
+		what string
+	}{
+		{c.long, "long labels"},
+		{c.random, "random subdomains"},
+		{c.txt, "TXT or NULL queries"},
+	} {
+		if r := float64(ratio.n) / float64(c.queries); r >= d.Ratio {
+			reasons = append(reasons, fmt.Sprintf("%.0f%% %s", r*100, ratio.what))
+		}
+	}
+
+	if len(reasons) == 1 && c.unique < int64(d.MaxUnique) {
+		return ""
+	}
+
+	return strings.Join(reasons, ", ")
+}
+
+// Check counts a query and returns the registrable domain of the queried
+// domain, and the reason the querying client is suspected of using a tunnel
+// through it, if it is.
+func (d *Detector) Check(ctx context.Context, msg *queue.DomainAccessMessage) (string, string) {
+	zone, err := publicsuffix.EffectiveTLDPlusOne(msg.Domain)
+	if err != nil {
+		return "", ""
+	}
+
+	t := msg.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	q := d.parse(msg, zone)
+	backend := d.cache.Backend(ctx)
+
+	now := t.Unix()
+	window := int64(d.Window)
+
+	// the first window starts at a multiple of the window length, the
+	// second one starts half a window later
+	first, second := now/window, (now+window/2)/window
+	firstPrefix := fmt.Sprintf("%s%s:%s:0:%d:", keyPrefix, zone, msg.Client, first)
+	secondPrefix := fmt.Sprintf("%s%s:%s:1:%d:", keyPrefix, zone, msg.Client, second)
+
+	firstStart := first * window
+	secondStart := second*window - window/2
+
+	// the older window is read
+	if firstStart > secondStart {
+		firstPrefix, secondPrefix = secondPrefix, firstPrefix
+	}
+
+	return zone, d.explain(d.count(backend, firstPrefix, secondPrefix, q))
+}
+
+func (d *Detector) Connect() error {
+	return nil
+}
+
+func (d *Detector) IsAsync() bool {
+	return false
+}
+
+func (d *Detector) IsBad(ctx context.Context, msg *queue.DomainAccessMessage) *verdict.Verdict {
+	// retried messages were counted already
+	if msg.Retries > 0 {
+		return nil
+	}
+
+	zone, reason := d.Check(ctx, msg)
+	if reason == "" {
+		return nil
+	}
+
+	now := time.Now().UTC()
+
+	if d.OnAlert != nil && d.cache.Backend(ctx).Incr(keyPrefix+"alert:"+zone+":"+msg.Client, d.Window) == 1 {
+		d.OnAlert(&Alert{Zone: zone, Client: msg.Client, Time: now, Reason: reason})
+	}
+
+	expires := now.Add(d.BlockDuration)
+	return &verdict.Verdict{Source: Source, Category: verdict.CategoryTunneling, Rule: reason, Confidence: 1, Expires: &expires, Zone: zone}
+}
@@ -0,0 +1,165 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const base32 = "abcdefghijklmnopqrstuvwxyz234567"
+
+func openDetector(t *testing.T) *Detector {
+	c, err := cache.OpenCache(&cache.MemoryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return OpenDetector(c)
+}
+
+// encoded returns a subdomain that looks like encoded data
+func encoded(r *rand.Rand) string {
+	b := make([]byte, 50)
+	for i := range b {
+		b[i] = base32[r.Intn(len(base32))]
+	}
+
+	return string(b[:40]) + "." + string(b[40:])
+}
+
+func TestTunnel(t *testing.T) {
+	d := openDetector(t)
+	start := time.Now()
+	r := rand.New(rand.NewSource(1))
+
+	var alerts []*Alert
+	d.OnAlert = func(alert *Alert) {
+		alerts = append(alerts, alert)
+	}
+
+	for i := 0; i < d.MinUnique+10; i++ {
+		v := d.IsBad(context.Background(), &queue.DomainAccessMessage{
+			Domain:      encoded(r) + ".t.example.co.uk",
+			RequestType: dnsmessage.TypeTXT,
+			Client:      "a",
+			Time:        start.Add(time.Duration(i) * time.Second),
+		})
+
+		if i < d.MinUnique-1 {
+			if v != nil {
+				t.Fatal(i, v)
+			}
+		} else if v == nil || v.Source != Source || v.Category != verdict.CategoryTunneling || v.Zone != "example.co.uk" || v.Expires == nil || time.Until(*v.Expires) > DefaultBlockDuration {
+			t.Fatal(i, v)
+		}
+	}
+
+	if len(alerts) != 1 || alerts[0].Zone != "example.co.uk" || alerts[0].Client != "a" || alerts[0].Reason != "50 unique subdomains, 100% long labels, 100% random subdomains, 100% TXT or NULL queries" {
+		t.Error(alerts)
+	}
+
+	// other clients are counted separately
+	if v := d.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: encoded(r) + ".example.co.uk", RequestType: dnsmessage.TypeTXT, Client: "b", Time: start}); v != nil {
+		t.Error(v)
+	}
+
+	// old queries are forgotten
+	if v := d.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: encoded(r) + ".example.co.uk", RequestType: dnsmessage.TypeTXT, Client: "a", Time: start.Add(time.Duration(d.Window) * time.Second * 2)}); v != nil {
+		t.Error(v)
+	}
+}
+
+func TestBenign(t *testing.T) {
+	d := openDetector(t)
+	start := time.Now()
+
+	for i := 0; i < d.MaxUnique-1; i++ {
+		for _, domain := range []string{
+			"www.example.com",
+			fmt.Sprintf("host%d.example.org", i),
+		} {
+			if v := d.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: domain, RequestType: dnsmessage.TypeA, Client: "a", Time: start}); v != nil {
+				t.Fatal(domain, v)
+			}
+		}
+	}
+
+	// many unique subdomains are suspicious, even if they don't look random
+	if v := d.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "last.example.org", RequestType: dnsmessage.TypeA, Client: "a", Time: start}); v == nil || v.Rule != "500 unique subdomains" {
+		t.Error(v)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	d := openDetector(t)
+	d.MinUnique = 1
+	d.MaxUnique = 1
+
+	if v := d.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "a.example.com", Retries: 1}); v != nil {
+		t.Error(v)
+	}
+
+	if v := d.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "a.example.com"}); v == nil || v.Rule != "1 unique subdomains" {
+		t.Error(v)
+	}
+}
+
+func TestWindows(t *testing.T) {
+	d := openDetector(t)
+	d.MinUnique = 4
+	d.MaxUnique = 4
+
+	// the first window starts here, and the second one starts half a window
+	// earlier, then half a window later
+	start := time.Unix(int64(d.Window)*1000, 0)
+	quarter := time.Duration(d.Window) * time.Second / 4
+
+	for i, expected := range []bool{
+		false,
+		false,
+		false,
+		// the first window has seen 4 queries
+		true,
+		// the first window is new and the second one has seen the last 3
+		// queries
+		false,
+		true,
+	} {
+		v := d.IsBad(context.Background(), &queue.DomainAccessMessage{
+			Domain: fmt.Sprintf("host%d.example.com", i),
+			Time:   start.Add(time.Duration(i) * quarter),
+		})
+		if (v != nil) != expected {
+			t.Error(i, v)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package typosquat
+
+import "strings"
+
+// confusables maps characters that look like a latin letter or digit to that
+// letter, based on the Unicode confusables table; only characters allowed in
+// domain names are listed.
+var confusables = map[rune]rune{
+	// cyrillic
+	'а': 'a', 'в': 'b', 'с': 'c', 'ԁ': 'd', 'е': 'e', 'ё': 'e', 'һ': 'h',
+	'і': 'i', 'ї': 'i', 'ј': 'j', 'к': 'k', 'ӏ': 'l', 'м': 'm', 'п': 'n',
+	'о': 'o', 'р': 'p', 'ԛ': 'q', 'г': 'r', 'ѕ': 's', 'т': 't', 'ц': 'u',
+	'ѵ': 'v', 'ԝ': 'w', 'х': 'x', 'у': 'y', 'ү': 'y', 'ь': 'b',
+
+	// greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v',
+	'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'γ': 'y', 'ω': 'w',
+	'ϲ': 'c', 'ϳ': 'j',
+
+	// latin letters with diacritics, or in other styles
+	'à': 'a', 'á': 'a', 'â': 'a', 'ã': 'a', 'ä': 'a', 'å': 'a', 'ā': 'a',
+	'ă': 'a', 'ą': 'a', 'ɑ': 'a', 'ç': 'c', 'ć': 'c', 'ĉ': 'c', 'ċ': 'c',
+	'č': 'c', 'ď': 'd', 'đ': 'd', 'ɗ': 'd', 'è': 'e', 'é': 'e', 'ê': 'e',
+	'ë': 'e', 'ē': 'e', 'ė': 'e', 'ę': 'e', 'ě': 'e', 'ĝ': 'g', 'ğ': 'g',
+	'ġ': 'g', 'ģ': 'g', 'ɡ': 'g', 'ĥ': 'h', 'ì': 'i', 'í': 'i', 'î': 'i',
+	'ï': 'i', 'ī': 'i', 'į': 'i', 'ı': 'i', 'ĵ': 'j', 'ķ': 'k', 'ĺ': 'l',
+	'ļ': 'l', 'ľ': 'l', 'ł': 'l', 'ñ': 'n', 'ń': 'n', 'ņ': 'n', 'ň': 'n',
+	'ò': 'o', 'ó': 'o', 'ô': 'o', 'õ': 'o', 'ö': 'o', 'ø': 'o', 'ō': 'o',
+	'ő': 'o', 'ŕ': 'r', 'ř': 'r', 'ś': 's', 'ŝ': 's', 'ş': 's', 'š': 's',
+	'ţ': 't', 'ť': 't', 'ù': 'u', 'ú': 'u', 'û': 'u', 'ü': 'u', 'ū': 'u',
+	'ů': 'u', 'ű': 'u', 'ų': 'u', 'ŵ': 'w', 'ý': 'y', 'ÿ': 'y', 'ŷ': 'y',
+	'ź': 'z', 'ż': 'z', 'ž': 'z',
+
+	// digits that look like letters
+	'0': 'o', '1': 'l',
+}
+
+// sequences of latin letters that look like another letter
+var confusableSequences = strings.NewReplacer("rn", "m", "vv", "w")
+
+// skeleton maps a label to a string that looks the same, so two labels that
+// look alike have the same skeleton.
+func skeleton(label string) string {
+	var b strings.Builder
+
+	for _, r := range label {
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+
+		b.WriteRune(r)
+	}
+
+	return confusableSequences.Replace(b.String())
+}
@@ -0,0 +1,133 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package typosquat
+
+// keyboard is the layout of a QWERTY keyboard: each row is shifted to the
+// right by about half a key, relative to the previous one.
+var keyboard = []string{
+	"1234567890-",
+	"qwertyuiop",
+	"asdfghjkl",
+	"zxcvbnm",
+}
+
+type key struct {
+	row, column int
+}
+
+var keys = map[byte]key{}
+
+func init() {
+	for row, keysInRow := range keyboard {
+		for column := 0; column < len(keysInRow); column++ {
+			keys[keysInRow[column]] = key{row, column}
+		}
+	}
+}
+
+// isAdjacent determines whether or not two keys are next to each other.
+func isAdjacent(a, b byte) bool {
+	ka, ok := keys[a]
+	if !ok {
+		return false
+	}
+
+	kb, ok := keys[b]
+	if !ok {
+		return false
+	}
+
+	switch kb.row - ka.row {
+	case 0:
+		return kb.column == ka.column-1 || kb.column == ka.column+1
+	case -1:
+		return kb.column == ka.column || kb.column == ka.column+1
+	case 1:
+		return kb.column == ka.column-1 || kb.column == ka.column
+	}
+
+	return false
+}
+
+// isKeyboardTypo determines whether or not s is t, with one character replaced
+// by a neighboring key.
+func isKeyboardTypo(s, t string) bool {
+	if len(s) != len(t) {
+		return false
+	}
+
+	typos := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == t[i] {
+			continue
+		}
+
+		if typos++; typos > 1 || !isAdjacent(s[i], t[i]) {
+			return false
+		}
+	}
+
+	return typos == 1
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// distance returns the number of insertions, deletions, substitutions and
+// swaps of adjacent characters that turn s into t (the optimal string
+// alignment distance).
+func distance(s, t string) int {
+	// d[i][j] is the distance between the first i characters of s and the
+	// first j characters of t
+	d := make([][]int, len(s)+1)
+	for i := range d {
+		d[i] = make([]int, len(t)+1)
+		d[i][0] = i
+	}
+
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(s); i++ {
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+
+			d[i][j] = min(min(d[i-1][j]+1, d[i][j-1]+1), d[i-1][j-1]+cost)
+
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(s)][len(t)]
+}
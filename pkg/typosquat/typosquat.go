@@ -0,0 +1,199 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package typosquat detects domains that imitate the domains of protected
+// brands, like paypa1.com or xn--pple-43d.com.
+package typosquat
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Source is the source name in verdicts of Blocker.
+const Source = "typosquat"
+
+// Techniques used to imitate a brand.
+const (
+	TechniqueHomoglyph    = "homoglyph"
+	TechniqueKeyboard     = "keyboard"
+	TechniqueEditDistance = "edit distance"
+)
+
+const (
+	// typos in shorter names produce other, legitimate names too often
+	minTypoLength = 5
+
+	// names at least this long can have two typos
+	longNameLength = 9
+
+	// each typo costs the confidence of this many characters of the name,
+	// because typos in short names are more likely to produce legitimate
+	// names
+	typoWeight = 2
+)
+
+// ErrBadBrand is returned when a brand is not a registrable domain.
+var ErrBadBrand = errors.New("bad brand domain")
+
+type brand struct {
+	domain   string
+	label    string
+	skeleton string
+}
+
+// Match describes a domain that imitates a brand.
+type Match struct {
+	// Brand is the registrable domain of the imitated brand
+	Brand string `json:"brand"`
+
+	Technique string `json:"technique"`
+
+	// Confidence is 1 if the domain looks exactly like the brand, and lower
+	// if it has typos, especially in a short name
+	Confidence float64 `json:"confidence"`
+}
+
+func (m *Match) String() string {
+	return m.Brand + " (" + m.Technique + ")"
+}
+
+// Blocker blocks domains that look like the domain of a protected brand.
+type Blocker struct {
+	brands    []brand
+	protected map[string]bool
+}
+
+// registrable splits a domain into its registrable domain and the label under
+// the public suffix.
+func registrable(domain string) (string, string, error) {
+	etldPlusOne, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return "", "", err
+	}
+
+	return etldPlusOne, etldPlusOne[:strings.IndexByte(etldPlusOne, '.')], nil
+}
+
+// unicodeSkeleton returns the skeleton of a label, after decoding punycode.
+func unicodeSkeleton(label string) string {
+	if decoded, err := idna.Lookup.ToUnicode(label); err == nil {
+		label = decoded
+	}
+
+	return skeleton(label)
+}
+
+// OpenBlocker returns a blocker that protects a list of registrable domains.
+func OpenBlocker(brands ...string) (*Blocker, error) {
+	b := Blocker{protected: map[string]bool{}}
+
+	for _, domain := range brands {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+
+		etldPlusOne, label, err := registrable(domain)
+		if err != nil || etldPlusOne != domain {
+			return nil, ErrBadBrand
+		}
+
+		b.brands = append(b.brands, brand{domain: domain, label: label, skeleton: unicodeSkeleton(label)})
+		b.protected[domain] = true
+	}
+
+	return &b, nil
+}
+
+// typoConfidence returns the confidence of a match with typos.
+func typoConfidence(label string, typos int) float64 {
+	confidence := 1 - float64(typos*typoWeight)/float64(len(label))
+	if confidence < 0 {
+		return 0
+	}
+
+	return confidence
+}
+
+// Check returns the brand imitated by a domain, or nil.
+func (b *Blocker) Check(domain string) *Match {
+	etldPlusOne, label, err := registrable(domain)
+	if err != nil || b.protected[etldPlusOne] {
+		return nil
+	}
+
+	skeleton := unicodeSkeleton(label)
+
+	for _, brand := range b.brands {
+		// the same name under another suffix is not a lookalike
+		if label == brand.label {
+			continue
+		}
+
+		if skeleton == brand.skeleton {
+			return &Match{Brand: brand.domain, Technique: TechniqueHomoglyph, Confidence: 1}
+		}
+
+		if len(brand.label) < minTypoLength {
+			continue
+		}
+
+		if isKeyboardTypo(label, brand.label) {
+			return &Match{Brand: brand.domain, Technique: TechniqueKeyboard, Confidence: typoConfidence(brand.label, 1)}
+		}
+
+		maxDistance := 1
+		if len(brand.label) >= longNameLength {
+			maxDistance = 2
+		}
+
+		if diff := len(label) - len(brand.label); diff > maxDistance || -diff > maxDistance {
+			continue
+		}
+
+		if d := distance(label, brand.label); d <= maxDistance {
+			return &Match{Brand: brand.domain, Technique: TechniqueEditDistance, Confidence: typoConfidence(brand.label, d)}
+		}
+	}
+
+	return nil
+}
+
+func (b *Blocker) Connect() error {
+	return nil
+}
+
+func (b *Blocker) IsAsync() bool {
+	return false
+}
+
+func (b *Blocker) IsBad(_ context.Context, msg *queue.DomainAccessMessage) *verdict.Verdict {
+	if m := b.Check(msg.Domain); m != nil {
+		return &verdict.Verdict{Source: Source, Category: verdict.CategoryPhishing, Rule: m.String(), Confidence: m.Confidence}
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package typosquat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+func TestCheck(t *testing.T) {
+	b, err := OpenBlocker("paypal.com", "apple.com", "microsoft.com", "bbc.co.uk", "modern.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for domain, expected := range map[string]*Match{
+		"paypa1.com":                  {Brand: "paypal.com", Technique: TechniqueHomoglyph},
+		"login.paypa1.com":            {Brand: "paypal.com", Technique: TechniqueHomoglyph},
+		"xn--pple-43d.com":            {Brand: "apple.com", Technique: TechniqueHomoglyph},
+		"xn--80ak6aa92e.com":          {Brand: "apple.com", Technique: TechniqueHomoglyph},
+		"xn--l-7sba6dbr.com":          {Brand: "paypal.com", Technique: TechniqueHomoglyph},
+		"xn--ppl-pla2a.net":           {Brand: "apple.com", Technique: TechniqueHomoglyph},
+		"rnicrosoft.com":              {Brand: "microsoft.com", Technique: TechniqueHomoglyph},
+		"modem.com":                   {Brand: "modern.com", Technique: TechniqueHomoglyph},
+		"xn--bb-pmc.co.uk":            {Brand: "bbc.co.uk", Technique: TechniqueHomoglyph},
+		"paypak.com":                  {Brand: "paypal.com", Technique: TechniqueKeyboard},
+		"aople.com":                   {Brand: "apple.com", Technique: TechniqueKeyboard},
+		"paypall.com":                 {Brand: "paypal.com", Technique: TechniqueEditDistance},
+		"pyapal.com":                  {Brand: "paypal.com", Technique: TechniqueEditDistance},
+		"micrsoftt.net":               {Brand: "microsoft.com", Technique: TechniqueEditDistance},
+		"paypal.com":                  nil,
+		"www.paypal.com":              nil,
+		"paypal.de":                   nil,
+		"bbc.com":                     nil,
+		"abc.co.uk":                   nil,
+		"pineapple.com":               nil,
+		"example.com":                 nil,
+		"micro.com":                   nil,
+		"com":                         nil,
+		"secure-paypal-login.example": nil,
+	} {
+		m := b.Check(domain)
+		if expected == nil {
+			if m != nil {
+				t.Error(domain, m)
+			}
+		} else if m == nil || m.Brand != expected.Brand || m.Technique != expected.Technique {
+			t.Error(domain, m)
+		}
+	}
+}
+
+func TestIsBad(t *testing.T) {
+	b, err := OpenBlocker("paypal.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := b.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "paypa1.com"})
+	if v == nil || v.Source != Source || v.Category != verdict.CategoryPhishing || v.Rule != "paypal.com (homoglyph)" {
+		t.Error(v)
+	}
+
+	if v := b.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "wikipedia.org"}); v != nil {
+		t.Error(v)
+	}
+}
+
+func TestConfidence(t *testing.T) {
+	b, err := OpenBlocker("paypal.com", "apple.com", "google.com", "microsoft.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for domain, expected := range map[string]float64{
+		// lookalikes are certain
+		"paypa1.com":       1,
+		"xn--pple-43d.com": 1,
+		"rnicrosoft.com":   1,
+		// a typo in a long name is suspicious
+		"microsodt.com": 1 - 2.0/9,
+		"microsfot.com": 1 - 2.0/9,
+		// two typos are less so
+		"micrsoftt.net": 1 - 4.0/9,
+		// legitimate names are often one typo away from short names
+		"ample.com":   1 - 2.0/5,
+		"apples.com":  1 - 2.0/5,
+		"goggle.com":  1 - 2.0/6,
+		"paypals.com": 1 - 2.0/6,
+	} {
+		v := b.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: domain})
+		if v == nil || v.Confidence-expected > 0.001 || expected-v.Confidence > 0.001 {
+			t.Error(domain, v)
+		}
+	}
+}
+
+func TestBadBrand(t *testing.T) {
+	for _, brand := range []string{"com", "www.paypal.com", ""} {
+		if _, err := OpenBlocker(brand); err != ErrBadBrand {
+			t.Error(brand, err)
+		}
+	}
+}
+
+func TestKeyboard(t *testing.T) {
+	for _, pair := range [][2]byte{{'a', 'q'}, {'a', 'w'}, {'a', 's'}, {'a', 'z'}, {'g', 'b'}, {'p', '0'}, {'m', 'n'}} {
+		if !isAdjacent(pair[0], pair[1]) || !isAdjacent(pair[1], pair[0]) {
+			t.Error(string(pair[:]))
+		}
+	}
+
+	for _, pair := range [][2]byte{{'a', 'e'}, {'q', 'z'}, {'a', 'x'}, {'p', 'm'}} {
+		if isAdjacent(pair[0], pair[1]) {
+			t.Error(string(pair[:]))
+		}
+	}
+}
@@ -20,35 +20,62 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
-// Package urlhaus queries host information from URLHaus.
 package urlhaus
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
 )
 
+// DefaultBaseURL is the default API URL.
+const DefaultBaseURL = "https://urlhaus-api.abuse.ch"
+
 const (
-	url     = "https://urlhaus-api.abuse.ch"
-	timeout = 5 * time.Second
+	defaultTimeout = 5 * time.Second
+
+	// API responses are truncated to this size
+	maxResponseSize = 1024 * 1024
 )
 
-// Client is a URLHaus API client.
-type Client struct{}
+// ErrNoResults is returned when a domain is unknown to URLHaus.
+var ErrNoResults = errors.New("no results")
+
+// ErrInvalidHost is returned when URLHaus rejects a domain.
+var ErrInvalidHost = errors.New("invalid host")
+
+// ErrUnauthorized is returned when the auth key is missing or rejected.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// StatusError is returned when the API responds with an unexpected HTTP
+// status.
+type StatusError struct {
+	StatusCode int
+}
+
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("bad status: %d", err.StatusCode)
+}
 
-func (client *Client) Connect() error {
-	return nil
+// QueryError is returned when the API responds with an unexpected query
+// status.
+type QueryError struct {
+	QueryStatus string
 }
 
-func (client *Client) IsAsync() bool {
-	return true
+func (err *QueryError) Error() string {
+	return "bad query status: " + err.QueryStatus
 }
 
 type hostResponse struct {
@@ -56,47 +83,136 @@ type hostResponse struct {
 	Blacklists  map[string]string `json:"blacklists"`
 }
 
-func (client *Client) IsBad(parent context.Context, msg *queue.DomainAccessMessage) bool {
-	ctx, cancel := context.WithTimeout(parent, timeout)
+func (client *Client) baseURL() string {
+	if client.BaseURL != "" {
+		return strings.TrimSuffix(client.BaseURL, "/")
+	}
+
+	return DefaultBaseURL
+}
+
+func (client *Client) timeout() time.Duration {
+	if client.Timeout > 0 {
+		return client.Timeout
+	}
+
+	return defaultTimeout
+}
+
+func (client *Client) httpClient() *http.Client {
+	if client.HTTPClient != nil {
+		return client.HTTPClient
+	}
+
+	return http.DefaultClient
+}
+
+// Lookup looks up a domain using the API. The verdict is nil if the domain is
+// known but not listed by any blacklist.
+func (client *Client) Lookup(parent context.Context, domain string) (*verdict.Verdict, error) {
+	ctx, cancel := context.WithTimeout(parent, client.timeout())
 	defer cancel()
 
-	request, err := http.NewRequestWithContext(ctx, "POST", url+"/v1/host", bytes.NewBuffer([]byte("host="+msg.Domain)))
+	form := url.Values{"host": {domain}}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, client.baseURL()+"/v1/host/", strings.NewReader(form.Encode()))
 	if err != nil {
-		return false
+		return nil, err
 	}
 	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if client.AuthKey != "" {
+		request.Header.Set("Auth-Key", client.AuthKey)
+	}
 
-	response, err := http.DefaultClient.Do(request)
+	response, err := client.httpClient().Do(request)
 	if err != nil {
-		return false
+		return nil, err
 	}
 	defer response.Body.Close()
 
-	if response.StatusCode != http.StatusOK {
-		return false
+	switch response.StatusCode {
+	case http.StatusOK:
+
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, ErrUnauthorized
+
+	default:
+		return nil, &StatusError{StatusCode: response.StatusCode}
 	}
 
-	j, err := ioutil.ReadAll(response.Body)
+	j, err := ioutil.ReadAll(io.LimitReader(response.Body, maxResponseSize))
 	if err != nil {
-		return false
+		return nil, err
 	}
 
 	var parsedResponse hostResponse
 	if err := json.Unmarshal(j, &parsedResponse); err != nil {
-		log.Printf("Failed to parse %s: %v", j, err)
-		return false
+		return nil, err
 	}
 
-	if parsedResponse.QueryStatus != "ok" {
-		return false
+	switch parsedResponse.QueryStatus {
+	case "ok":
+
+	case "no_results":
+		return nil, ErrNoResults
+
+	case "invalid_host":
+		return nil, ErrInvalidHost
+
+	case "unauthorized":
+		return nil, ErrUnauthorized
+
+	default:
+		return nil, &QueryError{QueryStatus: parsedResponse.QueryStatus}
 	}
 
-	for _, status := range parsedResponse.Blacklists {
+	var listings []string
+	for blacklist, status := range parsedResponse.Blacklists {
 		if status != "not listed" {
-			log.Println(msg.Domain, " is blocked by URLHaus")
-			return true
+			listings = append(listings, blacklist+"="+status)
 		}
 	}
 
-	return false
+	if len(listings) == 0 {
+		return nil, nil
+	}
+
+	sort.Strings(listings)
+	return &verdict.Verdict{Source: Source, Category: verdict.CategoryMalware, Rule: strings.Join(listings, ","), Confidence: 1}, nil
+}
+
+// isFailure determines whether or not an API error indicates that the API is
+// unavailable: unknown and invalid domains are answered normally.
+func isFailure(err error) bool {
+	return err != ErrNoResults && err != ErrInvalidHost
+}
+
+// lookup looks up a domain using the API, or returns the cached verdict.
+func (client *Client) lookup(ctx context.Context, domain string) *verdict.Verdict {
+	return client.Verdicts.Lookup(ctx, domain, func(ctx context.Context) (*verdict.Verdict, error) {
+		var v *verdict.Verdict
+
+		err := client.Breaker.Do(ctx, func(ctx context.Context) error {
+			var err error
+			v, err = client.Lookup(ctx, domain)
+			return err
+		})
+
+		// unknown and invalid domains are not listed, and looking them up
+		// again won't change that
+		if err == ErrNoResults || err == ErrInvalidHost {
+			return nil, nil
+		}
+
+		if err != nil {
+			log.Printf("Failed to look up %s using URLHaus: %v", domain, err)
+			return nil, err
+		}
+
+		if v != nil {
+			log.Println(domain, " is blocked by URLHaus")
+		}
+
+		return v, nil
+	})
 }
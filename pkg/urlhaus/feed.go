@@ -0,0 +1,61 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package urlhaus
+
+import (
+	"bytes"
+
+	"github.com/dimkr/dohli/pkg/blocklist"
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+// the rule of verdicts based on the host file
+const feedRule = "hostfile"
+
+// update replaces the host file.
+func (client *Client) update(data []byte) (int, error) {
+	rules, err := blocklist.Parse(blocklist.FormatHosts, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+
+	table := blocklist.NewTable(&blocklist.Artifact{Rules: blocklist.Compile(rules)}, blocklist.DefaultBloomBitsPerKey)
+
+	client.lock.Lock()
+	client.table = table
+	client.lock.Unlock()
+
+	return table.Len(), nil
+}
+
+// match checks whether or not a domain is listed in the host file.
+func (client *Client) match(domain string) *verdict.Verdict {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+
+	if client.table == nil || client.table.Match(domain) == "" {
+		return nil
+	}
+
+	return &verdict.Verdict{Source: Source, Category: verdict.CategoryMalware, Rule: feedRule, Confidence: 1}
+}
@@ -0,0 +1,132 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package urlhaus blocks domains listed by URLHaus.
+package urlhaus
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/blocklist"
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/circuit"
+	"github.com/dimkr/dohli/pkg/feed"
+	"github.com/dimkr/dohli/pkg/fetch"
+	"github.com/dimkr/dohli/pkg/queue"
+	"github.com/dimkr/dohli/pkg/verdict"
+)
+
+// Source is the source name in verdicts of Client.
+const Source = "urlhaus"
+
+const (
+	feedURL = "https://urlhaus.abuse.ch/downloads/hostfile/"
+
+	// URLHaus updates the host file every few minutes, but asks users not to
+	// download it too often
+	feedInterval = 30 * time.Minute
+
+	feedTimeout = 5 * time.Minute
+)
+
+// Client blocks domains listed in the URLHaus host file, which is downloaded
+// periodically and checked locally. Domains missing from the host file can be
+// looked up using the URLHaus API, if UseAPI is set.
+type Client struct {
+	// UseAPI enables lookup of domains missing from the host file, using the
+	// URLHaus API
+	UseAPI bool
+
+	// BaseURL is the API URL; the default is DefaultBaseURL
+	BaseURL string
+
+	// AuthKey is sent in the Auth-Key header of API requests
+	AuthKey string
+
+	// Timeout limits the duration of API requests; the default is 5 seconds
+	Timeout time.Duration
+
+	// HTTPClient sends API requests; the default is http.DefaultClient
+	HTTPClient *http.Client
+
+	// Verdicts caches API verdicts
+	Verdicts *verdict.Cache
+
+	// Breaker protects the API and the worker from each other
+	Breaker *circuit.Breaker
+
+	feed feed.Feed
+
+	lock  sync.RWMutex
+	table *blocklist.Table
+}
+
+// OpenClient returns a new URLHaus client; API verdicts are cached in c.
+func OpenClient(c *cache.Cache) *Client {
+	client := &Client{Verdicts: verdict.OpenCache(c, Source), Breaker: circuit.OpenBreaker(c, Source)}
+	client.Breaker.IsFailure = isFailure
+
+	client.feed = feed.Feed{
+		Source:   fetch.Source{Name: Source, URL: feedURL},
+		Interval: feedInterval,
+		Update:   client.update,
+		Cache:    c,
+	}
+
+	return client
+}
+
+// Connect starts downloading and refreshing the host file in the background.
+// Domains are not blocked by the host file until the first download succeeds.
+func (client *Client) Connect() error {
+	client.feed.Start(context.Background())
+	return nil
+}
+
+// IsAsync returns true if the client uses the API, because the host file is
+// checked locally.
+func (client *Client) IsAsync() bool {
+	return client.UseAPI
+}
+
+// Reload downloads the host file again.
+func (client *Client) Reload() error {
+	ctx, cancel := context.WithTimeout(context.Background(), feedTimeout)
+	defer cancel()
+
+	return client.feed.Refresh(ctx)
+}
+
+func (client *Client) IsBad(ctx context.Context, msg *queue.DomainAccessMessage) *verdict.Verdict {
+	if v := client.match(msg.Domain); v != nil {
+		return v
+	}
+
+	if client.UseAPI {
+		return client.lookup(ctx, msg.Domain)
+	}
+
+	return nil
+}
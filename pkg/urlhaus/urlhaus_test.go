@@ -0,0 +1,262 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package urlhaus
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/cache"
+	"github.com/dimkr/dohli/pkg/circuit"
+	"github.com/dimkr/dohli/pkg/queue"
+)
+
+const hostFile = `################################################################
+# abuse.ch URLhaus Host file                                   #
+################################################################
+127.0.0.1	malware.example.com
+127.0.0.1	1.2.3.4
+127.0.0.1	Payload.Example.NET
+`
+
+const authKey = "secret"
+
+func openClient(t *testing.T, useAPI bool) (*Client, *int32, func()) {
+	var queries int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hostfile", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(hostFile))
+	})
+	mux.HandleFunc("/v1/host/", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&queries, 1)
+
+		if r.Method != http.MethodPost || r.Header.Get("Auth-Key") != authKey {
+			w.Write([]byte(`{"query_status":"unauthorized"}`))
+			return
+		}
+
+		switch r.FormValue("host") {
+		case "api.example.com":
+			w.Write([]byte(`{"query_status":"ok","blacklists":{"spamhaus_dbl":"abused_legit_malware","surbl":"not listed"}}`))
+
+		case "broken.example.com":
+			w.WriteHeader(http.StatusInternalServerError)
+
+		case "invalid.example.com":
+			w.Write([]byte(`{"query_status":"invalid_host"}`))
+
+		case "new.example.com":
+			w.Write([]byte(`{"query_status":"http_post_expected"}`))
+
+		case "slow.example.com":
+			time.Sleep(time.Second)
+
+		case "clean.example.com":
+			w.Write([]byte(`{"query_status":"ok","blacklists":{"spamhaus_dbl":"not listed","surbl":"not listed"}}`))
+
+		default:
+			w.Write([]byte(`{"query_status":"no_results"}`))
+		}
+	})
+
+	server := httptest.NewServer(mux)
+
+	c, err := cache.OpenCache(&cache.MemoryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := OpenClient(c)
+	client.UseAPI = useAPI
+	client.BaseURL = server.URL + "/"
+	client.AuthKey = authKey
+	client.Timeout = 100 * time.Millisecond
+	client.feed.Source.URL = server.URL + "/hostfile"
+
+	if err := client.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	return client, &queries, server.Close
+}
+
+func TestHostFile(t *testing.T) {
+	client, queries, done := openClient(t, false)
+	defer done()
+
+	if client.IsAsync() {
+		t.Error("client without API is async")
+	}
+
+	for domain, bad := range map[string]bool{
+		"malware.example.com":     true,
+		"payload.example.net":     true,
+		"www.malware.example.com": false,
+		"api.example.com":         false,
+	} {
+		v := client.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: domain})
+		if (v != nil) != bad {
+			t.Error(domain, v)
+		} else if v != nil && (v.Source != Source || v.Rule != feedRule) {
+			t.Error(domain, v)
+		}
+	}
+
+	if *queries != 0 {
+		t.Error(*queries)
+	}
+}
+
+func TestAPIFallback(t *testing.T) {
+	client, queries, done := openClient(t, true)
+	defer done()
+
+	for domain, rule := range map[string]string{
+		"malware.example.com": feedRule,
+		"api.example.com":     "spamhaus_dbl=abused_legit_malware",
+		"good.example.com":    "",
+		"broken.example.com":  "",
+	} {
+		v := client.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: domain})
+		if (rule == "" && v != nil) || (rule != "" && (v == nil || v.Rule != rule)) {
+			t.Error(domain, v)
+		}
+	}
+
+	if atomic.LoadInt32(queries) != 3 {
+		t.Error(*queries)
+	}
+}
+
+func TestAPICache(t *testing.T) {
+	client, queries, done := openClient(t, true)
+	defer done()
+
+	for _, domain := range []string{"api.example.com", "api.example.com", "good.example.com", "good.example.com", "broken.example.com", "broken.example.com"} {
+		client.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: domain})
+	}
+
+	// failed lookups are not cached
+	if atomic.LoadInt32(queries) != 4 {
+		t.Error(*queries)
+	}
+
+	if v := client.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "api.example.com"}); v == nil {
+		t.Error("cached verdict was lost")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	client, _, done := openClient(t, true)
+	defer done()
+
+	for domain, expected := range map[string]error{
+		"good.example.com":    ErrNoResults,
+		"invalid.example.com": ErrInvalidHost,
+		"clean.example.com":   nil,
+	} {
+		if v, err := client.Lookup(context.Background(), domain); err != expected || v != nil {
+			t.Error(domain, v, err)
+		}
+	}
+
+	var statusErr *StatusError
+	if _, err := client.Lookup(context.Background(), "broken.example.com"); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusInternalServerError {
+		t.Error(err)
+	}
+
+	var queryErr *QueryError
+	if _, err := client.Lookup(context.Background(), "new.example.com"); !errors.As(err, &queryErr) || queryErr.QueryStatus != "http_post_expected" {
+		t.Error(err)
+	}
+
+	if _, err := client.Lookup(context.Background(), "slow.example.com"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error(err)
+	}
+
+	client.AuthKey = ""
+	if _, err := client.Lookup(context.Background(), "api.example.com"); err != ErrUnauthorized {
+		t.Error(err)
+	}
+}
+
+type countingTransport struct {
+	requests int
+}
+
+func (transport *countingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	transport.requests++
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+func TestHTTPClient(t *testing.T) {
+	client, _, done := openClient(t, true)
+	defer done()
+
+	transport := countingTransport{}
+	client.HTTPClient = &http.Client{Transport: &transport}
+
+	if v, err := client.Lookup(context.Background(), "api.example.com"); err != nil || v == nil {
+		t.Error(v, err)
+	}
+
+	if transport.requests != 1 {
+		t.Error(transport.requests)
+	}
+}
+
+func TestCircuit(t *testing.T) {
+	client, queries, done := openClient(t, true)
+	defer done()
+
+	client.Breaker.MaxFailures = 1
+
+	if v := client.IsBad(context.Background(), &queue.DomainAccessMessage{Domain: "broken.example.com"}); v != nil {
+		t.Error(v)
+	}
+
+	ctx, skipped := circuit.WithSkipped(context.Background())
+
+	// the API is not called while the circuit is open, and the result is not
+	// cached
+	for i := 0; i < 2; i++ {
+		if v := client.IsBad(ctx, &queue.DomainAccessMessage{Domain: "api.example.com"}); v != nil {
+			t.Error(v)
+		}
+	}
+
+	if atomic.LoadInt32(queries) != 1 || skipped.Len() != 2 {
+		t.Error(*queries, skipped.Len())
+	}
+
+	// the host file is still checked
+	if v := client.IsBad(ctx, &queue.DomainAccessMessage{Domain: "malware.example.com"}); v == nil {
+		t.Error("listed domain is not blocked")
+	}
+}
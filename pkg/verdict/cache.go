@@ -0,0 +1,115 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package verdict
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/dimkr/dohli/pkg/cache"
+)
+
+const (
+	cacheKeyPrefix = "verdict:"
+
+	// DefaultPositiveTTL is the default caching duration of verdicts that
+	// block a domain, in seconds.
+	DefaultPositiveTTL = 24 * 60 * 60
+
+	// DefaultNegativeTTL is the default caching duration of clean results, in
+	// seconds.
+	DefaultNegativeTTL = 6 * 60 * 60
+)
+
+// cachedVerdict is a cached verdict; Verdict is nil if the domain is clean.
+type cachedVerdict struct {
+	Verdict *Verdict `json:"verdict"`
+}
+
+// Cache caches the verdicts of a blocker, so all workers share the results of
+// slow or rate-limited checks.
+type Cache struct {
+	// PositiveTTL is the caching duration of verdicts that block a domain,
+	// in seconds
+	PositiveTTL int
+
+	// NegativeTTL is the caching duration of clean results, in seconds
+	NegativeTTL int
+
+	cache  *cache.Cache
+	prefix string
+}
+
+// OpenCache returns a verdict cache for a source of verdicts.
+func OpenCache(c *cache.Cache, source string) *Cache {
+	return &Cache{
+		PositiveTTL: DefaultPositiveTTL,
+		NegativeTTL: DefaultNegativeTTL,
+		cache:       c,
+		prefix:      cacheKeyPrefix + source + ":",
+	}
+}
+
+// Get returns the cached verdict of a key, usually a domain. The boolean is
+// false if there is no cached result.
+func (vc *Cache) Get(ctx context.Context, key string) (*Verdict, bool) {
+	j := vc.cache.Backend(ctx).Get(vc.prefix + key)
+	if j == nil {
+		return nil, false
+	}
+
+	var cached cachedVerdict
+	if err := json.Unmarshal(j, &cached); err != nil {
+		return nil, false
+	}
+
+	return cached.Verdict, true
+}
+
+// Set caches a verdict; v is nil if the domain is clean.
+func (vc *Cache) Set(ctx context.Context, key string, v *Verdict) {
+	ttl := vc.NegativeTTL
+	if v != nil {
+		ttl = vc.PositiveTTL
+	}
+
+	if j, err := json.Marshal(cachedVerdict{Verdict: v}); err == nil {
+		vc.cache.Backend(ctx).Set(vc.prefix+key, j, ttl)
+	}
+}
+
+// Lookup returns the cached verdict of a key, or calls check and caches its
+// result. Failed checks are not cached.
+func (vc *Cache) Lookup(ctx context.Context, key string, check func(context.Context) (*Verdict, error)) *Verdict {
+	if v, ok := vc.Get(ctx, key); ok {
+		return v
+	}
+
+	v, err := check(ctx)
+	if err != nil {
+		return nil
+	}
+
+	vc.Set(ctx, key, v)
+	return v
+}
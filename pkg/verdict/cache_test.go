@@ -0,0 +1,104 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package verdict
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dimkr/dohli/pkg/cache"
+)
+
+func openCaches(t *testing.T) (*Cache, *Cache) {
+	c, err := cache.OpenCache(&cache.MemoryBackend{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return OpenCache(c, "a"), OpenCache(c, "b")
+}
+
+func TestCacheLookup(t *testing.T) {
+	vc, other := openCaches(t)
+
+	checks := map[string]int{}
+	check := func(domain string) func(context.Context) (*Verdict, error) {
+		return func(context.Context) (*Verdict, error) {
+			checks[domain]++
+
+			switch domain {
+			case "bad.com":
+				return &Verdict{Source: "a", Category: CategoryMalware, Confidence: 1}, nil
+
+			case "broken.com":
+				return nil, errors.New("lookup failed")
+			}
+
+			return nil, nil
+		}
+	}
+
+	for _, domain := range []string{"bad.com", "bad.com", "good.com", "good.com", "broken.com", "broken.com"} {
+		v := vc.Lookup(context.Background(), domain, check(domain))
+		if (domain == "bad.com") != (v != nil) {
+			t.Error(domain, v)
+		}
+	}
+
+	// failed checks are not cached
+	if checks["bad.com"] != 1 || checks["good.com"] != 1 || checks["broken.com"] != 2 {
+		t.Error(checks)
+	}
+
+	if v, ok := vc.Get(context.Background(), "bad.com"); !ok || v.Category != CategoryMalware {
+		t.Error(v, ok)
+	}
+
+	if v, ok := vc.Get(context.Background(), "good.com"); !ok || v != nil {
+		t.Error(v, ok)
+	}
+
+	if _, ok := other.Get(context.Background(), "bad.com"); ok {
+		t.Error("verdicts of different sources are shared")
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	vc, _ := openCaches(t)
+	vc.NegativeTTL = 1
+
+	vc.Set(context.Background(), "bad.com", &Verdict{Source: "a"})
+	vc.Set(context.Background(), "good.com", nil)
+
+	time.Sleep(2 * time.Second)
+
+	if _, ok := vc.Get(context.Background(), "bad.com"); !ok {
+		t.Error("verdict has expired")
+	}
+
+	if _, ok := vc.Get(context.Background(), "good.com"); ok {
+		t.Error("clean result has not expired")
+	}
+}
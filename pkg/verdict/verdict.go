@@ -0,0 +1,85 @@
+// this file is part of dohli.
+//
+// Copyright (c) 2020 Dima Krasner
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package verdict describes the decisions of blockers.
+package verdict
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Category is the kind of threat or nuisance a domain is associated with.
+type Category string
+
+const (
+	CategoryAds      Category = "ads"
+	CategoryTracking Category = "tracking"
+	CategoryMalware  Category = "malware"
+	CategoryPhishing Category = "phishing"
+
+	// CategoryTunneling is the category of domains used to tunnel traffic
+	// or exfiltrate data over DNS.
+	CategoryTunneling Category = "tunneling"
+
+	// CategoryNewDomain is the category of domains first seen recently.
+	CategoryNewDomain Category = "new-domain"
+)
+
+// Verdict explains why a blocker considers a domain bad.
+type Verdict struct {
+	// Source is the name of the blocker or list that produced the verdict.
+	Source string `json:"source"`
+
+	Category Category `json:"category,omitempty"`
+
+	// Rule is the list entry or rule that matched the domain.
+	Rule string `json:"rule,omitempty"`
+
+	// Confidence is a score between 0 and 1.
+	Confidence float64 `json:"confidence"`
+
+	// Expires is the time the verdict stops being true, or nil.
+	Expires *time.Time `json:"expires,omitempty"`
+
+	// Zone is set when the verdict applies to a domain and all its
+	// subdomains.
+	Zone string `json:"zone,omitempty"`
+}
+
+// String returns a short, human-readable explanation of the verdict.
+func (v *Verdict) String() string {
+	var b strings.Builder
+
+	b.WriteString(v.Source)
+
+	if v.Category != "" {
+		fmt.Fprintf(&b, " (%s)", v.Category)
+	}
+
+	if v.Rule != "" {
+		fmt.Fprintf(&b, ": %s", v.Rule)
+	}
+
+	return b.String()
+}